@@ -5,21 +5,129 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
+// DEFAULT_MAX_MSG_LENGTH is the largest accepted Header.Size when it is not configured
+// the biggest known msg is 32 bytes, anything much larger is treated as a corrupted stream
+const DEFAULT_MAX_MSG_LENGTH = 1024
+
+// DEFAULT_BAR_SIZE is the number of seq in a bar when neither the bar size nor interval is configured
+const DEFAULT_BAR_SIZE = 1000
+
+// DEFAULT_QUEUE_SIZE is the number of items a queue between two components holds when it is not configured
+const DEFAULT_QUEUE_SIZE = 1024
+
+// DEFAULT_DEPTH is the number of printed levels when neither the symbol nor OrderBook.Depth configures it
+const DEFAULT_DEPTH = 3
+
+// DEFAULT_STREAM_MAX_DEPTH is the deepest depth a WebSocket client can subscribe to when it is not configured
+const DEFAULT_STREAM_MAX_DEPTH = 20
+
 type Config struct {
 	App struct {
 		Id      string `mapstructure:"id"`
 		Version string `mapstructure:"version"`
 	} `mapstructure:"app"`
 	Stream struct {
-		HeaderLength int64 `mapstructure:"headerLength"` // header length of the expected msg
+		Protocol     string        `mapstructure:"protocol"`     // native or itch, native by default
+		HeaderLength int64         `mapstructure:"headerLength"` // header length of the expected msg
+		MaxMsgLength int64         `mapstructure:"maxMsgLength"` // largest accepted Header.Size, 0 means DEFAULT_MAX_MSG_LENGTH
+		Schema       *SchemaConfig `mapstructure:"schema"`       // wire layout of the header and msg, nil means the layout of the sample captures
+		Session      SessionConfig `mapstructure:"session"`      // session layer that carries the msg, the msg are read from stdin when not set
+		Pcap         struct {
+			Destination string `mapstructure:"destination"` // host:port the feed is sent to, stdin is read as a pcap or pcapng capture when set
+		} `mapstructure:"pcap"`
 	} `mapstructure:"stream"`
 	OrderBook struct {
-		Depth int // depth of the printed market depth
+		Depth      int            `mapstructure:"depth"`      // default depth of the printed market depth
+		PriceGroup int64          `mapstructure:"priceGroup"` // price increment that the printed levels are grouped into, 0 means the exact prices
+		Scale      int            `mapstructure:"scale"`      // decimal places of the raw prices e.g. 4 prints 318800 as 31.8800
+		TickSize   int64          `mapstructure:"tickSize"`   // raw prices must be a multiple of it, 0 means they are not validated
+		Symbols    []SymbolConfig `mapstructure:"symbols"`    // per symbol overrides of the defaults above
+	} `mapstructure:"orderBook"`
+	Bars struct {
+		Size     uint32        `mapstructure:"size"`     // number of seq in a bar, used when Interval is 0. 0 means DEFAULT_BAR_SIZE
+		Interval time.Duration `mapstructure:"interval"` // duration of a bar e.g. 1m, of the capture time of the msg or the processing time when the msg has none
+		Format   string        `mapstructure:"format"`   // csv or json, csv by default
+	} `mapstructure:"bars"`
+	Signals struct {
+		Depth    int   `mapstructure:"depth"`    // levels used for the imbalance, 0 means the depth of the symbol
+		TickSize int64 `mapstructure:"tickSize"` // price increment for the spread in ticks of the symbols without orderBook tickSize, 0 means 1
+	} `mapstructure:"signals"`
+	Queues struct {
+		Stream  QueueConfig `mapstructure:"stream"`  // decoded msg waiting for the order book, every msg changes the book so it can only block
+		Print   QueueConfig `mapstructure:"print"`   // market depth lines waiting for the output
+		Bbo     QueueConfig `mapstructure:"bbo"`     // BBO lines waiting for their output
+		Trades  QueueConfig `mapstructure:"trades"`  // trade lines waiting for their output, every trade is printed so it can only block
+		Bars    QueueConfig `mapstructure:"bars"`    // bars waiting for their output, every bar is printed so it can only block
+		Signals QueueConfig `mapstructure:"signals"` // signal lines waiting for their output
+	} `mapstructure:"queues"`
+	Http struct {
+		MaxDepth int `mapstructure:"maxDepth"` // deepest depth a WebSocket client can subscribe to, 0 means DEFAULT_STREAM_MAX_DEPTH
+	} `mapstructure:"http"`
+}
+
+// SymbolConfig is the config of a single symbol, zero values fall back to the OrderBook defaults
+type SymbolConfig struct {
+	Symbol   string `mapstructure:"symbol"`   // e.g. VC0
+	Depth    int    `mapstructure:"depth"`    // depth of the printed market depth for this symbol
+	Scale    int    `mapstructure:"scale"`    // decimal places of the raw prices of this symbol
+	TickSize int64  `mapstructure:"tickSize"` // price increment of this symbol
+}
+
+// QueueConfig is the size of a queue between two components and what happens when it is full
+type QueueConfig struct {
+	Size   int    `mapstructure:"size"`   // items the queue holds, 0 means DEFAULT_QUEUE_SIZE
+	Policy string `mapstructure:"policy"` // block, drop-oldest or conflate. block by default
+}
+
+// Capacity returns the items the queue holds, falling back to DEFAULT_QUEUE_SIZE when not configured
+func (q QueueConfig) Capacity() int {
+	if q.Size <= 0 {
+		return DEFAULT_QUEUE_SIZE
 	}
+	return q.Size
+}
+
+// SessionConfig is the session layer protocol that carries the msg of the stream
+type SessionConfig struct {
+	Protocol          string        `mapstructure:"protocol"`          // soupbintcp or moldudp64
+	Address           string        `mapstructure:"address"`           // SoupBinTCP server, or the address the MoldUDP64 packets are sent to
+	RequestAddress    string        `mapstructure:"requestAddress"`    // MoldUDP64 retransmission server, gaps are not requested when empty
+	Username          string        `mapstructure:"username"`          // SoupBinTCP login
+	Password          string        `mapstructure:"password"`          // SoupBinTCP login
+	Session           string        `mapstructure:"session"`           // SoupBinTCP session to log into, blank means the current one
+	Heartbeat         time.Duration `mapstructure:"heartbeat"`         // SoupBinTCP client heartbeat interval, 1s by default
+	Reconnects        int           `mapstructure:"reconnects"`        // times the SoupBinTCP connection is logged into again after it drops
+	RetransmitTimeout time.Duration `mapstructure:"retransmitTimeout"` // wait before repeating a MoldUDP64 retransmission request or skipping a gap, 1s by default
+	FirstSeq          uint64        `mapstructure:"firstSeq"`          // first MoldUDP64 msg to deliver, 0 means the seq of the first packet received
+}
+
+// SchemaConfig declares the wire layout of the header and of each msg type
+type SchemaConfig struct {
+	Endian   string          `mapstructure:"endian"`   // byte order of the numbers, little or big. little by default
+	Fill     string          `mapstructure:"fill"`     // byte written into the unused bytes and symbol padding when encoding, a space by default
+	Header   []FieldConfig   `mapstructure:"header"`   // seq and size fields, offsets are from the start of the header
+	Messages []MessageConfig `mapstructure:"messages"` // layout of each msg type
+}
+
+// MessageConfig is the layout of the body of a msg type
+type MessageConfig struct {
+	Type   string        `mapstructure:"type"`   // msg type byte e.g. A
+	Length int           `mapstructure:"length"` // length of the body after the msg type byte
+	Fields []FieldConfig `mapstructure:"fields"` // offsets are from the byte after the msg type
+}
+
+// FieldConfig is the position of a single field
+type FieldConfig struct {
+	Name   string `mapstructure:"name"`   // e.g. symbol, orderId, side, size, price, tradedQty, or seq and size in the header
+	Offset int    `mapstructure:"offset"` // byte offset of the field
+	Width  int    `mapstructure:"width"`  // number of bytes, 1 to 8 for numbers and up to 8 for symbols
+	Endian string `mapstructure:"endian"` // overrides the schema endian for this field
 }
 
 func NewConfig() *Config {
@@ -43,3 +151,86 @@ func NewConfig() *Config {
 
 	return &config
 }
+
+// MaxMsgLength returns the largest accepted Header.Size, falling back to DEFAULT_MAX_MSG_LENGTH when not configured
+func (c *Config) MaxMsgLength() int64 {
+	if c.Stream.MaxMsgLength <= 0 {
+		return DEFAULT_MAX_MSG_LENGTH
+	}
+	return c.Stream.MaxMsgLength
+}
+
+// BarSize returns the number of seq in a bar, falling back to DEFAULT_BAR_SIZE when not configured
+func (c *Config) BarSize() uint32 {
+	if c.Bars.Size == 0 {
+		return DEFAULT_BAR_SIZE
+	}
+	return c.Bars.Size
+}
+
+// StreamMaxDepth returns the deepest depth a WebSocket client can subscribe to, falling back to DEFAULT_STREAM_MAX_DEPTH when not configured
+func (c *Config) StreamMaxDepth() int {
+	if c.Http.MaxDepth <= 0 {
+		return DEFAULT_STREAM_MAX_DEPTH
+	}
+	return c.Http.MaxDepth
+}
+
+// SignalTickSize returns the price increment for the spread in ticks, falling back to 1 when not configured
+func (c *Config) SignalTickSize() int64 {
+	if c.Signals.TickSize <= 0 {
+		return 1
+	}
+	return c.Signals.TickSize
+}
+
+// DefaultDepth returns OrderBook.Depth, falling back to DEFAULT_DEPTH when not configured
+func (c *Config) DefaultDepth() int {
+	if c.OrderBook.Depth <= 0 {
+		return DEFAULT_DEPTH
+	}
+	return c.OrderBook.Depth
+}
+
+// SymbolDepth returns the configured depth of the symbol, falling back to DefaultDepth
+func (c *Config) SymbolDepth(symbol string) int {
+	for _, symbolConfig := range c.OrderBook.Symbols {
+		if symbolConfig.Symbol == symbol && symbolConfig.Depth > 0 {
+			return symbolConfig.Depth
+		}
+	}
+	return c.DefaultDepth()
+}
+
+// SymbolScale returns the configured decimal places of the prices of the symbol, falling back to OrderBook.Scale
+func (c *Config) SymbolScale(symbol string) int {
+	for _, symbolConfig := range c.OrderBook.Symbols {
+		if symbolConfig.Symbol == symbol && symbolConfig.Scale > 0 {
+			return symbolConfig.Scale
+		}
+	}
+	return c.OrderBook.Scale
+}
+
+// SymbolTickSize returns the configured price increment of the symbol, falling back to OrderBook.TickSize
+func (c *Config) SymbolTickSize(symbol string) int64 {
+	for _, symbolConfig := range c.OrderBook.Symbols {
+		if symbolConfig.Symbol == symbol && symbolConfig.TickSize > 0 {
+			return symbolConfig.TickSize
+		}
+	}
+	return c.OrderBook.TickSize
+}
+
+// OnChange calls fn with the newly loaded config every time the config file is modified
+func OnChange(fn func(*Config)) {
+	viper.OnConfigChange(func(event fsnotify.Event) {
+		var config Config
+		if err := viper.Unmarshal(&config); err != nil {
+			log.Printf("unable to reload config: %s \n", err.Error())
+			return
+		}
+		fn(&config)
+	})
+	viper.WatchConfig()
+}
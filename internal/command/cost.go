@@ -0,0 +1,138 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/pkg/orderbook"
+)
+
+// costRecord is the printed cost to fill a single qty
+type costRecord struct {
+	Seq        uint32  `json:"seq"` // seq of the last msg applied to the book
+	Symbol     string  `json:"symbol"`
+	Side       string  `json:"side"`
+	Qty        uint64  `json:"qty"`    // requested qty
+	Filled     uint64  `json:"filled"` // qty that the book can fill
+	AvgPrice   float64 `json:"avgPrice"`
+	WorstPrice int64   `json:"worstPrice"`
+	Levels     int     `json:"levels"`
+	Mid        float64 `json:"mid"`
+	Slippage   float64 `json:"slippage"`
+	Scale      int     `json:"scale"` // decimal places of the prices, the json prices are raw
+}
+
+// RunCost replays a capture and prints what an order of the side would pay to fill each qty at once
+// e.g. order_book cost -input input2.stream -symbol VC0 -side B -qty 100,1000 -seq 20000
+func RunCost(config *config.Config, args []string) error {
+	flags := flag.NewFlagSet("cost", flag.ContinueOnError)
+	inputParam := flags.String("input", "", "the capture file to replay, defaults to stdin")
+	symbolParam := flags.String("symbol", "", "symbol of the order")
+	sideParam := flags.String("side", "B", "side of the order, B consumes the sell levels and S the buy levels")
+	qtyParam := flags.String("qty", "", "comma separated qty of the order")
+	seqParam := flags.Uint("seq", 0, "last seq applied to the book before calculating, 0 means the whole capture")
+	jsonParam := flags.Bool("json", false, "print each qty as a JSON line")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *symbolParam == "" || *qtyParam == "" {
+		return fmt.Errorf("-symbol and -qty are required")
+	}
+	if len(*sideParam) != 1 || ((*sideParam)[0] != orderbook.SIDE_BUY && (*sideParam)[0] != orderbook.SIDE_SELL) {
+		return fmt.Errorf("-side must be B or S, received %s", *sideParam)
+	}
+	qtys := make([]uint64, 0)
+	for _, value := range strings.Split(*qtyParam, ",") {
+		qty, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid -qty %s: %w", value, err)
+		}
+		qtys = append(qtys, qty)
+	}
+
+	input := io.Reader(os.Stdin)
+	if *inputParam != "" {
+		f, err := os.Open(*inputParam)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+	return cost(config, input, os.Stdout, *symbolParam, (*sideParam)[0], qtys, uint32(*seqParam), *jsonParam)
+}
+
+// cost applies the input to a book until seq and writes the cost to fill each qty into output
+func cost(config *config.Config, input io.Reader, output io.Writer, symbol string, side byte, qtys []uint64, seq uint32, asJson bool) error {
+	engine := orderbook.NewEngine(config)
+	decoder := orderbook.NewDecoder(config, input)
+	var lastSeq uint32
+	for {
+		msg, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if seq != 0 && msg.MsgHeader.Seq > seq {
+			break
+		}
+		if err = engine.Apply(msg); err != nil {
+			return fmt.Errorf("unable to apply seq %d: %w", msg.MsgHeader.Seq, err)
+		}
+		lastSeq = msg.MsgHeader.Seq
+	}
+
+	encoder := json.NewEncoder(output)
+	for _, qty := range qtys {
+		fill, err := engine.CostToFill(orderbook.NewSymbol(symbol), side, qty)
+		if err != nil {
+			return err
+		}
+		record := newCostRecord(lastSeq, symbol, side, qty, fill)
+		record.Scale = engine.Scale(orderbook.NewSymbol(symbol))
+		if asJson {
+			err = encoder.Encode(record)
+		} else {
+			_, err = fmt.Fprintln(output, record.String())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newCostRecord flattens the fill into a costRecord
+func newCostRecord(seq uint32, symbol string, side byte, qty uint64, fill db.Fill) costRecord {
+	return costRecord{
+		Seq:        seq,
+		Symbol:     symbol,
+		Side:       string(side),
+		Qty:        qty,
+		Filled:     fill.Qty,
+		AvgPrice:   fill.AvgPrice,
+		WorstPrice: fill.WorstPrice,
+		Levels:     fill.Levels,
+		Mid:        fill.Mid,
+		Slippage:   fill.Slippage,
+	}
+}
+
+// String returns the human readable form of the record
+// prices are printed with the scale of the symbol plus 4 decimal places for the averages
+// e.g. 20000, VC0, B, qty=1000, filled=1000, avg=318912.5000, worst=319000, levels=3, mid=318850.0000, slippage=62.5000
+func (r costRecord) String() string {
+	format := func(value float64) string { return strconv.FormatFloat(value/math.Pow10(r.Scale), 'f', r.Scale+4, 64) }
+	return fmt.Sprintf("%d, %s, %s, qty=%d, filled=%d, avg=%s, worst=%s, levels=%d, mid=%s, slippage=%s", r.Seq, r.Symbol, r.Side,
+		r.Qty, r.Filled, format(r.AvgPrice), orderbook.FormatPrice(r.WorstPrice, r.Scale), r.Levels, format(r.Mid), format(r.Slippage))
+}
@@ -0,0 +1,44 @@
+package command
+
+import (
+	"bytes"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cost", func() {
+	config := &config.Config{}
+	config.Stream.HeaderLength = 8
+	symbol := message.NewSymbol("ABC")
+	var capture bytes.Buffer
+	var output bytes.Buffer
+
+	BeforeEach(func() {
+		capture.Reset()
+		output.Reset()
+		stream_handler.WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_ADDED, MsgHeader: message.Header{Seq: 1}, MsgBody: message.MessageAdded{Symbol: symbol, OrderId: 1, Side: [1]byte{message.SIDE_BUY}, Size: 10, Price: 98}})
+		stream_handler.WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_ADDED, MsgHeader: message.Header{Seq: 2}, MsgBody: message.MessageAdded{Symbol: symbol, OrderId: 2, Side: [1]byte{message.SIDE_SELL}, Size: 10, Price: 102}})
+		stream_handler.WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_ADDED, MsgHeader: message.Header{Seq: 3}, MsgBody: message.MessageAdded{Symbol: symbol, OrderId: 3, Side: [1]byte{message.SIDE_SELL}, Size: 10, Price: 104}})
+	})
+
+	Context("with the whole capture", func() {
+		It("should print the cost of every qty", func() {
+			err := cost(config, &capture, &output, "ABC", message.SIDE_BUY, []uint64{5, 20}, 0, false)
+			Expect(err).To(BeNil())
+			Expect(output.String()).To(Equal("3, ABC, B, qty=5, filled=5, avg=102.0000, worst=102, levels=1, mid=100.0000, slippage=2.0000\n" +
+				"3, ABC, B, qty=20, filled=20, avg=103.0000, worst=104, levels=2, mid=100.0000, slippage=3.0000\n"))
+		})
+	})
+
+	Context("until a seq", func() {
+		It("should only apply the msg up to the seq", func() {
+			err := cost(config, &capture, &output, "ABC", message.SIDE_BUY, []uint64{20}, 2, true)
+			Expect(err).To(BeNil())
+			Expect(output.String()).To(Equal(`{"seq":2,"symbol":"ABC","side":"B","qty":20,"filled":10,"avgPrice":102,"worstPrice":102,"levels":1,"mid":100,"slippage":2,"scale":0}` + "\n"))
+		})
+	})
+})
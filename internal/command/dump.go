@@ -0,0 +1,232 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+)
+
+// dumpRecord is the human readable form of a single decoded frame
+type dumpRecord struct {
+	Seq     uint32 `json:"seq"`
+	Type    string `json:"type"`
+	Symbol  string `json:"symbol"`
+	OrderId uint64 `json:"orderId"`
+	Side    string `json:"side"`
+	Size    uint64 `json:"size"`
+	Price   *int64 `json:"price,omitempty"` // only present for added and updated msg
+}
+
+// dumpFilter decides which records are printed, zero values mean no filtering
+type dumpFilter struct {
+	symbols  map[string]bool
+	orderId  uint64
+	msgTypes map[string]bool
+	fromSeq  uint32
+	toSeq    uint32
+}
+
+// match returns whether the record passes the filter
+func (f *dumpFilter) match(record dumpRecord) bool {
+	if len(f.symbols) > 0 && !f.symbols[record.Symbol] {
+		return false
+	}
+	if f.orderId != 0 && f.orderId != record.OrderId {
+		return false
+	}
+	if len(f.msgTypes) > 0 && !f.msgTypes[record.Type] {
+		return false
+	}
+	if record.Seq < f.fromSeq || (f.toSeq != 0 && record.Seq > f.toSeq) {
+		return false
+	}
+	return true
+}
+
+// dumpSummary counts the printed records
+type dumpSummary struct {
+	Total   int                       `json:"total"`
+	Types   map[string]int            `json:"types"`
+	Symbols map[string]map[string]int `json:"symbols"` // per symbol count of each msg type
+}
+
+// add counts the record into the summary
+func (s *dumpSummary) add(record dumpRecord) {
+	s.Total++
+	s.Types[record.Type]++
+	if _, ok := s.Symbols[record.Symbol]; !ok {
+		s.Symbols[record.Symbol] = make(map[string]int)
+	}
+	s.Symbols[record.Symbol][record.Type]++
+}
+
+// RunDump decodes every frame of a capture and prints it in human readable or JSON form
+// e.g. order_book dump -input input2.stream -symbol VC0 -type A,E -from 100 -to 200
+func RunDump(config *config.Config, args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	inputParam := flags.String("input", "", "the capture file to dump, defaults to stdin")
+	symbolParam := flags.String("symbol", "", "comma separated symbols to print")
+	orderIdParam := flags.Uint64("order", 0, "only print msg for this OrderId")
+	typeParam := flags.String("type", "", "comma separated msg types to print e.g. A,U,D,E")
+	fromParam := flags.Uint("from", 0, "first seq to print")
+	toParam := flags.Uint("to", 0, "last seq to print, 0 means until the end")
+	jsonParam := flags.Bool("json", false, "print each msg as a JSON line")
+	summaryParam := flags.Bool("summary", false, "only print the per type and per symbol counts")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *fromParam > math.MaxUint32 || *toParam > math.MaxUint32 {
+		return fmt.Errorf("-from and -to must be at most %d, the seq is 4 bytes", uint32(math.MaxUint32))
+	}
+
+	input := io.Reader(os.Stdin)
+	if *inputParam != "" {
+		f, err := os.Open(*inputParam)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+	filter := &dumpFilter{
+		symbols:  splitSet(*symbolParam),
+		orderId:  *orderIdParam,
+		msgTypes: splitSet(*typeParam),
+		fromSeq:  uint32(*fromParam),
+		toSeq:    uint32(*toParam),
+	}
+	return dump(config, input, os.Stdout, filter, *jsonParam, *summaryParam)
+}
+
+// dump writes the records of the input that pass the filter into output
+func dump(config *config.Config, input io.Reader, output io.Writer, filter *dumpFilter, asJson bool, summaryOnly bool) error {
+	summary := &dumpSummary{
+		Types:   make(map[string]int),
+		Symbols: make(map[string]map[string]int),
+	}
+	encoder := json.NewEncoder(output)
+	frameReader := stream_handler.NewFrameReader(config, input)
+	for {
+		frame, err := frameReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read frame: %w", err)
+		}
+		msg, err := frameReader.Parse(frame)
+		if err != nil {
+			return fmt.Errorf("unable to parse seq %d: %w", frame.Header.Seq, err)
+		}
+		record := newDumpRecord(msg)
+		if !filter.match(record) {
+			continue
+		}
+		summary.add(record)
+		if summaryOnly {
+			continue
+		}
+		if asJson {
+			err = encoder.Encode(record)
+		} else {
+			_, err = fmt.Fprintln(output, record.String())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if summaryOnly {
+		if asJson {
+			return encoder.Encode(summary)
+		}
+		_, err := fmt.Fprint(output, summary.String())
+		return err
+	}
+	return nil
+}
+
+// newDumpRecord flattens the decoded msg into a dumpRecord
+func newDumpRecord(msg message.Message) dumpRecord {
+	record := dumpRecord{
+		Seq:    msg.MsgHeader.Seq,
+		Type:   msg.MsgType,
+		Symbol: msg.Symbol.String(),
+	}
+	switch body := msg.MsgBody.(type) {
+	case message.MessageAdded:
+		record.OrderId, record.Side, record.Size, record.Price = body.OrderId, string(body.Side[:]), body.Size, &body.Price
+	case message.MessageUpdated:
+		record.OrderId, record.Side, record.Size, record.Price = body.OrderId, string(body.Side[:]), body.Size, &body.Price
+	case message.MessageDeleted:
+		record.OrderId, record.Side = body.OrderId, string(body.Side[:])
+	case message.MessageExecuted:
+		record.OrderId, record.Side, record.Size = body.OrderId, string(body.Side[:]), body.TradedQty
+	}
+	return record
+}
+
+// String returns the human readable form of the record
+// e.g. 1, A, VC0, order=6990022307456631368, side=B, size=5000, price=318800
+func (r dumpRecord) String() string {
+	line := fmt.Sprintf("%d, %s, %s, order=%d, side=%s, size=%d", r.Seq, r.Type, r.Symbol, r.OrderId, r.Side, r.Size)
+	if r.Price != nil {
+		line += fmt.Sprintf(", price=%d", *r.Price)
+	}
+	return line
+}
+
+// String returns the human readable form of the summary
+func (s *dumpSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "total: %d\n", s.Total)
+	for _, msgType := range sortedKeys(s.Types) {
+		fmt.Fprintf(&b, "type %s: %d\n", msgType, s.Types[msgType])
+	}
+	symbols := make([]string, 0, len(s.Symbols))
+	for symbol := range s.Symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		counts := s.Symbols[symbol]
+		total := 0
+		parts := make([]string, 0, len(counts))
+		for _, msgType := range sortedKeys(counts) {
+			total += counts[msgType]
+			parts = append(parts, fmt.Sprintf("%s=%d", msgType, counts[msgType]))
+		}
+		fmt.Fprintf(&b, "symbol %s: %d (%s)\n", symbol, total, strings.Join(parts, ", "))
+	}
+	return b.String()
+}
+
+// sortedKeys returns the keys of the map in ascending order
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitSet split the comma separated param into a set, returns nil for empty param
+func splitSet(param string) map[string]bool {
+	if param == "" {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, item := range strings.Split(param, ",") {
+		set[strings.TrimSpace(item)] = true
+	}
+	return set
+}
@@ -0,0 +1,61 @@
+package command
+
+import (
+	"bytes"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dump", func() {
+	config := &config.Config{}
+	config.Stream.HeaderLength = 8
+	var capture bytes.Buffer
+	var output bytes.Buffer
+
+	BeforeEach(func() {
+		capture.Reset()
+		output.Reset()
+		stream_handler.WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_ADDED, MsgHeader: message.Header{Seq: 1}, MsgBody: message.MessageAdded{Symbol: message.NewSymbol("ABC"), OrderId: 1, Side: [1]byte{message.SIDE_BUY}, Size: 10, Price: 100}})
+		stream_handler.WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_ADDED, MsgHeader: message.Header{Seq: 2}, MsgBody: message.MessageAdded{Symbol: message.NewSymbol("XYZ"), OrderId: 2, Side: [1]byte{message.SIDE_SELL}, Size: 10, Price: 200}})
+		stream_handler.WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_EXECUTED, MsgHeader: message.Header{Seq: 3}, MsgBody: message.MessageExecuted{Symbol: message.NewSymbol("ABC"), OrderId: 1, Side: [1]byte{message.SIDE_BUY}, TradedQty: 4}})
+	})
+
+	Context("without filter", func() {
+		It("should print every msg in human readable form", func() {
+			err := dump(config, &capture, &output, &dumpFilter{}, false, false)
+			Expect(err).To(BeNil())
+			Expect(output.String()).To(Equal("1, A, ABC, order=1, side=B, size=10, price=100\n" +
+				"2, A, XYZ, order=2, side=S, size=10, price=200\n" +
+				"3, E, ABC, order=1, side=B, size=4\n"))
+		})
+	})
+
+	Context("filtering by symbol and type as JSON", func() {
+		It("should only print the matching msg", func() {
+			filter := &dumpFilter{symbols: splitSet("ABC"), msgTypes: splitSet("E")}
+			err := dump(config, &capture, &output, filter, true, false)
+			Expect(err).To(BeNil())
+			Expect(output.String()).To(Equal(`{"seq":3,"type":"E","symbol":"ABC","orderId":1,"side":"B","size":4}` + "\n"))
+		})
+	})
+
+	Context("in summary mode with a seq range", func() {
+		It("should print the counts of the matching msg", func() {
+			filter := &dumpFilter{fromSeq: 2, toSeq: 3}
+			err := dump(config, &capture, &output, filter, false, true)
+			Expect(err).To(BeNil())
+			Expect(output.String()).To(Equal("total: 2\ntype A: 1\ntype E: 1\nsymbol ABC: 1 (E=1)\nsymbol XYZ: 1 (A=1)\n"))
+		})
+	})
+
+	Context("with a seq range beyond 4 bytes", func() {
+		It("should return an error instead of wrapping the seq", func() {
+			Expect(RunDump(config, []string{"-from", "4294967296"})).To(MatchError(ContainSubstring("at most 4294967295")))
+			Expect(RunDump(config, []string{"-to", "4294967297"})).To(MatchError(ContainSubstring("at most 4294967295")))
+		})
+	})
+})
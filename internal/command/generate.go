@@ -0,0 +1,98 @@
+package command
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/generator"
+)
+
+// RunGenerate writes a synthetic feed in the same format as the sample captures
+// e.g. order_book generate -output load.stream -count 1000000 -symbols VC0,VC1 -rates 3,1
+func RunGenerate(config *config.Config, args []string) error {
+	defaults := generator.DefaultConfig()
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	outputParam := flags.String("output", "", "where to write the feed, defaults to stdout")
+	countParam := flags.Int("count", 10000, "number of msg to generate")
+	seedParam := flags.Int64("seed", defaults.Seed, "seed of the random source")
+	symbolsParam := flags.String("symbols", strings.Join(defaults.Symbols, ","), "comma separated symbols")
+	ratesParam := flags.String("rates", "", "comma separated relative arrival rate of each symbol, defaults to equal rates")
+	addParam := flags.Float64("add", defaults.AddRatio, "relative frequency of added msg")
+	updateParam := flags.Float64("update", defaults.UpdateRatio, "relative frequency of updated msg")
+	deleteParam := flags.Float64("delete", defaults.DeleteRatio, "relative frequency of deleted msg")
+	executeParam := flags.Float64("execute", defaults.ExecuteRatio, "relative frequency of executed msg")
+	midParam := flags.Int("mid", int(defaults.MidPrice), "starting mid price")
+	tickParam := flags.Int("tick", int(defaults.TickSize), "tick size")
+	widthParam := flags.Int("width", defaults.BookWidth, "number of ticks away from mid an order can be placed")
+	distParam := flags.String("dist", defaults.PriceDist, "price distribution, uniform or exponential")
+	driftParam := flags.Float64("drift", defaults.MidDrift, "probability that the mid moves by one tick after each msg")
+	minSizeParam := flags.Uint64("min-size", defaults.MinSize, "minimum order size")
+	maxSizeParam := flags.Uint64("max-size", defaults.MaxSize, "maximum order size")
+	maxOrdersParam := flags.Int("max-orders", defaults.MaxOrders, "live orders kept per symbol, the deepest order is cancelled when the book is full, 0 means no limit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	genConfig := generator.Config{
+		Seed:         *seedParam,
+		Symbols:      strings.Split(*symbolsParam, ","),
+		Rates:        make(map[string]float64),
+		AddRatio:     *addParam,
+		UpdateRatio:  *updateParam,
+		DeleteRatio:  *deleteParam,
+		ExecuteRatio: *executeParam,
+		MidPrice:     int64(*midParam),
+		TickSize:     int64(*tickParam),
+		BookWidth:    *widthParam,
+		PriceDist:    *distParam,
+		MidDrift:     *driftParam,
+		MinSize:      *minSizeParam,
+		MaxSize:      *maxSizeParam,
+		StartSeq:     defaults.StartSeq,
+		StartOrderId: defaults.StartOrderId,
+		MaxOrders:    *maxOrdersParam,
+	}
+	if *ratesParam != "" {
+		rates := strings.Split(*ratesParam, ",")
+		if len(rates) != len(genConfig.Symbols) {
+			return fmt.Errorf("got %d rates for %d symbols", len(rates), len(genConfig.Symbols))
+		}
+		for i, rate := range rates {
+			value, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+			if err != nil {
+				return fmt.Errorf("invalid rate %s: %w", rate, err)
+			}
+			genConfig.Rates[genConfig.Symbols[i]] = value
+		}
+	}
+	gen, err := generator.NewGenerator(genConfig)
+	if err != nil {
+		return err
+	}
+
+	output := io.Writer(os.Stdout)
+	if *outputParam != "" {
+		f, err := os.Create(*outputParam)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		output = f
+	}
+	writer := bufio.NewWriter(output)
+	if err = gen.WriteTo(writer, *countParam); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	log.Printf("generated %d msg \n", *countParam)
+	return nil
+}
@@ -0,0 +1,45 @@
+// Package command contains the subcommands of the app other than the default depth processing
+package command
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+)
+
+// RunIndex builds the sidecar index for a capture file
+// e.g. order_book index -input input2.stream
+func RunIndex(config *config.Config, args []string) error {
+	flags := flag.NewFlagSet("index", flag.ContinueOnError)
+	inputParam := flags.String("input", "", "the capture file to index")
+	outputParam := flags.String("output", "", "where to write the index, defaults to <input>"+stream_handler.INDEX_FILE_EXT)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *inputParam == "" {
+		return fmt.Errorf("-input is required")
+	}
+	output := *outputParam
+	if output == "" {
+		output = stream_handler.IndexPath(*inputParam)
+	}
+
+	f, err := os.Open(*inputParam)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	idx, err := stream_handler.BuildIndex(config, f)
+	if err != nil {
+		return err
+	}
+	if err = stream_handler.SaveIndex(output, idx); err != nil {
+		return err
+	}
+	log.Printf("indexed %d frames and %d symbols into %s \n", len(idx.Entries), len(idx.Symbols), output)
+	return nil
+}
@@ -0,0 +1,24 @@
+// Package dbtest holds the helpers of the tests and benchmarks that replay msg into an order book
+package dbtest
+
+import (
+	"fmt"
+
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// Apply applies the msg to the book by the type of its body, returns the shallowest level changed
+func Apply(orderBook db.IDbOrderBook, msg message.Message) (int, error) {
+	switch body := msg.MsgBody.(type) {
+	case message.MessageAdded:
+		return orderBook.AddOrder(body)
+	case message.MessageUpdated:
+		return orderBook.UpdateOrder(body)
+	case message.MessageDeleted:
+		return orderBook.DeleteOrder(body)
+	case message.MessageExecuted:
+		return orderBook.ExecuteOrder(body)
+	}
+	return db.NO_LEVEL_CHANGED, fmt.Errorf("unrecognized msg body %T", msg.MsgBody)
+}
@@ -0,0 +1,37 @@
+package inmem_db
+
+import (
+	"testing"
+
+	"github.com/albertsundjaja/order_book/config"
+	dbPkg "github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/db/dbtest"
+	"github.com/albertsundjaja/order_book/internal/generator"
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// BenchmarkOrderBookDb applies a synthetic feed to OrderBookDb and prints the depth whenever its top levels change
+func BenchmarkOrderBookDb(b *testing.B) {
+	gen, err := generator.NewGenerator(generator.DefaultConfig())
+	if err != nil {
+		b.Fatal(err)
+	}
+	msgs := make([]message.Message, b.N)
+	for i := range msgs {
+		msgs[i] = gen.Next()
+	}
+	config := &config.Config{}
+	config.OrderBook.Depth = 5
+	db := NewOrderBookDb(config)
+
+	b.ResetTimer()
+	for _, msg := range msgs {
+		changedLevel, err := dbtest.Apply(db, msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if changedLevel != dbPkg.NO_LEVEL_CHANGED && changedLevel < config.OrderBook.Depth {
+			db.PrintDepth(msg.Symbol, config.OrderBook.Depth)
+		}
+	}
+}
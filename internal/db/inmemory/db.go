@@ -2,98 +2,178 @@
 package inmem_db
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"sort"
 
 	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db"
 	"github.com/albertsundjaja/order_book/internal/message"
 )
 
 // OrderBook is the IDbOrderBook in-memory implementation
 type OrderBookDb struct {
-	config     *config.Config
-	books      map[[3]byte]*orderBook // store the OrderBook of each symbols
-	lastSymbol string                 // last symbol that was updated
+	config *config.Config
+	books  map[message.Symbol]*orderBook // store the OrderBook of each symbols
 }
 
 // NewOrderBookDb return an instance of OrderBookDb
 func NewOrderBookDb(config *config.Config) *OrderBookDb {
 	return &OrderBookDb{
 		config: config,
-		books:  make(map[[3]byte]*orderBook),
+		books:  make(map[message.Symbol]*orderBook),
 	}
 }
 
 // AddSymbol add a new order book for the symbol to the manager
-func (o *OrderBookDb) AddSymbol(symbol [3]byte, orderBook *orderBook) {
+func (o *OrderBookDb) AddSymbol(symbol message.Symbol, orderBook *orderBook) {
 	o.books[symbol] = orderBook
 }
 
+// Symbols returns every symbol that has an order book, sorted
+func (o *OrderBookDb) Symbols() []message.Symbol {
+	symbols := make([]message.Symbol, 0, len(o.books))
+	for symbol := range o.books {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return bytes.Compare(symbols[i][:], symbols[j][:]) < 0
+	})
+	return symbols
+}
+
 // AddOrder add the order to the coressponding symbol order book
-func (o *OrderBookDb) AddOrder(msg message.MessageAdded) (bool, error) {
+func (o *OrderBookDb) AddOrder(msg message.MessageAdded) (int, error) {
 	orderBook, ok := o.books[msg.Symbol]
 	if !ok {
-		orderBook = newOrderBook(o.config.OrderBook.Depth)
+		orderBook = newOrderBook()
 		o.AddSymbol(msg.Symbol, orderBook)
 	}
+	orderBook.resetChangedLevel()
 	err := orderBook.addOrder(msg)
 	if err != nil {
 		log.Printf("Unable to add order. Error: %s \n", err.Error())
-		return false, err
+		return db.NO_LEVEL_CHANGED, err
 	}
-	return orderBook.shouldPrint, nil
+	return orderBook.changedLevel, nil
 }
 
 // UpdateOrder update the corresponding symbol OrderId
-func (o *OrderBookDb) UpdateOrder(msg message.MessageUpdated) (bool, error) {
+func (o *OrderBookDb) UpdateOrder(msg message.MessageUpdated) (int, error) {
 	orderBook, ok := o.books[msg.Symbol]
 	if !ok {
-		return false, fmt.Errorf("unable to update symbol %s. Symbol not found", msg.Symbol)
+		return db.NO_LEVEL_CHANGED, fmt.Errorf("unable to update symbol %s. Symbol not found", msg.Symbol)
 	}
-	orderBook.shouldPrint = false
+	orderBook.resetChangedLevel()
 	err := orderBook.updateOrder(msg)
 	if err != nil {
 		log.Printf("Unable to update order. Error: %s \n", err.Error())
-		return false, err
+		return db.NO_LEVEL_CHANGED, err
 	}
-	return orderBook.shouldPrint, nil
+	return orderBook.changedLevel, nil
 }
 
 // DeleteOrder delete the corresponding symbol OrderId
-func (o *OrderBookDb) DeleteOrder(msg message.MessageDeleted) (bool, error) {
+func (o *OrderBookDb) DeleteOrder(msg message.MessageDeleted) (int, error) {
 	orderBook, ok := o.books[msg.Symbol]
 	if !ok {
-		return false, fmt.Errorf("unable to delete symbol %s. Symbol not found", msg.Symbol)
+		return db.NO_LEVEL_CHANGED, fmt.Errorf("unable to delete symbol %s. Symbol not found", msg.Symbol)
 	}
-	orderBook.shouldPrint = false
+	orderBook.resetChangedLevel()
 	err := orderBook.deleteOrder(msg)
 	if err != nil {
 		log.Printf("Unable to delete order. Error: %s \n", err.Error())
-		return false, err
+		return db.NO_LEVEL_CHANGED, err
 	}
-	return orderBook.shouldPrint, nil
+	return orderBook.changedLevel, nil
 }
 
 // ExecuteOrder execute the corresponding symbol OrderId
-func (o *OrderBookDb) ExecuteOrder(msg message.MessageExecuted) (bool, error) {
+func (o *OrderBookDb) ExecuteOrder(msg message.MessageExecuted) (int, error) {
 	orderBook, ok := o.books[msg.Symbol]
 	if !ok {
-		return false, fmt.Errorf("unable to execute symbol %s. Symbol not found", msg.Symbol)
+		return db.NO_LEVEL_CHANGED, fmt.Errorf("unable to execute symbol %s. Symbol not found", msg.Symbol)
 	}
-	orderBook.shouldPrint = false
+	orderBook.resetChangedLevel()
 	err := orderBook.executeOrder(msg)
 	if err != nil {
 		log.Printf("Unable to execute order. Error: %s \n", err.Error())
-		return false, err
+		return db.NO_LEVEL_CHANGED, err
+	}
+	return orderBook.changedLevel, nil
+}
+
+// Print the top depth levels for the symbol
+func (o *OrderBookDb) PrintDepth(symbol message.Symbol, depth int) (string, error) {
+	orderBook, ok := o.books[symbol]
+	if !ok {
+		return "", fmt.Errorf("unexpected error occurred. symbol was not found: %s", symbol)
+	}
+	return orderBook.printDepth(depth), nil
+}
+
+// Depth returns the top depth levels for the symbol
+func (o *OrderBookDb) Depth(symbol message.Symbol, depth int) ([]db.Level, []db.Level, error) {
+	orderBook, ok := o.books[symbol]
+	if !ok {
+		return nil, nil, fmt.Errorf("symbol was not found: %s", symbol)
+	}
+	buy, sell := orderBook.levels(depth)
+	return buy, sell, nil
+}
+
+// Order returns the resting price and volume of the order
+func (o *OrderBookDb) Order(symbol message.Symbol, side byte, orderId uint64) (int64, uint64, error) {
+	orderBook, ok := o.books[symbol]
+	if !ok {
+		return 0, 0, fmt.Errorf("symbol was not found: %s", symbol)
+	}
+	orders := orderBook.Buy
+	if side == message.SIDE_SELL {
+		orders = orderBook.Sell
+	}
+	order, ok := orders[orderId]
+	if !ok {
+		return 0, 0, fmt.Errorf("orderId %d does not exist", orderId)
+	}
+	return order.Price, order.Volume, nil
+}
+
+// Orders returns every resting order of the symbol, best price first then by OrderId
+func (o *OrderBookDb) Orders(symbol message.Symbol) ([]db.Order, []db.Order, error) {
+	orderBook, ok := o.books[symbol]
+	if !ok {
+		return nil, nil, fmt.Errorf("symbol was not found: %s", symbol)
+	}
+	buy, sell := orderBook.orders()
+	return buy, sell, nil
+}
+
+// Stats returns the number of orders, levels and the volume of each side of the symbol
+func (o *OrderBookDb) Stats(symbol message.Symbol) (db.Stats, error) {
+	orderBook, ok := o.books[symbol]
+	if !ok {
+		return db.Stats{}, fmt.Errorf("symbol was not found: %s", symbol)
+	}
+	return orderBook.stats(), nil
+}
+
+// GroupedDepth returns the top depth buckets of increment width for the symbol
+func (o *OrderBookDb) GroupedDepth(symbol message.Symbol, depth int, increment int64) ([]db.Bucket, []db.Bucket, error) {
+	orderBook, ok := o.books[symbol]
+	if !ok {
+		return nil, nil, fmt.Errorf("symbol was not found: %s", symbol)
 	}
-	return orderBook.shouldPrint, nil
+	buy, sell := orderBook.buckets(depth, increment)
+	return buy, sell, nil
 }
 
-// Print the depth for the last symbol action
-func (o *OrderBookDb) PrintDepth(symbol [3]byte) (string, error) {
+// CostToFill walks the levels of the symbol that an order of the side and qty would consume
+func (o *OrderBookDb) CostToFill(symbol message.Symbol, side byte, qty uint64) (db.Fill, error) {
 	orderBook, ok := o.books[symbol]
 	if !ok {
-		return "", fmt.Errorf("unexpected error occurred. last symbol was not found: %s", o.lastSymbol)
+		return db.Fill{}, fmt.Errorf("symbol was not found: %s", symbol)
 	}
-	return orderBook.printDepth(), nil
+	return orderBook.costToFill(side, qty)
 }
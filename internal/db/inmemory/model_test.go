@@ -0,0 +1,225 @@
+package inmem_db
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db/dbtest"
+	"github.com/albertsundjaja/order_book/internal/message"
+	. "github.com/onsi/ginkgo"
+)
+
+// modelOp is a single operation applied to both OrderBookDb and the reference model
+type modelOp struct {
+	msgType string
+	side    byte
+	orderId uint64
+	price   int64
+	size    uint64 // size for add and update, traded qty for execute
+}
+
+// String returns the op in a form that can be pasted into a hand written test
+func (op modelOp) String() string {
+	return fmt.Sprintf("{%s side=%c order=%d price=%d size=%d}", op.msgType, op.side, op.orderId, op.price, op.size)
+}
+
+// message returns the msg of the op for the symbol
+func (op modelOp) message(symbol message.Symbol) message.Message {
+	side := [1]byte{op.side}
+	msg := message.Message{Symbol: symbol, MsgType: op.msgType}
+	switch op.msgType {
+	case message.MSG_TYPE_ADDED:
+		msg.MsgBody = message.MessageAdded{Symbol: symbol, OrderId: op.orderId, Side: side, Price: op.price, Size: op.size}
+	case message.MSG_TYPE_UPDATED:
+		msg.MsgBody = message.MessageUpdated{Symbol: symbol, OrderId: op.orderId, Side: side, Price: op.price, Size: op.size}
+	case message.MSG_TYPE_DELETED:
+		msg.MsgBody = message.MessageDeleted{Symbol: symbol, OrderId: op.orderId, Side: side}
+	case message.MSG_TYPE_EXECUTED:
+		msg.MsgBody = message.MessageExecuted{Symbol: symbol, OrderId: op.orderId, Side: side, TradedQty: op.size}
+	}
+	return msg
+}
+
+// modelOrder is a resting order in the reference model
+type modelOrder struct {
+	side  byte
+	price int64
+	size  uint64
+}
+
+// referenceBook is a trivially correct order book, depth is recomputed from scratch on every call
+type referenceBook struct {
+	orders map[uint64]*modelOrder
+}
+
+// valid returns whether op can be applied to the current state of the model
+func (r *referenceBook) valid(op modelOp) bool {
+	order, ok := r.orders[op.orderId]
+	switch op.msgType {
+	case message.MSG_TYPE_ADDED:
+		return !ok && op.size > 0
+	case message.MSG_TYPE_UPDATED:
+		return ok && order.side == op.side && op.size > 0
+	case message.MSG_TYPE_DELETED:
+		return ok && order.side == op.side
+	case message.MSG_TYPE_EXECUTED:
+		return ok && order.side == op.side && op.size > 0 && op.size <= order.size
+	}
+	return false
+}
+
+// apply applies a valid op to the model
+func (r *referenceBook) apply(op modelOp) {
+	switch op.msgType {
+	case message.MSG_TYPE_ADDED:
+		r.orders[op.orderId] = &modelOrder{side: op.side, price: op.price, size: op.size}
+	case message.MSG_TYPE_UPDATED:
+		r.orders[op.orderId].price = op.price
+		r.orders[op.orderId].size = op.size
+	case message.MSG_TYPE_DELETED:
+		delete(r.orders, op.orderId)
+	case message.MSG_TYPE_EXECUTED:
+		r.orders[op.orderId].size -= op.size
+		if r.orders[op.orderId].size == 0 {
+			delete(r.orders, op.orderId)
+		}
+	}
+}
+
+// depth returns the top N depth in the same format as printDepth
+func (r *referenceBook) depth(depth int) string {
+	levels := map[byte]map[int64]uint64{message.SIDE_BUY: {}, message.SIDE_SELL: {}}
+	for _, order := range r.orders {
+		levels[order.side][order.price] += order.size
+	}
+	sides := make([]string, 0, 2)
+	for _, side := range []byte{message.SIDE_BUY, message.SIDE_SELL} {
+		prices := make([]int64, 0, len(levels[side]))
+		for price := range levels[side] {
+			prices = append(prices, price)
+		}
+		sort.Slice(prices, func(i, j int) bool {
+			if side == message.SIDE_BUY {
+				return prices[i] > prices[j]
+			}
+			return prices[i] < prices[j]
+		})
+		parts := make([]string, 0, depth)
+		for _, price := range prices[:min(depth, len(prices))] {
+			parts = append(parts, fmt.Sprintf("(%d, %d)", price, levels[side][price]))
+		}
+		sides = append(sides, "["+strings.Join(parts, ", ")+"]")
+	}
+	return strings.Join(sides, ", ")
+}
+
+// randomOp returns an op that is valid for the current state of the model
+// prices are drawn from a narrow range so that levels are frequently shared and emptied
+func randomOp(r *rand.Rand, model *referenceBook, nextOrderId *uint64) modelOp {
+	price := int64(100 + r.Intn(12))
+	size := uint64(r.Intn(5) + 1)
+	if len(model.orders) == 0 || r.Intn(3) == 0 {
+		side := byte(message.SIDE_BUY)
+		if r.Intn(2) == 0 {
+			side = message.SIDE_SELL
+		}
+		*nextOrderId++
+		return modelOp{msgType: message.MSG_TYPE_ADDED, side: side, orderId: *nextOrderId, price: price, size: size}
+	}
+	ids := make([]uint64, 0, len(model.orders))
+	for id := range model.orders {
+		ids = append(ids, id)
+	}
+	// sort so that the picked order only depends on the random source
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	id := ids[r.Intn(len(ids))]
+	order := model.orders[id]
+	switch r.Intn(3) {
+	case 0:
+		return modelOp{msgType: message.MSG_TYPE_UPDATED, side: order.side, orderId: id, price: price, size: size}
+	case 1:
+		return modelOp{msgType: message.MSG_TYPE_DELETED, side: order.side, orderId: id}
+	default:
+		return modelOp{msgType: message.MSG_TYPE_EXECUTED, side: order.side, orderId: id, size: uint64(r.Int63n(int64(order.size))) + 1}
+	}
+}
+
+// runModel replays ops against a fresh OrderBookDb and reference model, skipping ops that are no longer valid
+// returns a description of the first mismatch or an empty string if the db always agrees with the model
+func runModel(depth int, ops []modelOp) string {
+	db := NewOrderBookDb(&config.Config{})
+	model := &referenceBook{orders: make(map[uint64]*modelOrder)}
+	symbol := message.NewSymbol("MDL")
+	lastDepth := model.depth(depth)
+
+	for i, op := range ops {
+		if !model.valid(op) {
+			continue
+		}
+		model.apply(op)
+		changedLevel, err := dbtest.Apply(db, op.message(symbol))
+		if err != nil {
+			return fmt.Sprintf("step %d %s returned error: %s", i, op, err)
+		}
+		actual, err := db.PrintDepth(symbol, depth)
+		if err != nil {
+			return fmt.Sprintf("step %d %s unable to print depth: %s", i, op, err)
+		}
+		expected := model.depth(depth)
+		if actual != expected {
+			return fmt.Sprintf("step %d %s depth mismatch\n  db:    %s\n  model: %s", i, op, actual, expected)
+		}
+		if changedLevel >= depth && expected != lastDepth {
+			return fmt.Sprintf("step %d %s changed the depth but reported level %d\n  before: %s\n  after:  %s", i, op, changedLevel, lastDepth, expected)
+		}
+		lastDepth = expected
+	}
+	return ""
+}
+
+// shrink removes ops from the failing sequence for as long as it keeps failing
+// it halves the removed chunk size down to single ops, similar to delta debugging
+func shrink(depth int, ops []modelOp) []modelOp {
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for start := 0; start+chunk <= len(ops); {
+			candidate := append(append([]modelOp{}, ops[:start]...), ops[start+chunk:]...)
+			if runModel(depth, candidate) != "" {
+				ops = candidate
+				continue
+			}
+			start += chunk
+		}
+	}
+	return ops
+}
+
+var _ = Describe("OrderBook model", func() {
+	Context("with random sequences of valid operations", func() {
+		It("should always have the same depth as the reference model", func() {
+			r := rand.New(rand.NewSource(GinkgoRandomSeed()))
+			for run := 0; run < 200; run++ {
+				depth := r.Intn(5) + 1
+				model := &referenceBook{orders: make(map[uint64]*modelOrder)}
+				nextOrderId := uint64(0)
+				ops := make([]modelOp, 0, 300)
+				for i := 0; i < cap(ops); i++ {
+					op := randomOp(r, model, &nextOrderId)
+					model.apply(op)
+					ops = append(ops, op)
+				}
+
+				if failure := runModel(depth, ops); failure != "" {
+					minimal := shrink(depth, ops)
+					reproducer := make([]string, len(minimal))
+					for i, op := range minimal {
+						reproducer[i] = op.String()
+					}
+					Fail(fmt.Sprintf("depth %d failed after shrinking to %d ops: %s\n%s", depth, len(minimal), runModel(depth, minimal), strings.Join(reproducer, "\n")))
+				}
+			}
+		})
+	})
+})
@@ -3,7 +3,9 @@ package inmem_db
 import (
 	"fmt"
 	"log"
+	"sort"
 
+	"github.com/albertsundjaja/order_book/internal/db"
 	"github.com/albertsundjaja/order_book/internal/message"
 )
 
@@ -14,25 +16,25 @@ const (
 
 // orderBook is the item that stores all the orderId for a given symbol
 type orderBook struct {
-	depth       int               // a parameter to indicate how deep we should keep the aggregate
-	Buy         map[uint64]*order // store map of all the buy orders with OrderId as key
-	Sell        map[uint64]*order // store map of all the sell orders with OrderId as key
-	AggBuy      map[int32]*order  // store aggregated buy data with price as key
-	AggSell     map[int32]*order  // store aggregated sell data with price as key
-	BuyDepth    []int32           // store all prices in AggBuy that is used for buy depth, sorted descending
-	SellDepth   []int32           // store all prices in AggSell that is used for sell depth, sorted ascending
-	shouldPrint bool              // flag indicating whether an update to orderBook should print new depth
+	Buy          map[uint64]*order // store map of all the buy orders with OrderId as key
+	Sell         map[uint64]*order // store map of all the sell orders with OrderId as key
+	AggBuy       map[int64]*order  // store aggregated buy data with price as key
+	AggSell      map[int64]*order  // store aggregated sell data with price as key
+	BuyDepth     []int64           // store all prices in AggBuy that is used for buy depth, sorted descending
+	SellDepth    []int64           // store all prices in AggSell that is used for sell depth, sorted ascending
+	changedLevel int               // shallowest level of BuyDepth or SellDepth changed by the last update, NO_LEVEL_CHANGED if none
 }
 
 // order is the data for individual order
 type order struct {
 	Index  int
 	Volume uint64
-	Price  int32
+	Price  int64
+	Count  int // number of orders at the price, only used in AggBuy and AggSell
 }
 
 // newOrder create new Order
-func newOrder(volume uint64, price int32) *order {
+func newOrder(volume uint64, price int64) *order {
 	return &order{
 		Volume: volume,
 		Price:  price,
@@ -40,20 +42,20 @@ func newOrder(volume uint64, price int32) *order {
 }
 
 // newOrderBook init an empty orderBook
-func newOrderBook(depth int) *orderBook {
+func newOrderBook() *orderBook {
 	return &orderBook{
-		Buy:     make(map[uint64]*order),
-		Sell:    make(map[uint64]*order),
-		AggBuy:  make(map[int32]*order),
-		AggSell: make(map[int32]*order),
-		depth:   depth,
+		Buy:          make(map[uint64]*order),
+		Sell:         make(map[uint64]*order),
+		AggBuy:       make(map[int64]*order),
+		AggSell:      make(map[int64]*order),
+		changedLevel: db.NO_LEVEL_CHANGED,
 	}
 }
 
-// PrintDepth print the depth to the console
-func (o *orderBook) printDepth() string {
+// PrintDepth print the top depth levels of each side
+func (o *orderBook) printDepth(depth int) string {
 	buyDepth := ""
-	lenBuyDepth := min(o.depth, len(o.BuyDepth))
+	lenBuyDepth := min(depth, len(o.BuyDepth))
 	for idx, val := range o.BuyDepth[:lenBuyDepth] {
 		buyDepth += fmt.Sprintf("(%d, %d)", o.AggBuy[val].Price, o.AggBuy[val].Volume)
 		if idx < lenBuyDepth-1 {
@@ -61,7 +63,7 @@ func (o *orderBook) printDepth() string {
 		}
 	}
 	sellDepth := ""
-	lenSellDepth := min(o.depth, len(o.SellDepth))
+	lenSellDepth := min(depth, len(o.SellDepth))
 	for idx, val := range o.SellDepth[:lenSellDepth] {
 		sellDepth += fmt.Sprintf("(%d, %d)", o.AggSell[val].Price, o.AggSell[val].Volume)
 		if idx < lenSellDepth-1 {
@@ -71,9 +73,157 @@ func (o *orderBook) printDepth() string {
 	return fmt.Sprintf("[%s], [%s]", buyDepth, sellDepth)
 }
 
-// ShouldPrint return the flag whether we should print after the prev update
-func (o *orderBook) ShouldPrint() bool {
-	return o.shouldPrint
+// levels returns the top depth levels of each side, best price first
+func (o *orderBook) levels(depth int) ([]db.Level, []db.Level) {
+	buy := make([]db.Level, 0, min(depth, len(o.BuyDepth)))
+	for _, price := range o.BuyDepth[:min(depth, len(o.BuyDepth))] {
+		buy = append(buy, db.Level{Price: price, Volume: o.AggBuy[price].Volume})
+	}
+	sell := make([]db.Level, 0, min(depth, len(o.SellDepth)))
+	for _, price := range o.SellDepth[:min(depth, len(o.SellDepth))] {
+		sell = append(sell, db.Level{Price: price, Volume: o.AggSell[price].Volume})
+	}
+	return buy, sell
+}
+
+// orders returns the resting orders of each side, best price first then by OrderId
+func (o *orderBook) orders() ([]db.Order, []db.Order) {
+	return sideOrders(o.Buy, message.SIDE_BUY), sideOrders(o.Sell, message.SIDE_SELL)
+}
+
+// sideOrders returns the orders of one side sorted by price priority then OrderId
+func sideOrders(orders map[uint64]*order, side byte) []db.Order {
+	sorted := make([]db.Order, 0, len(orders))
+	for orderId, order := range orders {
+		sorted = append(sorted, db.Order{OrderId: orderId, Side: side, Price: order.Price, Volume: order.Volume})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Price != sorted[j].Price {
+			// buy orders with a higher price and sell orders with a lower price come first
+			return (sorted[i].Price > sorted[j].Price) == (side == message.SIDE_BUY)
+		}
+		return sorted[i].OrderId < sorted[j].OrderId
+	})
+	return sorted
+}
+
+// stats returns the number of orders, levels and the volume of each side
+func (o *orderBook) stats() db.Stats {
+	stats := db.Stats{
+		BuyOrders:  len(o.Buy),
+		SellOrders: len(o.Sell),
+		BuyLevels:  len(o.BuyDepth),
+		SellLevels: len(o.SellDepth),
+	}
+	for _, level := range o.AggBuy {
+		stats.BuyVolume += level.Volume
+	}
+	for _, level := range o.AggSell {
+		stats.SellVolume += level.Volume
+	}
+	return stats
+}
+
+// buckets returns the top depth buckets of each side with levels grouped into price increments, best price first
+// buy prices are rounded down and sell prices up to the increment
+func (o *orderBook) buckets(depth int, increment int64) ([]db.Bucket, []db.Bucket) {
+	return groupLevels(o.BuyDepth, o.AggBuy, depth, increment, false), groupLevels(o.SellDepth, o.AggSell, depth, increment, true)
+}
+
+// groupLevels merges the consecutive prices of one side that round to the same bucket until depth buckets are found
+func groupLevels(prices []int64, levels map[int64]*order, depth int, increment int64, roundUp bool) []db.Bucket {
+	buckets := make([]db.Bucket, 0, depth)
+	for _, price := range prices {
+		bucketPrice := roundToIncrement(price, increment, roundUp)
+		if len(buckets) == 0 || buckets[len(buckets)-1].Price != bucketPrice {
+			if len(buckets) == depth {
+				break
+			}
+			buckets = append(buckets, db.Bucket{Price: bucketPrice})
+		}
+		bucket := &buckets[len(buckets)-1]
+		bucket.Volume += levels[price].Volume
+		bucket.Orders += levels[price].Count
+	}
+	return buckets
+}
+
+// roundToIncrement rounds the price down or up to a multiple of increment, negative prices included
+func roundToIncrement(price int64, increment int64, roundUp bool) int64 {
+	if increment <= 1 {
+		return price
+	}
+	rounded := price - price%increment
+	if price%increment < 0 {
+		rounded -= increment
+	}
+	if roundUp && rounded != price {
+		rounded += increment
+	}
+	return rounded
+}
+
+// costToFill walks the levels of the side opposite to the order in depth order until qty is filled or the side runs out of volume
+func (o *orderBook) costToFill(side byte, qty uint64) (db.Fill, error) {
+	var prices []int64
+	var levels map[int64]*order
+	switch side {
+	case message.SIDE_BUY:
+		prices, levels = o.SellDepth, o.AggSell
+	case message.SIDE_SELL:
+		prices, levels = o.BuyDepth, o.AggBuy
+	default:
+		return db.Fill{}, fmt.Errorf("unrecognized side for cost to fill: %c", side)
+	}
+	fill := db.Fill{}
+	if len(o.BuyDepth) > 0 && len(o.SellDepth) > 0 {
+		fill.Mid = (float64(o.BuyDepth[0]) + float64(o.SellDepth[0])) / 2
+	}
+	var notional float64
+	for _, price := range prices {
+		if fill.Qty == qty {
+			break
+		}
+		filled := levels[price].Volume
+		if remaining := qty - fill.Qty; filled > remaining {
+			filled = remaining
+		}
+		fill.Qty += filled
+		fill.WorstPrice = price
+		fill.Levels++
+		notional += float64(price) * float64(filled)
+	}
+	if fill.Qty == 0 {
+		return fill, nil
+	}
+	fill.AvgPrice = notional / float64(fill.Qty)
+	if fill.Mid != 0 {
+		fill.Slippage = fill.AvgPrice - fill.Mid
+		if side == message.SIDE_SELL {
+			fill.Slippage = -fill.Slippage
+		}
+	}
+	return fill, nil
+}
+
+// ChangedLevel return the shallowest level changed by the prev update
+func (o *orderBook) ChangedLevel() int {
+	return o.changedLevel
+}
+
+// resetChangedLevel must be called before each update so that ChangedLevel only reflects that update
+func (o *orderBook) resetChangedLevel() {
+	o.changedLevel = db.NO_LEVEL_CHANGED
+}
+
+// markChanged record the level as changed if it is shallower than the already changed level
+func (o *orderBook) markChanged(level int) {
+	if level < 0 {
+		return
+	}
+	if o.changedLevel == db.NO_LEVEL_CHANGED || level < o.changedLevel {
+		o.changedLevel = level
+	}
 }
 
 // AddOrder add the buy/sell order from the symbol into the symbol order book map
@@ -108,14 +258,14 @@ func (o *orderBook) updateOrder(updateMsg message.MessageUpdated) error {
 		if !ok {
 			return fmt.Errorf("unable to update order, orderId %d does not exist", updateMsg.OrderId)
 		}
-		o.decAggBuy(order.Price, order.Volume)
+		o.decAggBuy(order.Price, order.Volume, true)
 		o.addAggBuy(updateMsg.Price, updateMsg.Size)
 	case message.SIDE_SELL:
 		order, ok = o.Sell[updateMsg.OrderId]
 		if !ok {
 			return fmt.Errorf("unable to update order, orderId %d does not exist", updateMsg.OrderId)
 		}
-		o.decAggSell(order.Price, order.Volume)
+		o.decAggSell(order.Price, order.Volume, true)
 		o.addAggSell(updateMsg.Price, updateMsg.Size)
 	default:
 		return fmt.Errorf("unrecognized side for Update Msg. OrderId: %d. Received side: %s", updateMsg.OrderId, string(updateMsg.Side[:]))
@@ -134,14 +284,14 @@ func (o *orderBook) deleteOrder(delMsg message.MessageDeleted) error {
 		if !ok {
 			return fmt.Errorf("unable to delete orderId %d. It does not exist", delMsg.OrderId)
 		}
-		o.decAggBuy(order.Price, order.Volume)
+		o.decAggBuy(order.Price, order.Volume, true)
 		delete(o.Buy, delMsg.OrderId)
 	case message.SIDE_SELL:
 		order, ok := o.Sell[delMsg.OrderId]
 		if !ok {
 			return fmt.Errorf("unable to delete orderId %d. It does not exist", delMsg.OrderId)
 		}
-		o.decAggSell(order.Price, order.Volume)
+		o.decAggSell(order.Price, order.Volume, true)
 		delete(o.Sell, delMsg.OrderId)
 	default:
 		return fmt.Errorf("unrecognized side for Delete Msg. OrderId: %d. Received side: %s", delMsg.OrderId, string(delMsg.Side[:]))
@@ -158,7 +308,7 @@ func (o *orderBook) executeOrder(exMsg message.MessageExecuted) error {
 			return fmt.Errorf("unable to execute orderId %d. It does not exist", exMsg.OrderId)
 		}
 		order.Volume -= exMsg.TradedQty
-		o.decAggBuy(order.Price, exMsg.TradedQty)
+		o.decAggBuy(order.Price, exMsg.TradedQty, order.Volume == 0)
 		if order.Volume <= 0 {
 			delete(o.Buy, exMsg.OrderId)
 		}
@@ -168,7 +318,7 @@ func (o *orderBook) executeOrder(exMsg message.MessageExecuted) error {
 			return fmt.Errorf("unable to execute orderId %d. It does not exist", exMsg.OrderId)
 		}
 		order.Volume -= exMsg.TradedQty
-		o.decAggSell(order.Price, exMsg.TradedQty)
+		o.decAggSell(order.Price, exMsg.TradedQty, order.Volume == 0)
 		if order.Volume <= 0 {
 			delete(o.Sell, exMsg.OrderId)
 		}
@@ -179,29 +329,29 @@ func (o *orderBook) executeOrder(exMsg message.MessageExecuted) error {
 }
 
 // add to AggBuy
-func (o *orderBook) addAggBuy(price int32, size uint64) {
+func (o *orderBook) addAggBuy(price int64, size uint64) {
 	order, ok := o.AggBuy[price]
 	if !ok {
 		order = newOrder(0, price)
 		o.AggBuy[price] = order
 	}
 	order.Volume += size
+	order.Count++
 	o.addBuyDepth(price)
-	if i := SortedContainsInt32(SORT_ORDER_BUY, o.BuyDepth[0:min(len(o.BuyDepth), o.depth)], price); i != -1 {
-		o.shouldPrint = true
-	}
+	o.markChanged(SortedContainsInt64(SORT_ORDER_BUY, o.BuyDepth, price))
 }
 
-// dec AggBuy
-func (o *orderBook) decAggBuy(price int32, size uint64) {
+// dec AggBuy, removed is whether the order leaves the price
+func (o *orderBook) decAggBuy(price int64, size uint64, removed bool) {
 	order, ok := o.AggBuy[price]
 	if !ok {
 		log.Fatalf("price (%d) is not found when decreasing aggBuy! this is not supposed to happen.", price)
 	}
 	order.Volume -= size
-	if i := SortedContainsInt32(SORT_ORDER_BUY, o.BuyDepth[0:min(len(o.BuyDepth), o.depth)], price); i != -1 {
-		o.shouldPrint = true
+	if removed {
+		order.Count--
 	}
+	o.markChanged(SortedContainsInt64(SORT_ORDER_BUY, o.BuyDepth, price))
 	if order.Volume == 0 {
 		o.removeBuyDepth(price)
 		delete(o.AggBuy, price)
@@ -209,29 +359,29 @@ func (o *orderBook) decAggBuy(price int32, size uint64) {
 }
 
 // add to AggSell
-func (o *orderBook) addAggSell(price int32, size uint64) {
+func (o *orderBook) addAggSell(price int64, size uint64) {
 	order, ok := o.AggSell[price]
 	if !ok {
 		order = newOrder(0, price)
 		o.AggSell[price] = order
 	}
 	order.Volume += size
+	order.Count++
 	o.addSellDepth(price)
-	if i := SortedContainsInt32(SORT_ORDER_SELL, o.SellDepth[0:min(len(o.SellDepth), o.depth)], price); i != -1 {
-		o.shouldPrint = true
-	}
+	o.markChanged(SortedContainsInt64(SORT_ORDER_SELL, o.SellDepth, price))
 }
 
-// dec from AgSell
-func (o *orderBook) decAggSell(price int32, size uint64) {
+// dec from AgSell, removed is whether the order leaves the price
+func (o *orderBook) decAggSell(price int64, size uint64, removed bool) {
 	order, ok := o.AggSell[price]
 	if !ok {
 		log.Fatalf("price (%d) is not found when decreasing aggSell! this is not supposed to happen.", price)
 	}
 	order.Volume -= size
-	if i := SortedContainsInt32(SORT_ORDER_SELL, o.SellDepth[0:min(len(o.SellDepth), o.depth)], price); i != -1 {
-		o.shouldPrint = true
+	if removed {
+		order.Count--
 	}
+	o.markChanged(SortedContainsInt64(SORT_ORDER_SELL, o.SellDepth, price))
 	if order.Volume == 0 {
 		o.removeSellDepth(price)
 		delete(o.AggSell, price)
@@ -239,22 +389,22 @@ func (o *orderBook) decAggSell(price int32, size uint64) {
 }
 
 // add price into BuyDepth, ignoring it if it's already there
-func (o *orderBook) addBuyDepth(price int32) {
+func (o *orderBook) addBuyDepth(price int64) {
 	// search if price is already in BuyDepth
 	var i int
-	if i = SortedContainsInt32(SORT_ORDER_BUY, o.BuyDepth, price); i != -1 {
+	if i = SortedContainsInt64(SORT_ORDER_BUY, o.BuyDepth, price); i != -1 {
 		return
 	}
 	o.BuyDepth = append(o.BuyDepth, price)
 	// sort descending
 	// insertion sort is used as BuyDepth is originally sorted, it is roughly O(n) for almost sorted array
-	InsertiontSortInt32(o.BuyDepth, SORT_ORDER_BUY)
+	InsertiontSortInt64(o.BuyDepth, SORT_ORDER_BUY)
 }
 
 // remove price from BuyDepth, ignoring it if it's not present
-func (o *orderBook) removeBuyDepth(price int32) {
+func (o *orderBook) removeBuyDepth(price int64) {
 	var i int
-	if i = SortedContainsInt32(SORT_ORDER_BUY, o.BuyDepth, price); i == -1 {
+	if i = SortedContainsInt64(SORT_ORDER_BUY, o.BuyDepth, price); i == -1 {
 		return
 	}
 	if len(o.BuyDepth) <= 1 {
@@ -265,22 +415,22 @@ func (o *orderBook) removeBuyDepth(price int32) {
 }
 
 // add price to SellDepth, ignoring it if it's present
-func (o *orderBook) addSellDepth(price int32) {
+func (o *orderBook) addSellDepth(price int64) {
 	// search if price is already in SellDepth
 	var i int
-	if i = SortedContainsInt32(SORT_ORDER_SELL, o.SellDepth, price); i != -1 {
+	if i = SortedContainsInt64(SORT_ORDER_SELL, o.SellDepth, price); i != -1 {
 		return
 	}
 	o.SellDepth = append(o.SellDepth, price)
 	// sort ascending
 	// insertion sort is used as SellDepth is originally sorted, it is roughly O(n) for almost sorted array
-	InsertiontSortInt32(o.SellDepth, SORT_ORDER_SELL)
+	InsertiontSortInt64(o.SellDepth, SORT_ORDER_SELL)
 }
 
 // remove price from SellDepth, ignoring it if it's not present
-func (o *orderBook) removeSellDepth(price int32) {
+func (o *orderBook) removeSellDepth(price int64) {
 	var i int
-	if i = SortedContainsInt32(SORT_ORDER_SELL, o.SellDepth, price); i == -1 {
+	if i = SortedContainsInt64(SORT_ORDER_SELL, o.SellDepth, price); i == -1 {
 		return
 	}
 
@@ -1,6 +1,7 @@
 package inmem_db
 
 import (
+	"github.com/albertsundjaja/order_book/internal/db"
 	"github.com/albertsundjaja/order_book/internal/message"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -12,13 +13,13 @@ var _ = Describe("OrderBook", func() {
 	)
 
 	BeforeEach(func() {
-		orderBook = newOrderBook(5)
+		orderBook = newOrderBook()
 	})
 	Describe("AddOrder", func() {
 		Context("adding order to buy side with a new OrderId", func() {
 			It("add to the Buy, AggBuy and BuyDepth correctly", func() {
 				orderId := uint64(123)
-				price := int32(1)
+				price := int64(1)
 				volume := uint64(1)
 				addMsg := message.MessageAdded{
 					Side:    [1]byte{message.SIDE_BUY},
@@ -32,14 +33,14 @@ var _ = Describe("OrderBook", func() {
 				Expect(orderBook.Buy[orderId].Price).To(Equal(price))
 				Expect(orderBook.Buy[orderId].Volume).To(Equal(volume))
 				Expect(orderBook.AggBuy[price].Volume).To(Equal(volume))
-				Expect(SortedContainsInt32(SORT_ORDER_BUY, orderBook.BuyDepth, price)).To(Equal(0))
+				Expect(SortedContainsInt64(SORT_ORDER_BUY, orderBook.BuyDepth, price)).To(Equal(0))
 			})
 		})
 
 		Context("adding order to sell side with a new OrderId", func() {
 			It("add to the Sell, AggSell, SellDepth correctly", func() {
 				orderId := uint64(123)
-				price := int32(1)
+				price := int64(1)
 				volume := uint64(1)
 				addMsg := message.MessageAdded{
 					Side:    [1]byte{message.SIDE_SELL},
@@ -53,7 +54,7 @@ var _ = Describe("OrderBook", func() {
 				Expect(orderBook.Sell[orderId].Price).To(Equal(price))
 				Expect(orderBook.Sell[orderId].Volume).To(Equal(volume))
 				Expect(orderBook.AggSell[price].Volume).To(Equal(volume))
-				Expect(SortedContainsInt32(SORT_ORDER_SELL, orderBook.SellDepth, price)).To(Equal(0))
+				Expect(SortedContainsInt64(SORT_ORDER_SELL, orderBook.SellDepth, price)).To(Equal(0))
 			})
 		})
 	})
@@ -63,7 +64,7 @@ var _ = Describe("OrderBook", func() {
 			It("should update correctly", func() {
 				// add order first
 				orderId := uint64(123)
-				price := int32(1)
+				price := int64(1)
 				volume := uint64(1)
 				addMsg := message.MessageAdded{
 					Side:    [1]byte{message.SIDE_BUY},
@@ -74,7 +75,7 @@ var _ = Describe("OrderBook", func() {
 				err := orderBook.addOrder(addMsg)
 				Expect(err).To(BeNil())
 
-				updatedPrice := int32(20)
+				updatedPrice := int64(20)
 				updatedVolume := uint64(20)
 				updateMsg := message.MessageUpdated{
 					Side:    [1]byte{message.SIDE_BUY},
@@ -88,12 +89,12 @@ var _ = Describe("OrderBook", func() {
 				Expect(orderBook.Buy[orderId].Price).To(Equal(updatedPrice))
 				Expect(orderBook.Buy[orderId].Volume).To(Equal(updatedVolume))
 				Expect(orderBook.AggBuy[updatedPrice].Volume).To(Equal(updatedVolume))
-				Expect(SortedContainsInt32(SORT_ORDER_BUY, orderBook.BuyDepth, updatedPrice)).To(Equal(0))
+				Expect(SortedContainsInt64(SORT_ORDER_BUY, orderBook.BuyDepth, updatedPrice)).To(Equal(0))
 
 				// check old price is correctly handled
 				_, ok := orderBook.AggBuy[price]
 				Expect(ok).To(Equal(false))
-				Expect(SortedContainsInt32(SORT_ORDER_BUY, orderBook.BuyDepth, price)).To(Equal(-1))
+				Expect(SortedContainsInt64(SORT_ORDER_BUY, orderBook.BuyDepth, price)).To(Equal(-1))
 			})
 		})
 
@@ -101,7 +102,7 @@ var _ = Describe("OrderBook", func() {
 			It("should update correctly", func() {
 				// add order first
 				orderId := uint64(123)
-				price := int32(1)
+				price := int64(1)
 				volume := uint64(1)
 				addMsg := message.MessageAdded{
 					Side:    [1]byte{message.SIDE_SELL},
@@ -112,7 +113,7 @@ var _ = Describe("OrderBook", func() {
 				err := orderBook.addOrder(addMsg)
 				Expect(err).To(BeNil())
 
-				updatedPrice := int32(20)
+				updatedPrice := int64(20)
 				updatedVolume := uint64(20)
 				updateMsg := message.MessageUpdated{
 					Side:    [1]byte{message.SIDE_SELL},
@@ -126,12 +127,12 @@ var _ = Describe("OrderBook", func() {
 				Expect(orderBook.Sell[orderId].Price).To(Equal(updatedPrice))
 				Expect(orderBook.Sell[orderId].Volume).To(Equal(updatedVolume))
 				Expect(orderBook.AggSell[updatedPrice].Volume).To(Equal(updatedVolume))
-				Expect(SortedContainsInt32(SORT_ORDER_SELL, orderBook.SellDepth, updatedPrice)).To(Equal(0))
+				Expect(SortedContainsInt64(SORT_ORDER_SELL, orderBook.SellDepth, updatedPrice)).To(Equal(0))
 
 				// check old price is correctly handled
 				_, ok := orderBook.AggSell[price]
 				Expect(ok).To(Equal(false))
-				Expect(SortedContainsInt32(SORT_ORDER_SELL, orderBook.SellDepth, price)).To(Equal(-1))
+				Expect(SortedContainsInt64(SORT_ORDER_SELL, orderBook.SellDepth, price)).To(Equal(-1))
 			})
 		})
 	})
@@ -141,7 +142,7 @@ var _ = Describe("OrderBook", func() {
 			It("should delete correctly", func() {
 				// add order first
 				orderId := uint64(123)
-				price := int32(1)
+				price := int64(1)
 				volume := uint64(1)
 				addMsg := message.MessageAdded{
 					Side:    [1]byte{message.SIDE_BUY},
@@ -163,7 +164,7 @@ var _ = Describe("OrderBook", func() {
 				Expect(ok).To(BeFalse())
 				_, ok = orderBook.AggBuy[price]
 				Expect(ok).To(BeFalse())
-				Expect(SortedContainsInt32(SORT_ORDER_BUY, orderBook.BuyDepth, price)).To(Equal(-1))
+				Expect(SortedContainsInt64(SORT_ORDER_BUY, orderBook.BuyDepth, price)).To(Equal(-1))
 			})
 		})
 
@@ -171,7 +172,7 @@ var _ = Describe("OrderBook", func() {
 			It("should delete correctly", func() {
 				// add order first
 				orderId := uint64(123)
-				price := int32(1)
+				price := int64(1)
 				volume := uint64(1)
 				addMsg := message.MessageAdded{
 					Side:    [1]byte{message.SIDE_SELL},
@@ -193,7 +194,7 @@ var _ = Describe("OrderBook", func() {
 				Expect(ok).To(BeFalse())
 				_, ok = orderBook.AggSell[price]
 				Expect(ok).To(BeFalse())
-				Expect(SortedContainsInt32(SORT_ORDER_SELL, orderBook.SellDepth, price)).To(Equal(-1))
+				Expect(SortedContainsInt64(SORT_ORDER_SELL, orderBook.SellDepth, price)).To(Equal(-1))
 			})
 		})
 	})
@@ -203,7 +204,7 @@ var _ = Describe("OrderBook", func() {
 			It("should execute correctly", func() {
 				// add order first
 				orderId := uint64(123)
-				price := int32(1)
+				price := int64(1)
 				volume := uint64(1)
 				addMsg := message.MessageAdded{
 					Side:    [1]byte{message.SIDE_BUY},
@@ -226,7 +227,7 @@ var _ = Describe("OrderBook", func() {
 				Expect(ok).To(BeFalse())
 				_, ok = orderBook.AggBuy[price]
 				Expect(ok).To(BeFalse())
-				Expect(SortedContainsInt32(SORT_ORDER_BUY, orderBook.BuyDepth, price)).To(Equal(-1))
+				Expect(SortedContainsInt64(SORT_ORDER_BUY, orderBook.BuyDepth, price)).To(Equal(-1))
 			})
 
 		})
@@ -235,7 +236,7 @@ var _ = Describe("OrderBook", func() {
 			It("should execute correctly", func() {
 				// add order first
 				orderId := uint64(123)
-				price := int32(1)
+				price := int64(1)
 				volume := uint64(1)
 				addMsg := message.MessageAdded{
 					Side:    [1]byte{message.SIDE_SELL},
@@ -258,9 +259,111 @@ var _ = Describe("OrderBook", func() {
 				Expect(ok).To(BeFalse())
 				_, ok = orderBook.AggSell[price]
 				Expect(ok).To(BeFalse())
-				Expect(SortedContainsInt32(SORT_ORDER_SELL, orderBook.SellDepth, price)).To(Equal(-1))
+				Expect(SortedContainsInt64(SORT_ORDER_SELL, orderBook.SellDepth, price)).To(Equal(-1))
 			})
 
 		})
 	})
+
+	Describe("Cost To Fill", func() {
+		BeforeEach(func() {
+			for i, level := range []struct {
+				side   byte
+				price  int64
+				volume uint64
+			}{{message.SIDE_BUY, 99, 10}, {message.SIDE_SELL, 101, 10}, {message.SIDE_SELL, 102, 20}, {message.SIDE_SELL, 104, 5}} {
+				err := orderBook.addOrder(message.MessageAdded{Side: [1]byte{level.side}, OrderId: uint64(i), Price: level.price, Size: level.volume})
+				Expect(err).To(BeNil())
+			}
+		})
+
+		Context("buying within the sell volume", func() {
+			It("should walk the sell levels best price first", func() {
+				fill, err := orderBook.costToFill(message.SIDE_BUY, 20)
+				Expect(err).To(BeNil())
+				Expect(fill.Qty).To(Equal(uint64(20)))
+				Expect(fill.AvgPrice).To(BeNumerically("~", 101.5))
+				Expect(fill.WorstPrice).To(Equal(int64(102)))
+				Expect(fill.Levels).To(Equal(2))
+				Expect(fill.Mid).To(BeNumerically("~", 100))
+				Expect(fill.Slippage).To(BeNumerically("~", 1.5))
+			})
+		})
+
+		Context("selling more than the buy volume", func() {
+			It("should only fill the available volume", func() {
+				fill, err := orderBook.costToFill(message.SIDE_SELL, 50)
+				Expect(err).To(BeNil())
+				Expect(fill.Qty).To(Equal(uint64(10)))
+				Expect(fill.WorstPrice).To(Equal(int64(99)))
+				Expect(fill.Slippage).To(BeNumerically("~", 1))
+			})
+		})
+	})
+
+	Describe("Buckets", func() {
+		BeforeEach(func() {
+			for i, level := range []struct {
+				side   byte
+				price  int64
+				volume uint64
+			}{{message.SIDE_BUY, 199, 1}, {message.SIDE_BUY, 150, 2}, {message.SIDE_BUY, 150, 3}, {message.SIDE_BUY, 99, 4},
+				{message.SIDE_SELL, 201, 5}, {message.SIDE_SELL, 300, 6}, {message.SIDE_SELL, 301, 7}} {
+				err := orderBook.addOrder(message.MessageAdded{Side: [1]byte{level.side}, OrderId: uint64(i), Price: level.price, Size: level.volume})
+				Expect(err).To(BeNil())
+			}
+		})
+
+		Context("grouping into increments of 100", func() {
+			It("should round buy prices down and sell prices up", func() {
+				buy, sell := orderBook.buckets(5, 100)
+				Expect(buy).To(Equal([]db.Bucket{{Price: 100, Volume: 6, Orders: 3}, {Price: 0, Volume: 4, Orders: 1}}))
+				Expect(sell).To(Equal([]db.Bucket{{Price: 300, Volume: 11, Orders: 2}, {Price: 400, Volume: 7, Orders: 1}}))
+			})
+		})
+
+		Context("after a partial and a full execution", func() {
+			It("should only count the orders left at the price", func() {
+				Expect(orderBook.executeOrder(message.MessageExecuted{Side: [1]byte{message.SIDE_BUY}, OrderId: 1, TradedQty: 1})).To(Succeed())
+				Expect(orderBook.executeOrder(message.MessageExecuted{Side: [1]byte{message.SIDE_BUY}, OrderId: 2, TradedQty: 3})).To(Succeed())
+				buy, _ := orderBook.buckets(1, 100)
+				Expect(buy).To(Equal([]db.Bucket{{Price: 100, Volume: 2, Orders: 2}}))
+			})
+		})
+	})
+
+	Describe("Orders and Stats", func() {
+		BeforeEach(func() {
+			for i, level := range []struct {
+				side   byte
+				price  int64
+				volume uint64
+			}{{message.SIDE_BUY, 99, 1}, {message.SIDE_BUY, 100, 2}, {message.SIDE_BUY, 99, 3},
+				{message.SIDE_SELL, 102, 4}, {message.SIDE_SELL, 101, 5}} {
+				err := orderBook.addOrder(message.MessageAdded{Side: [1]byte{level.side}, OrderId: uint64(10 - i), Price: level.price, Size: level.volume})
+				Expect(err).To(BeNil())
+			}
+		})
+
+		Context("listing the resting orders", func() {
+			It("should sort by best price then by OrderId", func() {
+				buy, sell := orderBook.orders()
+				Expect(buy).To(Equal([]db.Order{
+					{OrderId: 9, Side: message.SIDE_BUY, Price: 100, Volume: 2},
+					{OrderId: 8, Side: message.SIDE_BUY, Price: 99, Volume: 3},
+					{OrderId: 10, Side: message.SIDE_BUY, Price: 99, Volume: 1},
+				}))
+				Expect(sell).To(Equal([]db.Order{
+					{OrderId: 6, Side: message.SIDE_SELL, Price: 101, Volume: 5},
+					{OrderId: 7, Side: message.SIDE_SELL, Price: 102, Volume: 4},
+				}))
+			})
+		})
+
+		Context("counting the book", func() {
+			It("should return the orders, levels and volume of each side", func() {
+				Expect(orderBook.stats()).To(Equal(db.Stats{BuyOrders: 3, SellOrders: 2, BuyLevels: 2, SellLevels: 2, BuyVolume: 6, SellVolume: 9}))
+			})
+		})
+	})
 })
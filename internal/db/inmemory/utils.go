@@ -4,9 +4,9 @@ import (
 	"sort"
 )
 
-// SortedContainsInt32 use binary search to search for a value in the slice
+// SortedContainsInt64 use binary search to search for a value in the slice
 // returns the index of the item or -1 if not found
-func SortedContainsInt32(ascending bool, slice []int32, a int32) int {
+func SortedContainsInt64(ascending bool, slice []int64, a int64) int {
 	// edge case when slice is empty
 	if len(slice) == 0 {
 		return -1
@@ -27,7 +27,7 @@ func SortedContainsInt32(ascending bool, slice []int32, a int32) int {
 
 // InsertionSort sort the slice using insertion sort algorithm
 // this is useful as the complexity is roughly O(n) for almost sorted array
-func InsertiontSortInt32(slice []int32, ascending bool) {
+func InsertiontSortInt64(slice []int64, ascending bool) {
 	var x = len(slice)
 	for n := 1; n < x; n++ {
 		v := n
@@ -2,12 +2,65 @@ package db
 
 import "github.com/albertsundjaja/order_book/internal/message"
 
+// NO_LEVEL_CHANGED is returned when a transaction does not change any depth level
+const NO_LEVEL_CHANGED = -1
+
+// Level is the aggregated volume of all orders at a price
+type Level struct {
+	Price  int64
+	Volume uint64
+}
+
+// Bucket is the aggregated volume and number of orders of all the levels within a price increment
+// buy levels are grouped down to the bucket Price and sell levels up to it, so that the buckets never overlap
+type Bucket struct {
+	Price  int64
+	Volume uint64
+	Orders int
+}
+
+// Fill is the result of walking the levels of the opposite side to fill the qty of an order at once
+type Fill struct {
+	Qty        uint64  // qty that can be filled, less than the requested qty when the side does not have enough volume
+	AvgPrice   float64 // volume weighted price of the fill
+	WorstPrice int64   // price of the last level consumed
+	Levels     int     // levels consumed, including a partially consumed one
+	Mid        float64 // mid of the best prices before the fill, 0 when either side is empty
+	Slippage   float64 // how much worse AvgPrice is than Mid for the side of the order, 0 when Mid is 0
+}
+
+// Order is a resting order
+type Order struct {
+	OrderId uint64
+	Side    byte
+	Price   int64
+	Volume  uint64
+}
+
+// Stats is the size of the book of a symbol
+type Stats struct {
+	BuyOrders  int // resting buy orders
+	SellOrders int
+	BuyLevels  int // distinct buy prices
+	SellLevels int
+	BuyVolume  uint64 // resting buy volume over every level
+	SellVolume uint64
+}
+
 // IDbOrderBook is an interface to store order book for easy DB replacement
-// all data manipulation return bool that indicates whether that transaction changes the top N depth
+// all data manipulation return the shallowest depth level (0 is the best price) changed by that transaction on either side
+// a consumer printing the top N depth should print when the returned level is between 0 and N-1
 type IDbOrderBook interface {
-	AddOrder(message.MessageAdded) (bool, error)        // add order to db
-	UpdateOrder(message.MessageUpdated) (bool, error)   // update order
-	DeleteOrder(message.MessageDeleted) (bool, error)   // delete order
-	ExecuteOrder(message.MessageExecuted) (bool, error) // execute order
-	PrintDepth(symbol [3]byte) (string, error)          // return string that gives the symbol depth e.g. [(2, 1)], [(5, 1), (6, 1)]
+	AddOrder(message.MessageAdded) (int, error)                                                              // add order to db
+	UpdateOrder(message.MessageUpdated) (int, error)                                                         // update order
+	DeleteOrder(message.MessageDeleted) (int, error)                                                         // delete order
+	ExecuteOrder(message.MessageExecuted) (int, error)                                                       // execute order
+	PrintDepth(symbol message.Symbol, depth int) (string, error)                                             // return string that gives the top depth levels of the symbol e.g. [(2, 1)], [(5, 1), (6, 1)]
+	Depth(symbol message.Symbol, depth int) (buy []Level, sell []Level, err error)                           // return the top depth levels of the symbol, best price first
+	GroupedDepth(symbol message.Symbol, depth int, increment int64) (buy []Bucket, sell []Bucket, err error) // return the top depth buckets of increment width, best price first
+	CostToFill(symbol message.Symbol, side byte, qty uint64) (Fill, error)                                   // walk the levels that an order of the side would consume
+	Order(symbol message.Symbol, side byte, orderId uint64) (price int64, volume uint64, err error)          // return the resting price and volume of the order
+	Orders(symbol message.Symbol) (buy []Order, sell []Order, err error)                                     // return every resting order of the symbol, best price first then by OrderId
+	Stats(symbol message.Symbol) (Stats, error)                                                              // return the number of orders, levels and the volume of each side
+	Symbols() []message.Symbol                                                                               // return every symbol that has a book
 }
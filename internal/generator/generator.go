@@ -0,0 +1,454 @@
+// Package generator produces synthetic but valid feeds for load and fuzz testing
+package generator
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+)
+
+const (
+	PRICE_DIST_UNIFORM     = "uniform"     // prices are spread evenly across the book width
+	PRICE_DIST_EXPONENTIAL = "exponential" // prices are concentrated near the top of the book
+)
+
+// Config describes the shape of the generated feed
+type Config struct {
+	Seed         int64              // seed of the random source, the same seed always generates the same feed
+	Symbols      []string           // symbols to generate, each must be 3 bytes
+	Rates        map[string]float64 // relative order arrival rate per symbol, missing symbols default to 1
+	AddRatio     float64            // relative frequency of added msg
+	UpdateRatio  float64            // relative frequency of updated msg
+	DeleteRatio  float64            // relative frequency of deleted msg
+	ExecuteRatio float64            // relative frequency of executed msg
+	MidPrice     int64              // starting mid price of every symbol
+	TickSize     int64              // price increment between levels
+	BookWidth    int                // number of ticks away from mid an order can be placed
+	PriceDist    string             // PRICE_DIST_UNIFORM or PRICE_DIST_EXPONENTIAL
+	MidDrift     float64            // probability that the mid moves by one tick after each msg
+	MinSize      uint64             // minimum order size
+	MaxSize      uint64             // maximum order size
+	StartSeq     uint32             // seq of the first generated msg
+	StartOrderId uint64             // OrderId of the first added order
+	MaxOrders    int                // live orders kept per symbol, the deepest order is cancelled when the book is full, 0 means no limit
+}
+
+// DefaultConfig returns a Config that generates a feed similar to input2.stream
+func DefaultConfig() Config {
+	return Config{
+		Seed:         1,
+		Symbols:      []string{"VC0", "VC1", "VC2"},
+		AddRatio:     0.5,
+		UpdateRatio:  0.2,
+		DeleteRatio:  0.15,
+		ExecuteRatio: 0.15,
+		MidPrice:     318800,
+		TickSize:     100,
+		BookWidth:    20,
+		PriceDist:    PRICE_DIST_EXPONENTIAL,
+		MidDrift:     0.01,
+		MinSize:      1,
+		MaxSize:      5000,
+		StartSeq:     1,
+		StartOrderId: 1,
+		MaxOrders:    1000,
+	}
+}
+
+// liveOrder is an order that has been added and not yet fully deleted or executed
+type liveOrder struct {
+	id    uint64
+	side  byte
+	price int64
+	size  uint64
+}
+
+// symbolBook keeps the live orders of a symbol so that generated msg always reference existing OrderIds
+type symbolBook struct {
+	symbol message.Symbol
+	mid    int64
+	orders []*liveOrder   // live orders, used for picking a random order
+	index  map[uint64]int // position of each OrderId in orders
+	sides  [2]*bookSide   // live orders of the buy and sell side by price
+}
+
+// bookSide keeps the live orders of a side by price so that the best and deepest orders are found without scanning the book
+type bookSide struct {
+	buy    bool
+	prices []int64                // prices with live orders, best first
+	levels map[int64][]*liveOrder // live orders of each price in arrival order
+}
+
+// Generator produces Messages one at a time
+type Generator struct {
+	config      Config
+	rand        *rand.Rand
+	books       []*symbolBook
+	rateTotal   float64   // sum of all symbol rates
+	rates       []float64 // rate of each book
+	nextSeq     uint32
+	nextOrderId uint64
+}
+
+// NewGenerator validates the config and return an instance of Generator
+func NewGenerator(config Config) (*Generator, error) {
+	if len(config.Symbols) == 0 {
+		return nil, fmt.Errorf("at least one symbol is required")
+	}
+	if config.TickSize <= 0 || config.BookWidth <= 0 {
+		return nil, fmt.Errorf("tick size and book width must be positive")
+	}
+	if config.MinSize == 0 || config.MaxSize < config.MinSize {
+		return nil, fmt.Errorf("invalid size range [%d, %d]", config.MinSize, config.MaxSize)
+	}
+	if config.AddRatio <= 0 || config.UpdateRatio < 0 || config.DeleteRatio < 0 || config.ExecuteRatio < 0 {
+		return nil, fmt.Errorf("add ratio must be positive and the other ratios must not be negative")
+	}
+	if config.MaxOrders < 0 {
+		return nil, fmt.Errorf("max orders must not be negative")
+	}
+	if config.PriceDist != PRICE_DIST_UNIFORM && config.PriceDist != PRICE_DIST_EXPONENTIAL {
+		return nil, fmt.Errorf("unrecognized price distribution %s", config.PriceDist)
+	}
+	width := config.TickSize * int64(config.BookWidth)
+	if config.MidPrice-width <= 0 {
+		return nil, fmt.Errorf("mid price %d is too low for the book width", config.MidPrice)
+	}
+	if config.MidPrice+width > math.MaxInt32 {
+		return nil, fmt.Errorf("mid price %d is too high for the book width, the default schema encodes the price in 4 bytes", config.MidPrice)
+	}
+
+	g := &Generator{
+		config:      config,
+		rand:        rand.New(rand.NewSource(config.Seed)),
+		nextSeq:     config.StartSeq,
+		nextOrderId: config.StartOrderId,
+	}
+	for _, symbol := range config.Symbols {
+		if len(symbol) != 3 {
+			return nil, fmt.Errorf("symbol %s must be 3 bytes", symbol)
+		}
+		rate, ok := config.Rates[symbol]
+		if !ok {
+			rate = 1
+		}
+		if rate <= 0 {
+			return nil, fmt.Errorf("rate of symbol %s must be positive", symbol)
+		}
+		book := &symbolBook{
+			symbol: message.NewSymbol(symbol),
+			mid:    config.MidPrice,
+			index:  make(map[uint64]int),
+			sides:  [2]*bookSide{{buy: true, levels: make(map[int64][]*liveOrder)}, {levels: make(map[int64][]*liveOrder)}},
+		}
+		g.books = append(g.books, book)
+		g.rates = append(g.rates, rate)
+		g.rateTotal += rate
+	}
+	return g, nil
+}
+
+// Next returns the next Message of the feed
+func (g *Generator) Next() message.Message {
+	book := g.pickBook()
+	var msg message.Message
+	if order := g.trim(book); order != nil {
+		msg = g.cancel(book, order)
+	} else {
+		switch g.pickMsgType(book) {
+		case message.MSG_TYPE_ADDED:
+			msg = g.add(book)
+		case message.MSG_TYPE_UPDATED:
+			msg = g.update(book)
+		case message.MSG_TYPE_DELETED:
+			msg = g.delete(book)
+		case message.MSG_TYPE_EXECUTED:
+			msg = g.execute(book)
+		}
+	}
+	msg.Symbol = book.symbol
+	msg.MsgHeader.Seq = g.nextSeq
+	g.nextSeq++
+	g.drift(book)
+	return msg
+}
+
+// WriteTo writes count frames of the feed into w
+func (g *Generator) WriteTo(w io.Writer, count int) error {
+	for i := 0; i < count; i++ {
+		if err := stream_handler.WriteMsg(w, g.Next()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pickBook picks a symbol weighted by its arrival rate
+func (g *Generator) pickBook() *symbolBook {
+	r := g.rand.Float64() * g.rateTotal
+	for i, rate := range g.rates {
+		if r < rate {
+			return g.books[i]
+		}
+		r -= rate
+	}
+	return g.books[len(g.books)-1]
+}
+
+// pickMsgType picks the msg type weighted by the configured ratios, only add is possible on an empty book
+func (g *Generator) pickMsgType(book *symbolBook) string {
+	if len(book.orders) == 0 {
+		return message.MSG_TYPE_ADDED
+	}
+	c := g.config
+	r := g.rand.Float64() * (c.AddRatio + c.UpdateRatio + c.DeleteRatio + c.ExecuteRatio)
+	switch {
+	case r < c.AddRatio:
+		return message.MSG_TYPE_ADDED
+	case r < c.AddRatio+c.UpdateRatio:
+		return message.MSG_TYPE_UPDATED
+	case r < c.AddRatio+c.UpdateRatio+c.DeleteRatio:
+		return message.MSG_TYPE_DELETED
+	default:
+		return message.MSG_TYPE_EXECUTED
+	}
+}
+
+// add creates a new live order on a random side
+func (g *Generator) add(book *symbolBook) message.Message {
+	side := byte(message.SIDE_BUY)
+	if g.rand.Intn(2) == 1 {
+		side = message.SIDE_SELL
+	}
+	order := &liveOrder{id: g.nextOrderId, side: side, price: g.price(book, side), size: g.size()}
+	g.nextOrderId++
+	book.index[order.id] = len(book.orders)
+	book.orders = append(book.orders, order)
+	book.side(side).insert(order)
+	body := message.MessageAdded{
+		Symbol:  book.symbol,
+		OrderId: order.id,
+		Side:    [1]byte{side},
+		Size:    order.size,
+		Price:   order.price,
+	}
+	return message.Message{MsgType: message.MSG_TYPE_ADDED, MsgBody: body}
+}
+
+// update moves a random live order to a new price and size on the same side
+func (g *Generator) update(book *symbolBook) message.Message {
+	order := book.orders[g.rand.Intn(len(book.orders))]
+	book.side(order.side).remove(order)
+	order.price = g.price(book, order.side)
+	order.size = g.size()
+	book.side(order.side).insert(order)
+	body := message.MessageUpdated{
+		Symbol:  book.symbol,
+		OrderId: order.id,
+		Side:    [1]byte{order.side},
+		Size:    order.size,
+		Price:   order.price,
+	}
+	return message.Message{MsgType: message.MSG_TYPE_UPDATED, MsgBody: body}
+}
+
+// delete cancels a random live order
+func (g *Generator) delete(book *symbolBook) message.Message {
+	return g.cancel(book, book.orders[g.rand.Intn(len(book.orders))])
+}
+
+// cancel deletes the live order
+func (g *Generator) cancel(book *symbolBook, order *liveOrder) message.Message {
+	book.remove(order.id)
+	body := message.MessageDeleted{
+		Symbol:  book.symbol,
+		OrderId: order.id,
+		Side:    [1]byte{order.side},
+	}
+	return message.Message{MsgType: message.MSG_TYPE_DELETED, MsgBody: body}
+}
+
+// execute trades part or all of the best priced live order on a random side
+func (g *Generator) execute(book *symbolBook) message.Message {
+	order := book.best(g.rand.Intn(2) == 0)
+	qty := order.size
+	if g.rand.Intn(2) == 0 {
+		// partial execution
+		qty = uint64(g.rand.Int63n(int64(order.size))) + 1
+	}
+	order.size -= qty
+	if order.size == 0 {
+		book.remove(order.id)
+	}
+	body := message.MessageExecuted{
+		Symbol:    book.symbol,
+		OrderId:   order.id,
+		Side:      [1]byte{order.side},
+		TradedQty: qty,
+	}
+	return message.Message{MsgType: message.MSG_TYPE_EXECUTED, MsgBody: body}
+}
+
+// price returns a price on the side of the mid, between 1 and BookWidth ticks away from it
+func (g *Generator) price(book *symbolBook, side byte) int64 {
+	width := g.config.BookWidth
+	var ticks int
+	switch g.config.PriceDist {
+	case PRICE_DIST_EXPONENTIAL:
+		// mean of a quarter of the book width, clamped to the width
+		ticks = int(g.rand.ExpFloat64()*float64(width)/4) + 1
+		if ticks > width {
+			ticks = width
+		}
+	default:
+		ticks = g.rand.Intn(width) + 1
+	}
+	offset := int64(ticks) * g.config.TickSize
+	if side == message.SIDE_BUY {
+		return book.mid - offset
+	}
+	return book.mid + offset
+}
+
+// size returns a random size between MinSize and MaxSize
+func (g *Generator) size() uint64 {
+	return g.config.MinSize + uint64(g.rand.Int63n(int64(g.config.MaxSize-g.config.MinSize+1)))
+}
+
+// trim returns the deepest order to cancel when it is further than BookWidth ticks from the mid or the book holds MaxOrders
+// it returns nil when the book is within its limits
+func (g *Generator) trim(book *symbolBook) *liveOrder {
+	width := g.config.TickSize * int64(g.config.BookWidth)
+	buy, sell := book.side(message.SIDE_BUY).deepest(), book.side(message.SIDE_SELL).deepest()
+	if buy != nil && book.mid-buy.price > width {
+		return buy
+	}
+	if sell != nil && sell.price-book.mid > width {
+		return sell
+	}
+	if g.config.MaxOrders == 0 || len(book.orders) < g.config.MaxOrders {
+		return nil
+	}
+	// the order furthest from the mid
+	if buy == nil || (sell != nil && sell.price-book.mid > book.mid-buy.price) {
+		return sell
+	}
+	return buy
+}
+
+// drift randomly moves the mid of the book by one tick
+// the mid stays between the best buy and sell so that newly placed orders never cross the book
+func (g *Generator) drift(book *symbolBook) {
+	if g.rand.Float64() >= g.config.MidDrift {
+		return
+	}
+	step := g.config.TickSize
+	if g.rand.Intn(2) == 0 {
+		step = -step
+	}
+	mid := book.mid + step
+	width := g.config.TickSize * int64(g.config.BookWidth)
+	// the default schema encodes the price in 4 bytes
+	if mid-width <= 0 || mid+width > math.MaxInt32 {
+		return
+	}
+	if bestBuy := book.side(message.SIDE_BUY).best(); bestBuy != nil && mid < bestBuy.price {
+		return
+	}
+	if bestSell := book.side(message.SIDE_SELL).best(); bestSell != nil && mid > bestSell.price {
+		return
+	}
+	book.mid = mid
+}
+
+// remove deletes the order from the live orders
+func (b *symbolBook) remove(id uint64) {
+	i := b.index[id]
+	b.side(b.orders[i].side).remove(b.orders[i])
+	last := len(b.orders) - 1
+	b.orders[i] = b.orders[last]
+	b.index[b.orders[i].id] = i
+	b.orders = b.orders[:last]
+	delete(b.index, id)
+}
+
+// side returns the live orders of the side
+func (b *symbolBook) side(side byte) *bookSide {
+	if side == message.SIDE_BUY {
+		return b.sides[0]
+	}
+	return b.sides[1]
+}
+
+// best returns the highest buy or lowest sell live order, falling back to the other side if it is empty
+func (b *symbolBook) best(buy bool) *liveOrder {
+	side, otherSide := b.sides[0], b.sides[1]
+	if !buy {
+		side, otherSide = otherSide, side
+	}
+	if order := side.best(); order != nil {
+		return order
+	}
+	return otherSide.best()
+}
+
+// insert adds the order at the end of its price level
+func (s *bookSide) insert(order *liveOrder) {
+	if _, ok := s.levels[order.price]; !ok {
+		i := s.search(order.price)
+		s.prices = append(s.prices, 0)
+		copy(s.prices[i+1:], s.prices[i:])
+		s.prices[i] = order.price
+	}
+	s.levels[order.price] = append(s.levels[order.price], order)
+}
+
+// remove deletes the order from its price level, the price is dropped once no order is left on it
+func (s *bookSide) remove(order *liveOrder) {
+	level := s.levels[order.price]
+	for i, levelOrder := range level {
+		if levelOrder == order {
+			level = append(level[:i], level[i+1:]...)
+			break
+		}
+	}
+	if len(level) > 0 {
+		s.levels[order.price] = level
+		return
+	}
+	delete(s.levels, order.price)
+	i := s.search(order.price)
+	s.prices = append(s.prices[:i], s.prices[i+1:]...)
+}
+
+// search returns the position of the price in prices, or where it would be inserted
+func (s *bookSide) search(price int64) int {
+	return sort.Search(len(s.prices), func(i int) bool {
+		if s.buy {
+			return s.prices[i] <= price
+		}
+		return s.prices[i] >= price
+	})
+}
+
+// best returns the first order of the best price or nil if the side is empty
+func (s *bookSide) best() *liveOrder {
+	if len(s.prices) == 0 {
+		return nil
+	}
+	return s.levels[s.prices[0]][0]
+}
+
+// deepest returns the last order of the worst price or nil if the side is empty
+func (s *bookSide) deepest() *liveOrder {
+	if len(s.prices) == 0 {
+		return nil
+	}
+	level := s.levels[s.prices[len(s.prices)-1]]
+	return level[len(level)-1]
+}
@@ -0,0 +1,96 @@
+package generator
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db/dbtest"
+	db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generator", func() {
+	config := &config.Config{}
+	config.Stream.HeaderLength = 8
+	config.OrderBook.Depth = 5
+
+	Describe("NewGenerator", func() {
+		Context("with a symbol that is not 3 bytes", func() {
+			It("should return an error", func() {
+				genConfig := DefaultConfig()
+				genConfig.Symbols = []string{"VC10"}
+				_, err := NewGenerator(genConfig)
+				Expect(err).To(Not(BeNil()))
+			})
+		})
+	})
+
+	Describe("WriteTo", func() {
+		Context("with the default config", func() {
+			It("should write a feed that the order book accepts without errors", func() {
+				gen, err := NewGenerator(DefaultConfig())
+				Expect(err).To(BeNil())
+				var feed bytes.Buffer
+				Expect(gen.WriteTo(&feed, 20000)).To(Succeed())
+
+				orderBookDb := db.NewOrderBookDb(config)
+				frameReader := stream_handler.NewFrameReader(config, &feed)
+				counts := make(map[string]int)
+				expectedSeq := uint32(1)
+				for {
+					frame, err := frameReader.Next()
+					if err == io.EOF {
+						break
+					}
+					Expect(err).To(BeNil())
+					Expect(frame.Header.Seq).To(Equal(expectedSeq))
+					expectedSeq++
+					msg, err := stream_handler.ParseMsg(frame.MsgType, frame.Body)
+					Expect(err).To(BeNil())
+					counts[msg.MsgType]++
+					_, err = dbtest.Apply(orderBookDb, msg)
+					Expect(err).To(BeNil())
+				}
+				Expect(expectedSeq).To(Equal(uint32(20001)))
+				for _, msgType := range []string{message.MSG_TYPE_ADDED, message.MSG_TYPE_UPDATED, message.MSG_TYPE_DELETED, message.MSG_TYPE_EXECUTED} {
+					Expect(counts[msgType]).To(BeNumerically(">", 0))
+				}
+			})
+		})
+
+		Context("with a max number of orders", func() {
+			It("should keep every book within MaxOrders and BookWidth ticks of the mid", func() {
+				genConfig := DefaultConfig()
+				genConfig.MaxOrders = 50
+				gen, err := NewGenerator(genConfig)
+				Expect(err).To(BeNil())
+				width := genConfig.TickSize * int64(genConfig.BookWidth)
+				for i := 0; i < 20000; i++ {
+					gen.Next()
+					for _, book := range gen.books {
+						Expect(len(book.orders)).To(BeNumerically("<=", genConfig.MaxOrders))
+						for _, order := range book.orders {
+							// an order is cancelled on the next msg of its book once the mid drifts away from it
+							Expect(order.price).To(BeNumerically("~", book.mid, width+genConfig.TickSize))
+						}
+					}
+				}
+			})
+		})
+
+		Context("with the same seed", func() {
+			It("should generate the same feed", func() {
+				var first, second bytes.Buffer
+				gen, _ := NewGenerator(DefaultConfig())
+				Expect(gen.WriteTo(&first, 1000)).To(Succeed())
+				gen, _ = NewGenerator(DefaultConfig())
+				Expect(gen.WriteTo(&second, 1000)).To(Succeed())
+				Expect(first.Bytes()).To(Equal(second.Bytes()))
+			})
+		})
+	})
+})
@@ -0,0 +1,13 @@
+package http_api_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestHttpApi(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "HttpApi Suite")
+}
@@ -0,0 +1,360 @@
+// Package http_api serves the state of the books over HTTP while the stream is processed
+package http_api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/order_book"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/websocket"
+)
+
+// SHUTDOWN_TIMEOUT is how long the in flight requests are waited for when the server stops
+const SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// Pipeline is the part of orderbook.Pipeline the server reads the books from
+type Pipeline interface {
+	Query(fn func(order_book.Snapshot)) error // runs fn with a Snapshot of every book after the same msg
+	// registers fn for the depth changes of the watched symbols, before the pipeline runs
+	OnWatchedDepthChanged(depth int, watched func(message.Symbol) bool, fn func(order_book.DepthEvent))
+}
+
+// Server answers the queries of the book state, every response is read from a single Snapshot
+//
+//	GET /symbols                          every symbol with a book
+//	GET /symbols/{symbol}/depth?depth=N   top N levels, the depth of the symbol by default
+//	GET /symbols/{symbol}/orders          every resting order
+//	GET /symbols/{symbol}/orders/{id}     a single order by OrderId
+//	GET /symbols/{symbol}/stats           size of the book and the trades of the symbol
+//	GET /stream                           WebSocket of the depth of the subscribed symbols
+//	GET /metrics                          Prometheus metrics, once HandleMetrics is called
+type Server struct {
+	config   *config.Config
+	pipeline Pipeline
+	hub      *hub         // WebSocket clients of /stream
+	metrics  http.Handler // nil when the metrics are not served
+}
+
+// NewServer return an instance of Server, it must be called before the pipeline runs as it registers the depth changes sent to the WebSocket clients
+func NewServer(config *config.Config, pipeline Pipeline) *Server {
+	s := &Server{config: config, pipeline: pipeline, hub: newHub(config.StreamMaxDepth())}
+	pipeline.OnWatchedDepthChanged(s.hub.maxDepth, s.hub.watched, s.hub.onDepthChanged)
+	return s
+}
+
+// HandleMetrics serves the metrics of gatherer on /metrics, it must be called before the server starts
+func (s *Server) HandleMetrics(gatherer prometheus.Gatherer) {
+	s.metrics = promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+}
+
+// Start serves on address until ctx is cancelled, then waits for the in flight requests
+// returns nil once stopped, or the error of the listener
+func (s *Server) Start(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves on listener until ctx is cancelled, the WebSocket clients are sent their pending msg before they are disconnected
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: s, ReadHeaderTimeout: SHUTDOWN_TIMEOUT}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		// the hijacked WebSocket connections are not closed by Shutdown
+		s.hub.close()
+	}()
+	log.Printf("http api listening on %s \n", listener.Addr())
+	err := server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+		return nil
+	}
+	return err
+}
+
+// level is a depth level of the responses
+type level struct {
+	Price  int64  `json:"price"`
+	Volume uint64 `json:"volume"`
+}
+
+// order is a resting order of the responses
+type order struct {
+	OrderId uint64 `json:"orderId"`
+	Side    string `json:"side"`
+	Price   int64  `json:"price"`
+	Volume  uint64 `json:"volume"`
+}
+
+// symbolsResponse is the response of /symbols
+type symbolsResponse struct {
+	Seq     uint32   `json:"seq"`
+	Symbols []string `json:"symbols"`
+}
+
+// depthResponse is the response of /symbols/{symbol}/depth
+type depthResponse struct {
+	Seq    uint32  `json:"seq"`
+	Symbol string  `json:"symbol"`
+	Scale  int     `json:"scale"` // decimal places of the raw prices
+	Buy    []level `json:"buy"`
+	Sell   []level `json:"sell"`
+}
+
+// ordersResponse is the response of /symbols/{symbol}/orders
+type ordersResponse struct {
+	Seq    uint32  `json:"seq"`
+	Symbol string  `json:"symbol"`
+	Scale  int     `json:"scale"`
+	Buy    []order `json:"buy"`
+	Sell   []order `json:"sell"`
+}
+
+// orderResponse is the response of /symbols/{symbol}/orders/{id}
+type orderResponse struct {
+	Seq    uint32 `json:"seq"`
+	Symbol string `json:"symbol"`
+	Scale  int    `json:"scale"`
+	order
+}
+
+// statsResponse is the response of /symbols/{symbol}/stats
+type statsResponse struct {
+	Seq        uint32 `json:"seq"`
+	Symbol     string `json:"symbol"`
+	Scale      int    `json:"scale"`
+	BuyOrders  int    `json:"buyOrders"`
+	SellOrders int    `json:"sellOrders"`
+	BuyLevels  int    `json:"buyLevels"`
+	SellLevels int    `json:"sellLevels"`
+	BuyVolume  uint64 `json:"buyVolume"`
+	SellVolume uint64 `json:"sellVolume"`
+	BestBuy    *level `json:"bestBuy"`   // null when the side is empty
+	BestSell   *level `json:"bestSell"`  // null when the side is empty
+	LastPrice  *int64 `json:"lastPrice"` // null when the symbol never traded
+	Volume     uint64 `json:"volume"`    // cumulative traded qty
+	Trades     uint64 `json:"trades"`
+}
+
+// errorResponse is the body of every error
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpError is an error with the status it is answered with
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e httpError) Error() string {
+	return e.err.Error()
+}
+
+func notFound(format string, args ...interface{}) error {
+	return httpError{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+// ServeHTTP routes the request, the response is built inside a single Query so that it is consistent
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "only GET is supported"})
+		return
+	}
+	if r.URL.Path == "/metrics" && s.metrics != nil {
+		s.metrics.ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path == "/stream" {
+		// browsers connect from the origin of the dashboard, the api is read only so every origin is accepted
+		websocket.Server{Handler: s.serveStream}.ServeHTTP(w, r)
+		return
+	}
+	var response interface{}
+	var err error
+	if queryErr := s.pipeline.Query(func(snapshot order_book.Snapshot) {
+		response, err = s.route(snapshot, r)
+	}); queryErr != nil {
+		err = httpError{http.StatusServiceUnavailable, queryErr}
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		var statusErr httpError
+		if errors.As(err, &statusErr) {
+			status = statusErr.status
+		}
+		writeJSON(w, status, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// route returns the response of the path read from the snapshot
+func (s *Server) route(snapshot order_book.Snapshot, r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "symbols" {
+		return nil, notFound("unknown path %s", r.URL.Path)
+	}
+	if len(parts) == 1 {
+		return s.symbols(snapshot), nil
+	}
+	symbol := message.NewSymbol(parts[1])
+	if !hasBook(snapshot, symbol) {
+		return nil, notFound("symbol %s has no book", parts[1])
+	}
+	switch {
+	case len(parts) == 3 && parts[2] == "depth":
+		return s.depth(snapshot, symbol, r.URL.Query().Get("depth"))
+	case len(parts) == 3 && parts[2] == "orders":
+		return s.orders(snapshot, symbol)
+	case len(parts) == 4 && parts[2] == "orders":
+		return s.order(snapshot, symbol, parts[3])
+	case len(parts) == 3 && parts[2] == "stats":
+		return s.stats(snapshot, symbol)
+	}
+	return nil, notFound("unknown path %s", r.URL.Path)
+}
+
+func (s *Server) symbols(snapshot order_book.Snapshot) symbolsResponse {
+	response := symbolsResponse{Seq: snapshot.Seq, Symbols: make([]string, 0)}
+	for _, symbol := range snapshot.Db.Symbols() {
+		response.Symbols = append(response.Symbols, symbol.String())
+	}
+	return response
+}
+
+func (s *Server) depth(snapshot order_book.Snapshot, symbol message.Symbol, depthParam string) (interface{}, error) {
+	depth := snapshot.Depth(symbol)
+	if depthParam != "" {
+		var err error
+		if depth, err = strconv.Atoi(depthParam); err != nil || depth < 1 {
+			return nil, badRequest("depth must be a positive number, got %q", depthParam)
+		}
+	}
+	buy, sell, err := snapshot.Db.Depth(symbol, depth)
+	if err != nil {
+		return nil, err
+	}
+	return depthResponse{Seq: snapshot.Seq, Symbol: symbol.String(), Scale: snapshot.Scale(symbol), Buy: levels(buy), Sell: levels(sell)}, nil
+}
+
+func (s *Server) orders(snapshot order_book.Snapshot, symbol message.Symbol) (interface{}, error) {
+	buy, sell, err := snapshot.Db.Orders(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return ordersResponse{Seq: snapshot.Seq, Symbol: symbol.String(), Scale: snapshot.Scale(symbol), Buy: orders(buy), Sell: orders(sell)}, nil
+}
+
+func (s *Server) order(snapshot order_book.Snapshot, symbol message.Symbol, idParam string) (interface{}, error) {
+	orderId, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		return nil, badRequest("invalid OrderId %q", idParam)
+	}
+	// the OrderId is unique per side, the buy side is looked up first
+	for _, side := range []byte{message.SIDE_BUY, message.SIDE_SELL} {
+		price, volume, err := snapshot.Db.Order(symbol, side, orderId)
+		if err == nil {
+			return orderResponse{
+				Seq:    snapshot.Seq,
+				Symbol: symbol.String(),
+				Scale:  snapshot.Scale(symbol),
+				order:  order{OrderId: orderId, Side: string(side), Price: price, Volume: volume},
+			}, nil
+		}
+	}
+	return nil, notFound("OrderId %d does not exist for %s", orderId, symbol.String())
+}
+
+func (s *Server) stats(snapshot order_book.Snapshot, symbol message.Symbol) (interface{}, error) {
+	stats, err := snapshot.Db.Stats(symbol)
+	if err != nil {
+		return nil, err
+	}
+	buy, sell, err := snapshot.Db.Depth(symbol, 1)
+	if err != nil {
+		return nil, err
+	}
+	response := statsResponse{
+		Seq:        snapshot.Seq,
+		Symbol:     symbol.String(),
+		Scale:      snapshot.Scale(symbol),
+		BuyOrders:  stats.BuyOrders,
+		SellOrders: stats.SellOrders,
+		BuyLevels:  stats.BuyLevels,
+		SellLevels: stats.SellLevels,
+		BuyVolume:  stats.BuyVolume,
+		SellVolume: stats.SellVolume,
+	}
+	if len(buy) > 0 {
+		response.BestBuy = &levels(buy)[0]
+	}
+	if len(sell) > 0 {
+		response.BestSell = &levels(sell)[0]
+	}
+	if trades, ok := snapshot.TradeStats(symbol); ok {
+		response.LastPrice = &trades.LastPrice
+		response.Volume = trades.Volume
+		response.Trades = trades.Count
+	}
+	return response, nil
+}
+
+// hasBook reports whether the symbol received a msg
+func hasBook(snapshot order_book.Snapshot, symbol message.Symbol) bool {
+	for _, known := range snapshot.Db.Symbols() {
+		if known == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+func levels(dbLevels []db.Level) []level {
+	converted := make([]level, 0, len(dbLevels))
+	for _, dbLevel := range dbLevels {
+		converted = append(converted, level{Price: dbLevel.Price, Volume: dbLevel.Volume})
+	}
+	return converted
+}
+
+func orders(dbOrders []db.Order) []order {
+	converted := make([]order, 0, len(dbOrders))
+	for _, dbOrder := range dbOrders {
+		converted = append(converted, order{OrderId: dbOrder.OrderId, Side: string(dbOrder.Side), Price: dbOrder.Price, Volume: dbOrder.Volume})
+	}
+	return converted
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("unable to write the http response: %s \n", err.Error())
+	}
+}
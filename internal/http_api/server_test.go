@@ -0,0 +1,200 @@
+package http_api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	configPkg "github.com/albertsundjaja/order_book/config"
+	inmem_db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/order_book"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Server", func() {
+	symbol := message.NewSymbol("VC0")
+	var server *Server
+
+	added := func(seq uint32, orderId uint64, side byte, price int64, size uint64) message.Message {
+		return message.Message{
+			Symbol:    symbol,
+			MsgType:   message.MSG_TYPE_ADDED,
+			MsgHeader: message.Header{Seq: seq},
+			MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: orderId, Side: [1]byte{side}, Price: price, Size: size},
+		}
+	}
+	// get returns the status and the decoded body of the request
+	get := func(method string, path string) (int, map[string]interface{}) {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		body := make(map[string]interface{})
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &body)).To(Succeed())
+		return recorder.Code, body
+	}
+
+	BeforeEach(func() {
+		config := &configPkg.Config{}
+		config.OrderBook.Depth = 2
+		config.OrderBook.Scale = 2
+		streamChan := make(chan message.Message)
+		manager := order_book.NewOrderBookManager(config, streamChan, nil, inmem_db.NewOrderBookDb(config))
+		for _, msg := range []message.Message{
+			added(1, 1, message.SIDE_BUY, 100, 10),
+			added(2, 2, message.SIDE_BUY, 99, 5),
+			added(3, 3, message.SIDE_BUY, 98, 1),
+			added(4, 4, message.SIDE_SELL, 101, 7),
+			{
+				Symbol:    symbol,
+				MsgType:   message.MSG_TYPE_EXECUTED,
+				MsgHeader: message.Header{Seq: 5},
+				MsgBody:   message.MessageExecuted{Symbol: symbol, OrderId: 4, Side: [1]byte{message.SIDE_SELL}, TradedQty: 2},
+			},
+		} {
+			Expect(manager.Apply(msg)).To(Succeed())
+		}
+		close(streamChan)
+		Expect(manager.ProcessMessage()).To(Succeed())
+		server = NewServer(config, manager)
+	})
+
+	Context("listing the symbols", func() {
+		It("should return the symbols with the seq", func() {
+			status, body := get(http.MethodGet, "/symbols")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal(map[string]interface{}{"seq": 5.0, "symbols": []interface{}{"VC0"}}))
+		})
+	})
+
+	Context("reading the depth", func() {
+		It("should return the depth of the symbol by default", func() {
+			status, body := get(http.MethodGet, "/symbols/VC0/depth")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body["scale"]).To(Equal(2.0))
+			Expect(body["buy"]).To(HaveLen(2))
+			Expect(body["sell"]).To(Equal([]interface{}{map[string]interface{}{"price": 101.0, "volume": 5.0}}))
+		})
+
+		It("should return the requested depth", func() {
+			status, body := get(http.MethodGet, "/symbols/VC0/depth?depth=3")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body["buy"]).To(HaveLen(3))
+		})
+
+		for _, depth := range []string{"0", "-1", "abc"} {
+			depth := depth
+			It(fmt.Sprintf("should reject the depth %q", depth), func() {
+				status, body := get(http.MethodGet, "/symbols/VC0/depth?depth="+depth)
+				Expect(status).To(Equal(http.StatusBadRequest))
+				Expect(body["error"]).To(ContainSubstring("depth must be a positive number"))
+			})
+		}
+	})
+
+	Context("reading the orders", func() {
+		It("should return every resting order best price first", func() {
+			status, body := get(http.MethodGet, "/symbols/VC0/orders")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body["buy"]).To(HaveLen(3))
+			Expect(body["buy"].([]interface{})[0]).To(Equal(map[string]interface{}{"orderId": 1.0, "side": "B", "price": 100.0, "volume": 10.0}))
+			Expect(body["sell"]).To(Equal([]interface{}{map[string]interface{}{"orderId": 4.0, "side": "S", "price": 101.0, "volume": 5.0}}))
+		})
+
+		It("should look up a single order on either side", func() {
+			status, body := get(http.MethodGet, "/symbols/VC0/orders/4")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal(map[string]interface{}{"seq": 5.0, "symbol": "VC0", "scale": 2.0, "orderId": 4.0, "side": "S", "price": 101.0, "volume": 5.0}))
+		})
+
+		It("should not find an unknown order", func() {
+			status, body := get(http.MethodGet, "/symbols/VC0/orders/9")
+			Expect(status).To(Equal(http.StatusNotFound))
+			Expect(body["error"]).To(Equal("OrderId 9 does not exist for VC0"))
+		})
+	})
+
+	Context("reading the stats", func() {
+		It("should return the size of the book and the trades", func() {
+			status, body := get(http.MethodGet, "/symbols/VC0/stats")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body["buyOrders"]).To(Equal(3.0))
+			Expect(body["sellVolume"]).To(Equal(5.0))
+			Expect(body["bestBuy"]).To(Equal(map[string]interface{}{"price": 100.0, "volume": 10.0}))
+			Expect(body["lastPrice"]).To(Equal(101.0))
+			Expect(body["volume"]).To(Equal(2.0))
+			Expect(body["trades"]).To(Equal(1.0))
+		})
+	})
+
+	Context("with an unknown symbol or path", func() {
+		for _, path := range []string{"/symbols/VC9/depth", "/symbols/VC0/unknown", "/books"} {
+			path := path
+			It(fmt.Sprintf("should answer %s with not found", path), func() {
+				status, body := get(http.MethodGet, path)
+				Expect(status).To(Equal(http.StatusNotFound))
+				Expect(body).To(HaveKey("error"))
+			})
+		}
+	})
+
+	Context("serving the metrics", func() {
+		It("should only answer /metrics once HandleMetrics is called", func() {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "served_total", Help: "Test counter."}))
+			server.HandleMetrics(registry)
+			recorder = httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(ContainSubstring("served_total 0"))
+		})
+	})
+
+	Context("with a method other than GET", func() {
+		It("should answer method not allowed", func() {
+			status, _ := get(http.MethodPost, "/symbols")
+			Expect(status).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Context("when the pipeline did not start", func() {
+		It("should answer service unavailable instead of waiting", func() {
+			config := &configPkg.Config{}
+			manager := order_book.NewOrderBookManager(config, nil, nil, inmem_db.NewOrderBookDb(config))
+			manager.Stop()
+			server = NewServer(config, manager)
+			status, body := get(http.MethodGet, "/symbols")
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+			Expect(body["error"]).To(Equal(order_book.ErrNotProcessing.Error()))
+		})
+	})
+
+	Context("serving on a listener", func() {
+		It("should answer until the context is cancelled", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			ctx, cancel := context.WithCancel(context.Background())
+			served := make(chan error, 1)
+			go func() {
+				served <- server.Serve(ctx, listener)
+			}()
+
+			response, err := http.Get("http://" + listener.Addr().String() + "/symbols")
+			Expect(err).To(BeNil())
+			response.Body.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+			cancel()
+			Eventually(served).Should(Receive(BeNil()))
+		})
+	})
+})
@@ -0,0 +1,367 @@
+package http_api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/order_book"
+	"golang.org/x/net/websocket"
+)
+
+// STREAM_WRITE_TIMEOUT is how long a write to a WebSocket client can take before the client is dropped
+const STREAM_WRITE_TIMEOUT = 10 * time.Second
+
+// STREAM_MAX_REQUEST is the largest request a WebSocket client can send
+const STREAM_MAX_REQUEST = 4096
+
+// actions of the WebSocket requests
+const (
+	ACTION_SUBSCRIBE   = "subscribe"
+	ACTION_UNSUBSCRIBE = "unsubscribe"
+)
+
+// types of the WebSocket msg
+const (
+	STREAM_SNAPSHOT = "snapshot"
+	STREAM_UPDATE   = "update"
+	STREAM_ERROR    = "error"
+)
+
+// streamRequest is a request of a WebSocket client
+// e.g. {"action": "subscribe", "symbol": "VC0", "depth": 5}, depth 0 means the depth of the symbol
+type streamRequest struct {
+	Action string `json:"action"`
+	Symbol string `json:"symbol"`
+	Depth  int    `json:"depth"`
+}
+
+// streamMessage is the depth of a subscribed symbol, the snapshot is sent first then an update whenever the subscribed levels change
+type streamMessage struct {
+	Type      string  `json:"type"`
+	Seq       uint32  `json:"seq"`
+	Symbol    string  `json:"symbol"`
+	Scale     int     `json:"scale"`
+	Depth     int     `json:"depth"`
+	Buy       []level `json:"buy"`
+	Sell      []level `json:"sell"`
+	Conflated uint64  `json:"conflated"` // updates replaced by this one while the client was not keeping up
+}
+
+// streamError is sent for a request that could not be applied
+type streamError struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// hub fans the depth changes out to the WebSocket clients
+// it is called on the processing routine and never waits on a client, a slow client only gets the latest levels of each symbol
+type hub struct {
+	mu          sync.Mutex
+	maxDepth    int // depth of the levels received from the OrderBookManager
+	subscribers map[message.Symbol]map[*streamClient]bool
+	clients     map[*streamClient]bool
+	closed      bool
+	done        chan struct{}  // closed when the server stops
+	handlers    sync.WaitGroup // running connections
+}
+
+func newHub(maxDepth int) *hub {
+	return &hub{
+		maxDepth:    maxDepth,
+		subscribers: make(map[message.Symbol]map[*streamClient]bool),
+		clients:     make(map[*streamClient]bool),
+		done:        make(chan struct{}),
+	}
+}
+
+// watched reports whether a client subscribed to the symbol, the levels are only read for the watched symbols
+func (h *hub) watched(symbol message.Symbol) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers[symbol]) > 0
+}
+
+// onDepthChanged queues the levels to every subscriber of the symbol
+func (h *hub) onDepthChanged(event order_book.DepthEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.subscribers[event.Symbol] {
+		client.queue(STREAM_UPDATE, event)
+	}
+}
+
+// add registers the client, false once the hub is closed
+func (h *hub) add(client *streamClient) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.clients[client] = true
+	h.handlers.Add(1)
+	return true
+}
+
+// remove unsubscribes the client from every symbol
+func (h *hub) remove(client *streamClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, clients := range h.subscribers {
+		delete(clients, client)
+	}
+	delete(h.clients, client)
+	h.handlers.Done()
+}
+
+// subscribe queues the snapshot of the symbol and registers the client for its updates
+// it runs inside a Query so that no update is missed or sent twice between the snapshot and the first update
+func (h *hub) subscribe(snapshot order_book.Snapshot, client *streamClient, symbol message.Symbol, depth int) error {
+	if depth == 0 {
+		depth = snapshot.Depth(symbol)
+		if depth < 1 {
+			depth = config.DEFAULT_DEPTH
+		}
+		if depth > h.maxDepth {
+			depth = h.maxDepth
+		}
+	}
+	if depth < 1 || depth > h.maxDepth {
+		return fmt.Errorf("depth must be between 1 and %d, got %d", h.maxDepth, depth)
+	}
+	event := order_book.DepthEvent{Seq: snapshot.Seq, Symbol: symbol, Buy: []db.Level{}, Sell: []db.Level{}, Scale: snapshot.Scale(symbol)}
+	if hasBook(snapshot, symbol) {
+		var err error
+		if event.Buy, event.Sell, err = snapshot.Db.Depth(symbol, depth); err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.clients[client] {
+		return nil
+	}
+	if h.subscribers[symbol] == nil {
+		h.subscribers[symbol] = make(map[*streamClient]bool)
+	}
+	h.subscribers[symbol][client] = true
+	client.subscribe(symbol, depth)
+	client.queue(STREAM_SNAPSHOT, event)
+	return nil
+}
+
+// unsubscribe stops the updates of the symbol to the client
+func (h *hub) unsubscribe(client *streamClient, symbol message.Symbol) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers[symbol], client)
+	client.unsubscribe(symbol)
+}
+
+// close makes every client send its pending msg and disconnect, then waits for the connections to end
+func (h *hub) close() {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.done)
+	}
+	h.mu.Unlock()
+	h.handlers.Wait()
+}
+
+// streamClient is a WebSocket connection, its msg are written by its own routine
+type streamClient struct {
+	conn          *websocket.Conn
+	mu            sync.Mutex
+	subscriptions map[message.Symbol]*subscription
+	queued        []message.Symbol // symbols with a pending msg, in the order they were queued
+	notify        chan struct{}    // signalled when a msg is queued
+	stop          chan struct{}    // closed when the client disconnected
+}
+
+// subscription is the depth a client subscribed to for a symbol
+type subscription struct {
+	depth     int
+	buy, sell []db.Level     // levels of the last queued msg
+	pending   *streamMessage // msg not written yet, replaced by the next one
+}
+
+func newStreamClient(conn *websocket.Conn) *streamClient {
+	return &streamClient{
+		conn:          conn,
+		subscriptions: make(map[message.Symbol]*subscription),
+		notify:        make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+	}
+}
+
+// subscribe replaces the subscription of the symbol
+func (c *streamClient) subscribe(symbol message.Symbol, depth int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscriptions[symbol] = &subscription{depth: depth}
+}
+
+func (c *streamClient) unsubscribe(symbol message.Symbol) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subscriptions, symbol)
+}
+
+// queue makes the levels of the event the next msg of the symbol, when the previous one was not written yet it is replaced
+// an update that does not change the subscribed levels, i.e. a change deeper than the depth, is not queued
+func (c *streamClient) queue(kind string, event order_book.DepthEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sub, ok := c.subscriptions[event.Symbol]
+	if !ok {
+		return
+	}
+	buy, sell := top(event.Buy, sub.depth), top(event.Sell, sub.depth)
+	if kind == STREAM_UPDATE && sameLevels(buy, sub.buy) && sameLevels(sell, sub.sell) {
+		return
+	}
+	sub.buy, sub.sell = buy, sell
+	msg := &streamMessage{Type: kind, Seq: event.Seq, Symbol: event.Symbol.String(), Scale: event.Scale, Depth: sub.depth, Buy: levels(buy), Sell: levels(sell)}
+	if sub.pending != nil {
+		// a snapshot that was not written yet stays a snapshot with the latest levels
+		if sub.pending.Type == STREAM_SNAPSHOT {
+			msg.Type = STREAM_SNAPSHOT
+		}
+		msg.Conflated = sub.pending.Conflated + 1
+	} else {
+		c.queued = append(c.queued, event.Symbol)
+	}
+	sub.pending = msg
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending msg in the order they were queued
+func (c *streamClient) take() []*streamMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msgs := make([]*streamMessage, 0, len(c.queued))
+	for _, symbol := range c.queued {
+		// an unsubscribed symbol has no subscription, a symbol subscribed again can be queued twice
+		if sub, ok := c.subscriptions[symbol]; ok && sub.pending != nil {
+			msgs = append(msgs, sub.pending)
+			sub.pending = nil
+		}
+	}
+	c.queued = c.queued[:0]
+	return msgs
+}
+
+// write writes the queued msg until the client disconnects, or until done is closed after which the pending msg are written once more
+func (c *streamClient) write(done <-chan struct{}) error {
+	for {
+		stopping := false
+		select {
+		case <-c.notify:
+		case <-c.stop:
+			return nil
+		case <-done:
+			stopping = true
+		}
+		for _, msg := range c.take() {
+			if err := c.send(msg); err != nil {
+				return err
+			}
+		}
+		if stopping {
+			return nil
+		}
+	}
+}
+
+// send writes v as json, it is safe to call from the reading and the writing routine
+func (c *streamClient) send(v interface{}) error {
+	c.conn.SetWriteDeadline(time.Now().Add(STREAM_WRITE_TIMEOUT))
+	return websocket.JSON.Send(c.conn, v)
+}
+
+// serveStream reads the requests of a WebSocket client while its routine writes the depth of its subscriptions
+func (s *Server) serveStream(conn *websocket.Conn) {
+	conn.MaxPayloadBytes = STREAM_MAX_REQUEST
+	client := newStreamClient(conn)
+	if !s.hub.add(client) {
+		return
+	}
+	defer s.hub.remove(client)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		if err := client.write(s.hub.done); err != nil {
+			log.Printf("unable to write to websocket client %s: %s \n", conn.Request().RemoteAddr, err.Error())
+		}
+		// unblocks the reading of the requests
+		conn.Close()
+	}()
+	for {
+		var raw []byte
+		if err := websocket.Message.Receive(conn, &raw); err != nil {
+			break
+		}
+		if err := s.handleStream(client, raw); err != nil {
+			if err = client.send(streamError{Type: STREAM_ERROR, Error: err.Error()}); err != nil {
+				break
+			}
+		}
+	}
+	close(client.stop)
+	<-written
+}
+
+// handleStream applies a request of the client
+func (s *Server) handleStream(client *streamClient, raw []byte) error {
+	var request streamRequest
+	if err := json.Unmarshal(raw, &request); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	if request.Symbol == "" || len(request.Symbol) > len(message.Symbol{}) {
+		return fmt.Errorf("invalid symbol %q", request.Symbol)
+	}
+	symbol := message.NewSymbol(request.Symbol)
+	switch request.Action {
+	case ACTION_SUBSCRIBE:
+		var err error
+		if queryErr := s.pipeline.Query(func(snapshot order_book.Snapshot) {
+			err = s.hub.subscribe(snapshot, client, symbol, request.Depth)
+		}); queryErr != nil {
+			return queryErr
+		}
+		return err
+	case ACTION_UNSUBSCRIBE:
+		s.hub.unsubscribe(client, symbol)
+		return nil
+	}
+	return fmt.Errorf("unknown action %q, expected %s or %s", request.Action, ACTION_SUBSCRIBE, ACTION_UNSUBSCRIBE)
+}
+
+// top returns the first depth levels
+func top(levels []db.Level, depth int) []db.Level {
+	if len(levels) > depth {
+		return levels[:depth]
+	}
+	return levels
+}
+
+func sameLevels(a []db.Level, b []db.Level) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
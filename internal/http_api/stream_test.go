@@ -0,0 +1,227 @@
+package http_api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	configPkg "github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db"
+	inmem_db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/order_book"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+)
+
+var _ = Describe("Stream", func() {
+	symbol := message.NewSymbol("VC0")
+
+	added := func(seq uint32, orderId uint64, price int64) message.Message {
+		return message.Message{
+			Symbol:    symbol,
+			MsgType:   message.MSG_TYPE_ADDED,
+			MsgHeader: message.Header{Seq: seq},
+			MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: orderId, Side: [1]byte{message.SIDE_BUY}, Price: price, Size: 10},
+		}
+	}
+
+	Describe("conflation", func() {
+		var client *streamClient
+		event := func(seq uint32, prices ...int64) order_book.DepthEvent {
+			event := order_book.DepthEvent{Seq: seq, Symbol: symbol, Buy: []db.Level{}, Sell: []db.Level{}}
+			for _, price := range prices {
+				event.Buy = append(event.Buy, db.Level{Price: price, Volume: 10})
+			}
+			return event
+		}
+
+		BeforeEach(func() {
+			client = newStreamClient(nil)
+			client.subscribe(symbol, 2)
+		})
+
+		Context("with updates queued faster than they are written", func() {
+			It("should only keep the latest levels", func() {
+				client.queue(STREAM_UPDATE, event(1, 100))
+				client.queue(STREAM_UPDATE, event(2, 101, 100))
+				client.queue(STREAM_UPDATE, event(3, 102, 101, 100))
+				msgs := client.take()
+				Expect(msgs).To(HaveLen(1))
+				Expect(msgs[0].Seq).To(Equal(uint32(3)))
+				Expect(msgs[0].Buy).To(Equal([]level{{Price: 102, Volume: 10}, {Price: 101, Volume: 10}}))
+				Expect(msgs[0].Conflated).To(Equal(uint64(2)))
+				Expect(client.take()).To(BeEmpty())
+			})
+		})
+
+		Context("with an update of a snapshot that was not written", func() {
+			It("should stay a snapshot", func() {
+				client.queue(STREAM_SNAPSHOT, event(1, 100))
+				client.queue(STREAM_UPDATE, event(2, 101))
+				msgs := client.take()
+				Expect(msgs).To(HaveLen(1))
+				Expect(msgs[0].Type).To(Equal(STREAM_SNAPSHOT))
+				Expect(msgs[0].Seq).To(Equal(uint32(2)))
+			})
+		})
+
+		Context("with a change deeper than the subscribed depth", func() {
+			It("should not queue an update", func() {
+				client.queue(STREAM_SNAPSHOT, event(1, 102, 101))
+				client.take()
+				client.queue(STREAM_UPDATE, event(2, 102, 101, 100))
+				Expect(client.take()).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("serving WebSocket clients", func() {
+		var streamChan chan message.Message
+		var processed chan error
+		var cancel context.CancelFunc
+		var served chan error
+		var url string
+
+		dial := func() *websocket.Conn {
+			conn, err := websocket.Dial(url, "", "http://localhost/")
+			Expect(err).To(BeNil())
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			return conn
+		}
+		request := func(conn *websocket.Conn, action string, symbol string, depth int) {
+			Expect(websocket.JSON.Send(conn, streamRequest{Action: action, Symbol: symbol, Depth: depth})).To(Succeed())
+		}
+		receive := func(conn *websocket.Conn) streamMessage {
+			var msg streamMessage
+			Expect(websocket.JSON.Receive(conn, &msg)).To(Succeed())
+			return msg
+		}
+
+		BeforeEach(func() {
+			config := &configPkg.Config{}
+			config.OrderBook.Depth = 2
+			config.Http.MaxDepth = 3
+			streamChan = make(chan message.Message)
+			manager := order_book.NewOrderBookManager(config, streamChan, nil, inmem_db.NewOrderBookDb(config))
+			server := NewServer(config, manager)
+			processed = make(chan error, 1)
+			go func() {
+				processed <- manager.ProcessMessage()
+			}()
+			streamChan <- added(1, 1, 100)
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			url = "ws://" + listener.Addr().String() + "/stream"
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			served = make(chan error, 1)
+			go func() {
+				served <- server.Serve(ctx, listener)
+			}()
+		})
+
+		AfterEach(func() {
+			close(streamChan)
+			Eventually(processed).Should(Receive(BeNil()))
+			cancel()
+			Eventually(served).Should(Receive(BeNil()))
+		})
+
+		Context("subscribing to a symbol", func() {
+			It("should send the snapshot then the changes of the subscribed levels", func() {
+				conn := dial()
+				defer conn.Close()
+				request(conn, ACTION_SUBSCRIBE, "VC0", 0)
+				Expect(receive(conn)).To(Equal(streamMessage{Type: STREAM_SNAPSHOT, Seq: 1, Symbol: "VC0", Depth: 2, Buy: []level{{Price: 100, Volume: 10}}, Sell: []level{}}))
+
+				streamChan <- added(2, 2, 101)
+				// below the 2 subscribed levels
+				streamChan <- added(3, 3, 99)
+				streamChan <- added(4, 4, 102)
+				update := receive(conn)
+				Expect(update.Type).To(Equal(STREAM_UPDATE))
+				if update.Seq == 2 {
+					update = receive(conn)
+				}
+				Expect(update.Seq).To(Equal(uint32(4)))
+				Expect(update.Buy).To(Equal([]level{{Price: 102, Volume: 10}, {Price: 101, Volume: 10}}))
+			})
+		})
+
+		Context("subscribing to a symbol without a book", func() {
+			It("should send an empty snapshot then the first levels", func() {
+				conn := dial()
+				defer conn.Close()
+				request(conn, ACTION_SUBSCRIBE, "VC1", 1)
+				Expect(receive(conn)).To(Equal(streamMessage{Type: STREAM_SNAPSHOT, Seq: 1, Symbol: "VC1", Depth: 1, Buy: []level{}, Sell: []level{}}))
+
+				other := message.NewSymbol("VC1")
+				streamChan <- message.Message{
+					Symbol:    other,
+					MsgType:   message.MSG_TYPE_ADDED,
+					MsgHeader: message.Header{Seq: 2},
+					MsgBody:   message.MessageAdded{Symbol: other, OrderId: 1, Side: [1]byte{message.SIDE_SELL}, Price: 50, Size: 1},
+				}
+				Expect(receive(conn)).To(Equal(streamMessage{Type: STREAM_UPDATE, Seq: 2, Symbol: "VC1", Depth: 1, Buy: []level{}, Sell: []level{{Price: 50, Volume: 1}}}))
+			})
+		})
+
+		Context("after unsubscribing", func() {
+			It("should not send the changes of the symbol", func() {
+				conn := dial()
+				defer conn.Close()
+				request(conn, ACTION_SUBSCRIBE, "VC0", 1)
+				receive(conn)
+				request(conn, ACTION_UNSUBSCRIBE, "VC0", 0)
+				request(conn, ACTION_SUBSCRIBE, "VC1", 1)
+				// the requests are applied in order, so the snapshot of VC1 is sent after the unsubscribe
+				Expect(receive(conn).Symbol).To(Equal("VC1"))
+
+				streamChan <- added(2, 2, 101)
+				request(conn, ACTION_SUBSCRIBE, "VC2", 1)
+				Expect(receive(conn).Symbol).To(Equal("VC2"))
+			})
+		})
+
+		Context("with invalid requests", func() {
+			for _, invalid := range []streamRequest{
+				{Action: "watch", Symbol: "VC0"},
+				{Action: ACTION_SUBSCRIBE, Symbol: ""},
+				{Action: ACTION_SUBSCRIBE, Symbol: "VC0", Depth: 4},
+				{Action: ACTION_SUBSCRIBE, Symbol: "VC0", Depth: -1},
+			} {
+				invalid := invalid
+				It(fmt.Sprintf("should answer %+v with an error and keep the connection", invalid), func() {
+					conn := dial()
+					defer conn.Close()
+					request(conn, invalid.Action, invalid.Symbol, invalid.Depth)
+					var response streamError
+					Expect(websocket.JSON.Receive(conn, &response)).To(Succeed())
+					Expect(response.Type).To(Equal(STREAM_ERROR))
+
+					request(conn, ACTION_SUBSCRIBE, "VC0", 1)
+					Expect(receive(conn).Type).To(Equal(STREAM_SNAPSHOT))
+				})
+			}
+		})
+
+		Context("when the server stops", func() {
+			It("should disconnect the clients", func() {
+				conn := dial()
+				defer conn.Close()
+				request(conn, ACTION_SUBSCRIBE, "VC0", 1)
+				receive(conn)
+				cancel()
+				Eventually(served).Should(Receive(BeNil()))
+				served <- nil
+
+				var msg streamMessage
+				Expect(websocket.JSON.Receive(conn, &msg)).To(Not(Succeed()))
+			})
+		})
+	})
+})
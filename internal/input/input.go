@@ -0,0 +1,243 @@
+// Package input opens the captures given on the command line as a single stream
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+	"github.com/klauspost/compress/zstd"
+)
+
+// magic bytes of the compressed formats
+var (
+	MAGIC_GZIP = []byte{0x1f, 0x8b}
+	MAGIC_ZSTD = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Expand returns the files of the paths and globs in the given order, the files matched by a glob are sorted by name
+// a file matched by more than one pattern is only returned once
+func Expand(patterns []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			if !strings.ContainsAny(pattern, "*?[") {
+				// a plain path that does not exist is reported as such
+				_, err = os.Stat(pattern)
+				return nil, err
+			}
+			return nil, fmt.Errorf("no file matches %s", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				paths = append(paths, match)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// Decompress returns the decompressed r when it starts with the gzip or zstd magic bytes, r as it is otherwise
+// the magic bytes are only read by the first Read, so a stdin that is never read is not waited on
+func Decompress(r io.Reader) io.ReadCloser {
+	return &decompressor{input: r}
+}
+
+// decompressor picks the decompression of its input on the first Read
+type decompressor struct {
+	input  io.Reader
+	reader io.ReadCloser
+}
+
+func (d *decompressor) Read(p []byte) (int, error) {
+	if d.reader == nil {
+		reader := bufio.NewReader(d.input)
+		magic, err := reader.Peek(len(MAGIC_ZSTD))
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		switch {
+		case bytes.HasPrefix(magic, MAGIC_GZIP):
+			gzipReader, err := gzip.NewReader(reader)
+			if err != nil {
+				return 0, err
+			}
+			d.reader = gzipReader
+		case bytes.HasPrefix(magic, MAGIC_ZSTD):
+			decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return 0, err
+			}
+			d.reader = decoder.IOReadCloser()
+		default:
+			d.reader = io.NopCloser(reader)
+		}
+	}
+	return d.reader.Read(p)
+}
+
+// Close releases the decompression, the input is not closed
+func (d *decompressor) Close() error {
+	if d.reader == nil {
+		return nil
+	}
+	return d.reader.Close()
+}
+
+// Stream reads the files one after the other as a single stream, each file is decompressed on its own
+// when the files are the native stream the Header.Seq is checked to continue across the file boundaries
+type Stream struct {
+	paths  []string
+	next   int           // index of the next file to open
+	file   *os.File      // current file
+	reader io.ReadCloser // decompressed current file
+	seq    *seqCheck     // nil when the seq is not checked
+}
+
+// Open returns the Stream of the files of the paths and globs
+// the seq is checked for the native protocol, the ITCH msg and the pcap captures do not carry a Header.Seq
+func Open(config *config.Config, patterns []string) (*Stream, error) {
+	paths, err := Expand(patterns)
+	if err != nil {
+		return nil, err
+	}
+	stream := &Stream{paths: paths}
+	protocol := config.Stream.Protocol
+	if (protocol == "" || protocol == stream_handler.PROTOCOL_NATIVE) && config.Stream.Pcap.Destination == "" {
+		schema, err := stream_handler.SchemaOf(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema: %w", err)
+		}
+		stream.seq = &seqCheck{schema: schema}
+	}
+	return stream, nil
+}
+
+// Paths returns the files of the Stream in the order they are read
+func (s *Stream) Paths() []string {
+	return s.paths
+}
+
+// Read reads from the current file, moving on to the next file at the end of it. Returns io.EOF after the last file
+func (s *Stream) Read(p []byte) (int, error) {
+	for {
+		if s.reader == nil {
+			if s.next >= len(s.paths) {
+				return 0, io.EOF
+			}
+			if err := s.open(s.paths[s.next]); err != nil {
+				return 0, err
+			}
+			s.next++
+		}
+		count, err := s.reader.Read(p)
+		if count > 0 && s.seq != nil {
+			if seqErr := s.seq.feed(s.file.Name(), p[:count]); seqErr != nil {
+				return 0, seqErr
+			}
+		}
+		if err == io.EOF {
+			s.closeFile()
+			if count > 0 {
+				return count, nil
+			}
+			continue
+		}
+		if err != nil {
+			return count, fmt.Errorf("unable to read %s: %w", s.file.Name(), err)
+		}
+		return count, nil
+	}
+}
+
+// Close closes the current file
+func (s *Stream) Close() error {
+	s.closeFile()
+	s.next = len(s.paths)
+	return nil
+}
+
+func (s *Stream) open(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	s.file, s.reader = file, Decompress(file)
+	if s.seq != nil {
+		s.seq.boundary = s.seq.seen
+	}
+	return nil
+}
+
+func (s *Stream) closeFile() {
+	if s.reader != nil {
+		s.reader.Close()
+		s.file.Close()
+		s.reader = nil
+	}
+}
+
+// seqCheck follows the frames of the stream to check the first seq of every file after the first one
+type seqCheck struct {
+	schema   *stream_handler.Schema
+	header   []byte // header read so far
+	skip     int64  // msg type and body bytes of the current frame still to be read
+	last     uint32 // seq of the last header
+	lastPath string // file of the last header
+	seen     bool   // a header was read
+	boundary bool   // a file boundary was crossed since the last header
+}
+
+// feed follows the frames of data read from path
+func (c *seqCheck) feed(path string, data []byte) error {
+	headerLength := c.schema.HeaderLength()
+	for len(data) > 0 {
+		if c.skip > 0 {
+			count := int64(len(data))
+			if count > c.skip {
+				count = c.skip
+			}
+			c.skip -= count
+			data = data[count:]
+			continue
+		}
+		count := headerLength - len(c.header)
+		if count > len(data) {
+			count = len(data)
+		}
+		c.header = append(c.header, data[:count]...)
+		data = data[count:]
+		if len(c.header) < headerLength {
+			return nil
+		}
+		header, err := c.schema.DecodeHeader(c.header)
+		c.header = c.header[:0]
+		if err != nil {
+			// the StreamHandler reports the corrupted header
+			return nil
+		}
+		if c.boundary && header.Seq != c.last+1 {
+			return fmt.Errorf("%s continues at seq %d, expected %d after seq %d at the end of %s", path, header.Seq, c.last+1, c.last, c.lastPath)
+		}
+		c.boundary = false
+		c.seen = true
+		c.last, c.lastPath = header.Seq, path
+		c.skip = int64(header.Size)
+	}
+	return nil
+}
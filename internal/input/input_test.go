@@ -0,0 +1,150 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Input", func() {
+	var dir string
+	appConfig := &config.Config{}
+	appConfig.Stream.HeaderLength = 8
+
+	// frames returns the native frames of the seq
+	frames := func(seqs ...uint32) []byte {
+		var raw bytes.Buffer
+		for _, seq := range seqs {
+			Expect(stream_handler.WriteMsg(&raw, message.Message{
+				MsgHeader: message.Header{Seq: seq},
+				MsgType:   message.MSG_TYPE_DELETED,
+				MsgBody:   message.MessageDeleted{Symbol: message.NewSymbol("VC0"), OrderId: uint64(seq), Side: [1]byte{message.SIDE_BUY}},
+			})).To(Succeed())
+		}
+		return raw.Bytes()
+	}
+	gzipped := func(raw []byte) []byte {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(raw)
+		writer.Close()
+		return compressed.Bytes()
+	}
+	zstded := func(raw []byte) []byte {
+		encoder, err := zstd.NewWriter(nil)
+		Expect(err).To(BeNil())
+		defer encoder.Close()
+		return encoder.EncodeAll(raw, nil)
+	}
+	write := func(name string, raw []byte) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, raw, 0644)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "input_test")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Expand", func() {
+		It("should keep the order of the patterns and sort the files of a glob", func() {
+			write("b.stream", nil)
+			write("a.stream", nil)
+			first := write("first.stream", nil)
+			paths, err := Expand([]string{first, filepath.Join(dir, "?.stream"), filepath.Join(dir, "a.stream")})
+			Expect(err).To(BeNil())
+			Expect(paths).To(Equal([]string{first, filepath.Join(dir, "a.stream"), filepath.Join(dir, "b.stream")}))
+		})
+
+		It("should return an error when a pattern matches no file", func() {
+			_, err := Expand([]string{filepath.Join(dir, "*.stream")})
+			Expect(err).To(MatchError(ContainSubstring("no file matches")))
+			_, err = Expand([]string{filepath.Join(dir, "missing.stream")})
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Decompress", func() {
+		raw := []byte("some stream bytes")
+		for name, compress := range map[string]func([]byte) []byte{
+			"plain": func(raw []byte) []byte { return raw },
+			"gzip":  gzipped,
+			"zstd":  zstded,
+		} {
+			compress := compress
+			It("should read a "+name+" input", func() {
+				reader := Decompress(bytes.NewReader(compress(raw)))
+				defer reader.Close()
+				decompressed, err := io.ReadAll(reader)
+				Expect(err).To(BeNil())
+				Expect(decompressed).To(Equal(raw))
+			})
+		}
+
+		It("should read an empty input", func() {
+			decompressed, err := io.ReadAll(Decompress(bytes.NewReader(nil)))
+			Expect(err).To(BeNil())
+			Expect(decompressed).To(BeEmpty())
+		})
+	})
+
+	Describe("Open", func() {
+		Context("with files that continue the seq", func() {
+			It("should read them as a single stream, even when a frame is split across files", func() {
+				raw := frames(1, 2, 3, 4, 5)
+				split := len(frames(1, 2, 3)) + 5
+				write("feed.1.stream.gz", gzipped(raw[:len(frames(1))]))
+				write("feed.2.stream.zst", zstded(raw[len(frames(1)):split]))
+				write("feed.3.stream", raw[split:])
+				stream, err := Open(appConfig, []string{filepath.Join(dir, "feed.*")})
+				Expect(err).To(BeNil())
+				defer stream.Close()
+				Expect(stream.Paths()).To(HaveLen(3))
+				stitched, err := io.ReadAll(stream)
+				Expect(err).To(BeNil())
+				Expect(stitched).To(Equal(raw))
+			})
+		})
+
+		Context("with a file that does not continue the seq", func() {
+			It("should return an error naming both files", func() {
+				write("feed.1.stream", frames(1, 2))
+				write("feed.2.stream.gz", gzipped(frames(4, 5)))
+				stream, err := Open(appConfig, []string{filepath.Join(dir, "feed.*")})
+				Expect(err).To(BeNil())
+				defer stream.Close()
+				_, err = io.ReadAll(stream)
+				Expect(err).To(MatchError(ContainSubstring("feed.2.stream.gz continues at seq 4, expected 3 after seq 2 at the end of")))
+			})
+		})
+
+		Context("with ITCH files", func() {
+			It("should not check the seq", func() {
+				itchConfig := *appConfig
+				itchConfig.Stream.Protocol = stream_handler.PROTOCOL_ITCH
+				write("feed.1.itch", frames(1))
+				write("feed.2.itch", frames(5))
+				stream, err := Open(&itchConfig, []string{filepath.Join(dir, "feed.*")})
+				Expect(err).To(BeNil())
+				defer stream.Close()
+				_, err = io.ReadAll(stream)
+				Expect(err).To(BeNil())
+			})
+		})
+	})
+})
@@ -0,0 +1,69 @@
+package itch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// the encoders build ITCH msg for synthetic captures, stock locate, tracking number and timestamp are left as 0
+
+// EncodeAddOrder returns an add order (A) msg, the stock is padded with spaces up to 8 bytes
+func EncodeAddOrder(ref uint64, side byte, shares uint32, stock string, price uint32) []byte {
+	msg := newMsg(MSG_TYPE_ADD_ORDER, ref)
+	msg[offsetAddSide] = side
+	binary.BigEndian.PutUint32(msg[offsetAddShares:], shares)
+	copy(msg[offsetAddStock:offsetAddStock+stockLength], fmt.Sprintf("%-8s", stock))
+	binary.BigEndian.PutUint32(msg[offsetAddPrice:], price)
+	return msg
+}
+
+// EncodeOrderExecuted returns an order executed (E) msg
+func EncodeOrderExecuted(ref uint64, shares uint32, match uint64) []byte {
+	msg := newMsg(MSG_TYPE_ORDER_EXECUTED, ref)
+	binary.BigEndian.PutUint32(msg[offsetShares:], shares)
+	binary.BigEndian.PutUint64(msg[offsetMatch:], match)
+	return msg
+}
+
+// EncodeOrderCancel returns an order cancel (X) msg
+func EncodeOrderCancel(ref uint64, shares uint32) []byte {
+	msg := newMsg(MSG_TYPE_ORDER_CANCEL, ref)
+	binary.BigEndian.PutUint32(msg[offsetShares:], shares)
+	return msg
+}
+
+// EncodeOrderDelete returns an order delete (D) msg
+func EncodeOrderDelete(ref uint64) []byte {
+	return newMsg(MSG_TYPE_ORDER_DELETE, ref)
+}
+
+// EncodeOrderReplace returns an order replace (U) msg
+func EncodeOrderReplace(ref uint64, newRef uint64, shares uint32, price uint32) []byte {
+	msg := newMsg(MSG_TYPE_ORDER_REPLACE, ref)
+	binary.BigEndian.PutUint64(msg[offsetNewRef:], newRef)
+	binary.BigEndian.PutUint32(msg[offsetNewShares:], shares)
+	binary.BigEndian.PutUint32(msg[offsetNewPrice:], price)
+	return msg
+}
+
+// WriteMsg writes the msg into w prefixed by its 2 bytes big endian length, the framing of the ITCH binary files
+func WriteMsg(w io.Writer, msg []byte) error {
+	if len(msg) > 0xFFFF {
+		return fmt.Errorf("itch msg of %d bytes is too long", len(msg))
+	}
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(msg)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+func newMsg(msgType byte, ref uint64) []byte {
+	msg := make([]byte, msgLengths[msgType])
+	msg[0] = msgType
+	binary.BigEndian.PutUint64(msg[offsetOrderRef:], ref)
+	return msg
+}
@@ -0,0 +1,191 @@
+// Package itch decodes NASDAQ TotalView-ITCH 5.0 msg into the msg of the order book
+package itch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// ITCH msg types that change the order book, every other msg type is skipped
+const (
+	MSG_TYPE_ADD_ORDER                 = 'A'
+	MSG_TYPE_ADD_ORDER_MPID            = 'F'
+	MSG_TYPE_ORDER_EXECUTED            = 'E'
+	MSG_TYPE_ORDER_EXECUTED_WITH_PRICE = 'C'
+	MSG_TYPE_ORDER_CANCEL              = 'X'
+	MSG_TYPE_ORDER_DELETE              = 'D'
+	MSG_TYPE_ORDER_REPLACE             = 'U'
+)
+
+// PRICE_SCALE is the number of decimal places of the ITCH prices, orderBook.scale should be set to it
+const PRICE_SCALE = 4
+
+// msgLengths is the length of each msg type including the msg type byte
+var msgLengths = map[byte]int{
+	MSG_TYPE_ADD_ORDER:                 36,
+	MSG_TYPE_ADD_ORDER_MPID:            40,
+	MSG_TYPE_ORDER_EXECUTED:            31,
+	MSG_TYPE_ORDER_EXECUTED_WITH_PRICE: 36,
+	MSG_TYPE_ORDER_CANCEL:              23,
+	MSG_TYPE_ORDER_DELETE:              19,
+	MSG_TYPE_ORDER_REPLACE:             35,
+}
+
+// byte offsets of the fields, every msg starts with the msg type, stock locate, tracking number and a 6 bytes timestamp
+const (
+	offsetOrderRef  = 11 // order reference number of every order msg
+	offsetAddSide   = 19
+	offsetAddShares = 20
+	offsetAddStock  = 24
+	offsetAddPrice  = 32
+	offsetShares    = 19 // executed shares of E and C, cancelled shares of X
+	offsetMatch     = 23 // match number of E and C
+	offsetNewRef    = 19 // new order reference number of U
+	offsetNewShares = 27
+	offsetNewPrice  = 31
+	stockLength     = 8
+)
+
+// liveOrder keeps what the later msg of an order do not carry
+type liveOrder struct {
+	symbol message.Symbol
+	side   byte
+	price  int64
+	shares uint64
+}
+
+// Decoder maps ITCH msg onto the order book operations
+// only the add msg carry the stock and side, so the Decoder keeps every live order
+type Decoder struct {
+	orders map[uint64]*liveOrder // live orders by order reference number
+}
+
+// NewDecoder return an instance of Decoder
+func NewDecoder() *Decoder {
+	return &Decoder{orders: make(map[uint64]*liveOrder)}
+}
+
+// Decode maps a single ITCH msg into the msg of the order book, seq is the Header.Seq of every returned msg
+// - add order (A and F) is an added msg
+// - order executed (E and C) is an executed msg, the book trades at the resting price
+// - order cancel (X) is an updated msg with the remaining shares at the same price, or a deleted msg when none remain
+// - order delete (D) is a deleted msg
+// - order replace (U) is a deleted msg of the original order followed by an added msg of the new one
+// other msg types and msg of orders added before the capture started return no msg
+func (d *Decoder) Decode(seq uint32, payload []byte) ([]message.Message, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty itch msg")
+	}
+	msgType := payload[0]
+	length, ok := msgLengths[msgType]
+	if !ok {
+		return nil, nil
+	}
+	if len(payload) < length {
+		return nil, fmt.Errorf("itch msg %c needs %d bytes, got %d", msgType, length, len(payload))
+	}
+	header := message.Header{Seq: seq, Size: uint32(len(payload))}
+	ref := binary.BigEndian.Uint64(payload[offsetOrderRef:])
+
+	if msgType == MSG_TYPE_ADD_ORDER || msgType == MSG_TYPE_ADD_ORDER_MPID {
+		side, err := parseSide(payload[offsetAddSide])
+		if err != nil {
+			return nil, err
+		}
+		order := &liveOrder{
+			symbol: message.NewSymbol(strings.TrimRight(string(payload[offsetAddStock:offsetAddStock+stockLength]), " ")),
+			side:   side,
+			price:  int64(binary.BigEndian.Uint32(payload[offsetAddPrice:])),
+			shares: uint64(binary.BigEndian.Uint32(payload[offsetAddShares:])),
+		}
+		d.orders[ref] = order
+		return []message.Message{added(header, ref, order)}, nil
+	}
+
+	order, ok := d.orders[ref]
+	if !ok {
+		return nil, nil
+	}
+	switch msgType {
+	case MSG_TYPE_ORDER_EXECUTED, MSG_TYPE_ORDER_EXECUTED_WITH_PRICE:
+		// an execution larger than the shares left only trades the shares left
+		shares := order.reduce(uint64(binary.BigEndian.Uint32(payload[offsetShares:])))
+		if order.shares == 0 {
+			delete(d.orders, ref)
+		}
+		return []message.Message{{
+			Symbol:    order.symbol,
+			MsgType:   message.MSG_TYPE_EXECUTED,
+			MsgHeader: header,
+			MsgBody:   message.MessageExecuted{Symbol: order.symbol, OrderId: ref, Side: [1]byte{order.side}, TradedQty: shares},
+		}}, nil
+	case MSG_TYPE_ORDER_CANCEL:
+		order.reduce(uint64(binary.BigEndian.Uint32(payload[offsetShares:])))
+		if order.shares == 0 {
+			delete(d.orders, ref)
+			return []message.Message{deleted(header, ref, order)}, nil
+		}
+		return []message.Message{{
+			Symbol:    order.symbol,
+			MsgType:   message.MSG_TYPE_UPDATED,
+			MsgHeader: header,
+			MsgBody:   message.MessageUpdated{Symbol: order.symbol, OrderId: ref, Side: [1]byte{order.side}, Size: order.shares, Price: order.price},
+		}}, nil
+	case MSG_TYPE_ORDER_DELETE:
+		delete(d.orders, ref)
+		return []message.Message{deleted(header, ref, order)}, nil
+	case MSG_TYPE_ORDER_REPLACE:
+		delete(d.orders, ref)
+		newRef := binary.BigEndian.Uint64(payload[offsetNewRef:])
+		newOrder := &liveOrder{
+			symbol: order.symbol,
+			side:   order.side,
+			price:  int64(binary.BigEndian.Uint32(payload[offsetNewPrice:])),
+			shares: uint64(binary.BigEndian.Uint32(payload[offsetNewShares:])),
+		}
+		d.orders[newRef] = newOrder
+		return []message.Message{deleted(header, ref, order), added(header, newRef, newOrder)}, nil
+	}
+	return nil, nil
+}
+
+// reduce removes shares from the order, never below 0, and returns the shares removed
+func (o *liveOrder) reduce(shares uint64) uint64 {
+	if shares > o.shares {
+		shares = o.shares
+	}
+	o.shares -= shares
+	return shares
+}
+
+func added(header message.Header, ref uint64, order *liveOrder) message.Message {
+	return message.Message{
+		Symbol:    order.symbol,
+		MsgType:   message.MSG_TYPE_ADDED,
+		MsgHeader: header,
+		MsgBody:   message.MessageAdded{Symbol: order.symbol, OrderId: ref, Side: [1]byte{order.side}, Size: order.shares, Price: order.price},
+	}
+}
+
+func deleted(header message.Header, ref uint64, order *liveOrder) message.Message {
+	return message.Message{
+		Symbol:    order.symbol,
+		MsgType:   message.MSG_TYPE_DELETED,
+		MsgHeader: header,
+		MsgBody:   message.MessageDeleted{Symbol: order.symbol, OrderId: ref, Side: [1]byte{order.side}},
+	}
+}
+
+// parseSide maps the ITCH buy/sell indicator onto the side of the book
+func parseSide(indicator byte) (byte, error) {
+	switch indicator {
+	case 'B':
+		return message.SIDE_BUY, nil
+	case 'S':
+		return message.SIDE_SELL, nil
+	}
+	return 0, fmt.Errorf("unrecognized itch buy/sell indicator %q", indicator)
+}
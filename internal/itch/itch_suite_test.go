@@ -0,0 +1,13 @@
+package itch_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestItch(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Itch Suite")
+}
@@ -0,0 +1,111 @@
+package itch
+
+import (
+	"github.com/albertsundjaja/order_book/internal/message"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Decoder", func() {
+	var decoder *Decoder
+	symbol := message.NewSymbol("AAPL")
+	buy := [1]byte{message.SIDE_BUY}
+
+	// decode decodes the msg and returns the bodies of the book msg
+	decode := func(seq uint32, msg []byte) []interface{} {
+		msgs, err := decoder.Decode(seq, msg)
+		Expect(err).To(BeNil())
+		bodies := make([]interface{}, 0, len(msgs))
+		for _, decoded := range msgs {
+			Expect(decoded.MsgHeader.Seq).To(Equal(seq))
+			Expect(decoded.Symbol).To(Equal(symbol))
+			bodies = append(bodies, decoded.MsgBody)
+		}
+		return bodies
+	}
+
+	BeforeEach(func() {
+		decoder = NewDecoder()
+		Expect(decode(1, EncodeAddOrder(10, 'B', 100, "AAPL", 1725000))).To(Equal([]interface{}{
+			message.MessageAdded{Symbol: symbol, OrderId: 10, Side: buy, Size: 100, Price: 1725000},
+		}))
+	})
+
+	Context("with an add order with MPID", func() {
+		It("should decode it as an added msg", func() {
+			msg := append(EncodeAddOrder(11, 'S', 5, "AAPL", 1726000), 'M', 'P', 'I', 'D')
+			msg[0] = MSG_TYPE_ADD_ORDER_MPID
+			Expect(decode(2, msg)).To(Equal([]interface{}{
+				message.MessageAdded{Symbol: symbol, OrderId: 11, Side: [1]byte{message.SIDE_SELL}, Size: 5, Price: 1726000},
+			}))
+		})
+	})
+
+	Context("with an order executed", func() {
+		It("should decode it as an executed msg of the side of the order", func() {
+			Expect(decode(2, EncodeOrderExecuted(10, 40, 1))).To(Equal([]interface{}{
+				message.MessageExecuted{Symbol: symbol, OrderId: 10, Side: buy, TradedQty: 40},
+			}))
+		})
+		It("should only trade the shares left when it executes more", func() {
+			decode(2, EncodeOrderExecuted(10, 80, 1))
+			Expect(decode(3, EncodeOrderExecuted(10, 50, 2))).To(Equal([]interface{}{
+				message.MessageExecuted{Symbol: symbol, OrderId: 10, Side: buy, TradedQty: 20},
+			}))
+			Expect(decode(4, EncodeOrderDelete(10))).To(BeEmpty())
+		})
+	})
+
+	Context("with an order cancel", func() {
+		It("should update the order with the remaining shares", func() {
+			Expect(decode(2, EncodeOrderCancel(10, 30))).To(Equal([]interface{}{
+				message.MessageUpdated{Symbol: symbol, OrderId: 10, Side: buy, Size: 70, Price: 1725000},
+			}))
+		})
+		It("should delete the order when no shares remain", func() {
+			decode(2, EncodeOrderExecuted(10, 60, 1))
+			Expect(decode(3, EncodeOrderCancel(10, 40))).To(Equal([]interface{}{
+				message.MessageDeleted{Symbol: symbol, OrderId: 10, Side: buy},
+			}))
+			Expect(decode(4, EncodeOrderDelete(10))).To(BeEmpty())
+		})
+	})
+
+	Context("with an order delete", func() {
+		It("should decode it as a deleted msg", func() {
+			Expect(decode(2, EncodeOrderDelete(10))).To(Equal([]interface{}{
+				message.MessageDeleted{Symbol: symbol, OrderId: 10, Side: buy},
+			}))
+		})
+	})
+
+	Context("with an order replace", func() {
+		It("should delete the original order and add the new one on the same side", func() {
+			Expect(decode(2, EncodeOrderReplace(10, 12, 50, 1724000))).To(Equal([]interface{}{
+				message.MessageDeleted{Symbol: symbol, OrderId: 10, Side: buy},
+				message.MessageAdded{Symbol: symbol, OrderId: 12, Side: buy, Size: 50, Price: 1724000},
+			}))
+			Expect(decode(3, EncodeOrderCancel(12, 10))).To(Equal([]interface{}{
+				message.MessageUpdated{Symbol: symbol, OrderId: 12, Side: buy, Size: 40, Price: 1724000},
+			}))
+		})
+	})
+
+	Context("with a msg that does not change the book", func() {
+		It("should skip system events and orders added before the capture started", func() {
+			Expect(decode(2, []byte{'S', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'O'})).To(BeEmpty())
+			Expect(decode(3, EncodeOrderExecuted(99, 1, 1))).To(BeEmpty())
+		})
+	})
+
+	Context("with a corrupted msg", func() {
+		It("should return an error", func() {
+			_, err := decoder.Decode(2, EncodeOrderDelete(10)[:10])
+			Expect(err).To(Not(BeNil()))
+			_, err = decoder.Decode(2, EncodeAddOrder(13, 'Z', 1, "AAPL", 1))
+			Expect(err).To(Not(BeNil()))
+			_, err = decoder.Decode(2, nil)
+			Expect(err).To(Not(BeNil()))
+		})
+	})
+})
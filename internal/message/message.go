@@ -1,6 +1,11 @@
 // Package message contains all the message format that are expected from the input
 package message
 
+import (
+	"strings"
+	"time"
+)
+
 const (
 	MSG_TYPE_ADDED    = "A"
 	MSG_TYPE_UPDATED  = "U"
@@ -8,46 +13,60 @@ const (
 	MSG_TYPE_EXECUTED = "E"
 	SIDE_BUY          = 66 // Buy side. "B" in uint8
 	SIDE_SELL         = 83 // Sell side. "S" in uint8
+	SYMBOL_LENGTH     = 8  // longest supported symbol, shorter symbols are padded with zero bytes
 )
 
+// Symbol is the symbol of a msg, padded with zero bytes up to SYMBOL_LENGTH
+type Symbol [SYMBOL_LENGTH]byte
+
+// NewSymbol returns the Symbol of s, s is truncated to SYMBOL_LENGTH bytes
+func NewSymbol(s string) Symbol {
+	var symbol Symbol
+	copy(symbol[:], s)
+	return symbol
+}
+
+// String returns the symbol without its padding
+func (s Symbol) String() string {
+	return strings.TrimRight(string(s[:]), "\x00 ")
+}
+
 type Message struct {
-	Symbol    [3]byte     // indicate which symbol this message is for
+	Symbol    Symbol      // indicate which symbol this message is for
 	MsgType   string      // store the message type
 	MsgHeader Header      // header of the message
 	MsgBody   interface{} // body of the message can be MessageAdded, MessageDeleted, MessageUpdated, MessageExecuted
+	Timestamp time.Time   // capture time of the packet that completed the message, zero when not read from a capture
 }
 
+// the msg bodies hold the decoded fields only, their layout on the wire is described by the stream_handler.Schema
+
 type MessageAdded struct {
-	Symbol      [3]byte
-	OrderId     uint64
-	Side        [1]byte
-	ReservedOne [3]byte
-	Size        uint64
-	Price       int32
-	ReservedTwo [4]byte
+	Symbol  Symbol
+	OrderId uint64
+	Side    [1]byte
+	Size    uint64
+	Price   int64
 }
 
 type MessageUpdated struct {
-	Symbol      [3]byte
-	OrderId     uint64
-	Side        [1]byte
-	ReservedOne [3]byte
-	Size        uint64
-	Price       int32
-	ReservedTwo [4]byte
+	Symbol  Symbol
+	OrderId uint64
+	Side    [1]byte
+	Size    uint64
+	Price   int64
 }
 
 type MessageDeleted struct {
-	Symbol  [3]byte
+	Symbol  Symbol
 	OrderId uint64
 	Side    [1]byte
 }
 
 type MessageExecuted struct {
-	Symbol    [3]byte
+	Symbol    Symbol
 	OrderId   uint64
 	Side      [1]byte
-	Reserved  [3]byte
 	TradedQty uint64
 }
 
@@ -0,0 +1,224 @@
+// Package metrics holds the Prometheus metrics of the stream, the order books and the queues between them
+package metrics
+
+import (
+	"time"
+
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NAMESPACE prefixes the name of every metric
+const NAMESPACE = "orderbook"
+
+// label values of the side of the book
+const (
+	SIDE_BUY  = "buy"
+	SIDE_SELL = "sell"
+)
+
+// Metrics are the counters and histograms updated by the components while they process the stream
+// every method can be called on a nil *Metrics, which records nothing
+type Metrics struct {
+	decoded        *prometheus.CounterVec // msg decoded by msg type
+	decodeErrors   prometheus.Counter     // input that could not be decoded
+	rejected       prometheus.Counter     // msg rejected by the books e.g. for an invalid price
+	applied        *prometheus.CounterVec // msg applied to the books by symbol
+	depthPrints    prometheus.Counter     // market depth lines sent to the outputs
+	applyDuration  prometheus.Histogram   // time to apply a msg and publish its events
+	queueDropped   *prometheus.CounterVec // items dropped by a full queue by queue
+	queueConflated *prometheus.CounterVec // items replaced by a later item of their symbol by queue
+}
+
+// NewMetrics return an instance of Metrics, it has to be registered to be exposed
+func NewMetrics() *Metrics {
+	return &Metrics{
+		decoded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "messages_decoded_total",
+			Help:      "Msg decoded from the input by msg type.",
+		}, []string{"type"}),
+		decodeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "decode_errors_total",
+			Help:      "Input that could not be decoded into a msg.",
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "messages_rejected_total",
+			Help:      "Msg rejected without changing the order books, e.g. for a price off the tick grid.",
+		}),
+		applied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "messages_applied_total",
+			Help:      "Msg applied to the order books by symbol.",
+		}, []string{"symbol"}),
+		depthPrints: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "depth_prints_total",
+			Help:      "Market depth lines sent to the outputs.",
+		}),
+		applyDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: NAMESPACE,
+			Name:      "apply_duration_seconds",
+			Help:      "Time to apply a msg to the order books and publish its depth and events.",
+			// 1µs to 262ms
+			Buckets: prometheus.ExponentialBuckets(0.000001, 4, 10),
+		}),
+		queueDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "queue_dropped_total",
+			Help:      "Items dropped by a full queue with the drop-oldest policy.",
+		}, []string{"queue"}),
+		queueConflated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "queue_conflated_total",
+			Help:      "Items replaced by a later item of the same symbol in a full queue with the conflate policy.",
+		}, []string{"queue"}),
+	}
+}
+
+// Register registers every metric to registerer
+func (m *Metrics) Register(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{m.decoded, m.decodeErrors, m.rejected, m.applied, m.depthPrints, m.applyDuration, m.queueDropped, m.queueConflated} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MessageDecoded counts a msg decoded from the input
+func (m *Metrics) MessageDecoded(msgType string) {
+	if m == nil {
+		return
+	}
+	m.decoded.WithLabelValues(msgType).Inc()
+}
+
+// DecodeError counts input that could not be decoded
+func (m *Metrics) DecodeError() {
+	if m == nil {
+		return
+	}
+	m.decodeErrors.Inc()
+}
+
+// MessageRejected counts a msg rejected by the books
+func (m *Metrics) MessageRejected() {
+	if m == nil {
+		return
+	}
+	m.rejected.Inc()
+}
+
+// MessageApplied counts a msg applied to the book of the symbol and observes how long it took
+func (m *Metrics) MessageApplied(symbol message.Symbol, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.applied.WithLabelValues(symbol.String()).Inc()
+	m.applyDuration.Observe(duration.Seconds())
+}
+
+// DepthPrinted counts a market depth line sent to an output
+func (m *Metrics) DepthPrinted() {
+	if m == nil {
+		return
+	}
+	m.depthPrints.Inc()
+}
+
+// QueueDropped counts an item dropped by the queue
+func (m *Metrics) QueueDropped(queue string) {
+	if m == nil {
+		return
+	}
+	m.queueDropped.WithLabelValues(queue).Inc()
+}
+
+// QueueConflated counts an item of the queue replaced by a later item of its symbol
+func (m *Metrics) QueueConflated(queue string) {
+	if m == nil {
+		return
+	}
+	m.queueConflated.WithLabelValues(queue).Inc()
+}
+
+// Queue is the state of a channel between two components
+type Queue struct {
+	Name     string
+	Length   int // items waiting
+	Capacity int // items it can hold before its writer waits, 0 when unbuffered
+}
+
+// queueCollector reads the length of every queue when it is scraped
+type queueCollector struct {
+	queues   func() []Queue
+	length   *prometheus.Desc
+	capacity *prometheus.Desc
+}
+
+// NewQueueCollector returns the collector of the length and capacity of the queues, queues is called at every scrape
+func NewQueueCollector(queues func() []Queue) prometheus.Collector {
+	return &queueCollector{
+		queues:   queues,
+		length:   prometheus.NewDesc(prometheus.BuildFQName(NAMESPACE, "", "queue_length"), "Items waiting in the queue between two components.", []string{"queue"}, nil),
+		capacity: prometheus.NewDesc(prometheus.BuildFQName(NAMESPACE, "", "queue_capacity"), "Items the queue holds before its writer waits, 0 when unbuffered.", []string{"queue"}, nil),
+	}
+}
+
+func (c *queueCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.length
+	descs <- c.capacity
+}
+
+func (c *queueCollector) Collect(metrics chan<- prometheus.Metric) {
+	for _, queue := range c.queues() {
+		metrics <- prometheus.MustNewConstMetric(c.length, prometheus.GaugeValue, float64(queue.Length), queue.Name)
+		metrics <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(queue.Capacity), queue.Name)
+	}
+}
+
+// BookStats is the size of the book of a symbol
+type BookStats struct {
+	Symbol string
+	db.Stats
+}
+
+// bookCollector reads the size of every book when it is scraped
+type bookCollector struct {
+	books   func() []BookStats
+	symbols *prometheus.Desc
+	orders  *prometheus.Desc
+	levels  *prometheus.Desc
+}
+
+// NewBookCollector returns the collector of the number of symbols, resting orders and price levels per side
+// books is called at every scrape and should read every book at the same seq
+func NewBookCollector(books func() []BookStats) prometheus.Collector {
+	return &bookCollector{
+		books:   books,
+		symbols: prometheus.NewDesc(prometheus.BuildFQName(NAMESPACE, "", "symbols"), "Symbols with an order book.", nil, nil),
+		orders:  prometheus.NewDesc(prometheus.BuildFQName(NAMESPACE, "", "resting_orders"), "Resting orders of the book by symbol and side.", []string{"symbol", "side"}, nil),
+		levels:  prometheus.NewDesc(prometheus.BuildFQName(NAMESPACE, "", "price_levels"), "Price levels of the book by symbol and side.", []string{"symbol", "side"}, nil),
+	}
+}
+
+func (c *bookCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.symbols
+	descs <- c.orders
+	descs <- c.levels
+}
+
+func (c *bookCollector) Collect(metrics chan<- prometheus.Metric) {
+	books := c.books()
+	metrics <- prometheus.MustNewConstMetric(c.symbols, prometheus.GaugeValue, float64(len(books)))
+	for _, book := range books {
+		metrics <- prometheus.MustNewConstMetric(c.orders, prometheus.GaugeValue, float64(book.BuyOrders), book.Symbol, SIDE_BUY)
+		metrics <- prometheus.MustNewConstMetric(c.orders, prometheus.GaugeValue, float64(book.SellOrders), book.Symbol, SIDE_SELL)
+		metrics <- prometheus.MustNewConstMetric(c.levels, prometheus.GaugeValue, float64(book.BuyLevels), book.Symbol, SIDE_BUY)
+		metrics <- prometheus.MustNewConstMetric(c.levels, prometheus.GaugeValue, float64(book.SellLevels), book.Symbol, SIDE_SELL)
+	}
+}
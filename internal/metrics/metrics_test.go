@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/message"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Metrics", func() {
+	var registry *prometheus.Registry
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+	})
+
+	Context("with a nil Metrics", func() {
+		It("should record nothing", func() {
+			var metrics *Metrics
+			Expect(func() {
+				metrics.MessageDecoded(message.MSG_TYPE_ADDED)
+				metrics.DecodeError()
+				metrics.MessageRejected()
+				metrics.MessageApplied(message.NewSymbol("VC0"), time.Millisecond)
+				metrics.DepthPrinted()
+				metrics.QueueDropped("print")
+				metrics.QueueConflated("print")
+			}).To(Not(Panic()))
+		})
+	})
+
+	Context("with registered Metrics", func() {
+		It("should count by the labels", func() {
+			metrics := NewMetrics()
+			Expect(metrics.Register(registry)).To(Succeed())
+			metrics.MessageDecoded(message.MSG_TYPE_ADDED)
+			metrics.MessageDecoded(message.MSG_TYPE_ADDED)
+			metrics.MessageDecoded(message.MSG_TYPE_DELETED)
+			metrics.MessageApplied(message.NewSymbol("VC0"), 3*time.Microsecond)
+			metrics.DepthPrinted()
+			metrics.QueueConflated("print")
+			metrics.QueueConflated("print")
+
+			Expect(testutil.ToFloat64(metrics.decoded.WithLabelValues(message.MSG_TYPE_ADDED))).To(Equal(2.0))
+			Expect(testutil.ToFloat64(metrics.decoded.WithLabelValues(message.MSG_TYPE_DELETED))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(metrics.applied.WithLabelValues("VC0"))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(metrics.depthPrints)).To(Equal(1.0))
+			Expect(testutil.ToFloat64(metrics.decodeErrors)).To(Equal(0.0))
+			Expect(testutil.CollectAndCount(metrics.applyDuration)).To(Equal(1))
+			Expect(testutil.ToFloat64(metrics.queueConflated.WithLabelValues("print"))).To(Equal(2.0))
+			Expect(testutil.CollectAndCount(metrics.queueDropped)).To(Equal(0))
+		})
+
+		It("should not be registered twice", func() {
+			Expect(NewMetrics().Register(registry)).To(Succeed())
+			Expect(NewMetrics().Register(registry)).To(Not(Succeed()))
+		})
+	})
+
+	Context("collecting the queues and books", func() {
+		It("should read their state at every scrape", func() {
+			length := 1
+			registry.MustRegister(NewQueueCollector(func() []Queue {
+				return []Queue{{Name: "print", Length: length, Capacity: 64}}
+			}))
+			registry.MustRegister(NewBookCollector(func() []BookStats {
+				return []BookStats{{Symbol: "VC0", Stats: db.Stats{BuyOrders: 3, SellOrders: 1, BuyLevels: 2, SellLevels: 1}}}
+			}))
+			length = 5
+
+			expected := `
+# HELP orderbook_price_levels Price levels of the book by symbol and side.
+# TYPE orderbook_price_levels gauge
+orderbook_price_levels{side="buy",symbol="VC0"} 2
+orderbook_price_levels{side="sell",symbol="VC0"} 1
+# HELP orderbook_queue_length Items waiting in the queue between two components.
+# TYPE orderbook_queue_length gauge
+orderbook_queue_length{queue="print"} 5
+# HELP orderbook_resting_orders Resting orders of the book by symbol and side.
+# TYPE orderbook_resting_orders gauge
+orderbook_resting_orders{side="buy",symbol="VC0"} 3
+orderbook_resting_orders{side="sell",symbol="VC0"} 1
+# HELP orderbook_symbols Symbols with an order book.
+# TYPE orderbook_symbols gauge
+orderbook_symbols 1
+`
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(expected),
+				"orderbook_price_levels", "orderbook_queue_length", "orderbook_resting_orders", "orderbook_symbols")).To(Succeed())
+		})
+	})
+})
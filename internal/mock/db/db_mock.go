@@ -7,6 +7,7 @@ package mock_db
 import (
 	reflect "reflect"
 
+	db "github.com/albertsundjaja/order_book/internal/db"
 	message "github.com/albertsundjaja/order_book/internal/message"
 	gomock "github.com/golang/mock/gomock"
 )
@@ -35,10 +36,10 @@ func (m *MockIDbOrderBook) EXPECT() *MockIDbOrderBookMockRecorder {
 }
 
 // AddOrder mocks base method.
-func (m *MockIDbOrderBook) AddOrder(arg0 message.MessageAdded) (bool, error) {
+func (m *MockIDbOrderBook) AddOrder(arg0 message.MessageAdded) (int, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddOrder", arg0)
-	ret0, _ := ret[0].(bool)
+	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -49,11 +50,26 @@ func (mr *MockIDbOrderBookMockRecorder) AddOrder(arg0 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrder", reflect.TypeOf((*MockIDbOrderBook)(nil).AddOrder), arg0)
 }
 
+// CostToFill mocks base method.
+func (m *MockIDbOrderBook) CostToFill(symbol message.Symbol, side byte, qty uint64) (db.Fill, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CostToFill", symbol, side, qty)
+	ret0, _ := ret[0].(db.Fill)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CostToFill indicates an expected call of CostToFill.
+func (mr *MockIDbOrderBookMockRecorder) CostToFill(symbol, side, qty interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CostToFill", reflect.TypeOf((*MockIDbOrderBook)(nil).CostToFill), symbol, side, qty)
+}
+
 // DeleteOrder mocks base method.
-func (m *MockIDbOrderBook) DeleteOrder(arg0 message.MessageDeleted) (bool, error) {
+func (m *MockIDbOrderBook) DeleteOrder(arg0 message.MessageDeleted) (int, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DeleteOrder", arg0)
-	ret0, _ := ret[0].(bool)
+	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -64,11 +80,27 @@ func (mr *MockIDbOrderBookMockRecorder) DeleteOrder(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOrder", reflect.TypeOf((*MockIDbOrderBook)(nil).DeleteOrder), arg0)
 }
 
+// Depth mocks base method.
+func (m *MockIDbOrderBook) Depth(symbol message.Symbol, depth int) ([]db.Level, []db.Level, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Depth", symbol, depth)
+	ret0, _ := ret[0].([]db.Level)
+	ret1, _ := ret[1].([]db.Level)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Depth indicates an expected call of Depth.
+func (mr *MockIDbOrderBookMockRecorder) Depth(symbol, depth interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Depth", reflect.TypeOf((*MockIDbOrderBook)(nil).Depth), symbol, depth)
+}
+
 // ExecuteOrder mocks base method.
-func (m *MockIDbOrderBook) ExecuteOrder(arg0 message.MessageExecuted) (bool, error) {
+func (m *MockIDbOrderBook) ExecuteOrder(arg0 message.MessageExecuted) (int, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "ExecuteOrder", arg0)
-	ret0, _ := ret[0].(bool)
+	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -79,26 +111,103 @@ func (mr *MockIDbOrderBookMockRecorder) ExecuteOrder(arg0 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExecuteOrder", reflect.TypeOf((*MockIDbOrderBook)(nil).ExecuteOrder), arg0)
 }
 
+// GroupedDepth mocks base method.
+func (m *MockIDbOrderBook) GroupedDepth(symbol message.Symbol, depth int, increment int64) ([]db.Bucket, []db.Bucket, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GroupedDepth", symbol, depth, increment)
+	ret0, _ := ret[0].([]db.Bucket)
+	ret1, _ := ret[1].([]db.Bucket)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GroupedDepth indicates an expected call of GroupedDepth.
+func (mr *MockIDbOrderBookMockRecorder) GroupedDepth(symbol, depth, increment interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GroupedDepth", reflect.TypeOf((*MockIDbOrderBook)(nil).GroupedDepth), symbol, depth, increment)
+}
+
+// Order mocks base method.
+func (m *MockIDbOrderBook) Order(symbol message.Symbol, side byte, orderId uint64) (int64, uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Order", symbol, side, orderId)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(uint64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Order indicates an expected call of Order.
+func (mr *MockIDbOrderBookMockRecorder) Order(symbol, side, orderId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Order", reflect.TypeOf((*MockIDbOrderBook)(nil).Order), symbol, side, orderId)
+}
+
+// Orders mocks base method.
+func (m *MockIDbOrderBook) Orders(symbol message.Symbol) ([]db.Order, []db.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Orders", symbol)
+	ret0, _ := ret[0].([]db.Order)
+	ret1, _ := ret[1].([]db.Order)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Orders indicates an expected call of Orders.
+func (mr *MockIDbOrderBookMockRecorder) Orders(symbol interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Orders", reflect.TypeOf((*MockIDbOrderBook)(nil).Orders), symbol)
+}
+
 // PrintDepth mocks base method.
-func (m *MockIDbOrderBook) PrintDepth(symbol [3]byte) (string, error) {
+func (m *MockIDbOrderBook) PrintDepth(symbol message.Symbol, depth int) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PrintDepth", symbol)
+	ret := m.ctrl.Call(m, "PrintDepth", symbol, depth)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // PrintDepth indicates an expected call of PrintDepth.
-func (mr *MockIDbOrderBookMockRecorder) PrintDepth(symbol interface{}) *gomock.Call {
+func (mr *MockIDbOrderBookMockRecorder) PrintDepth(symbol, depth interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrintDepth", reflect.TypeOf((*MockIDbOrderBook)(nil).PrintDepth), symbol, depth)
+}
+
+// Stats mocks base method.
+func (m *MockIDbOrderBook) Stats(symbol message.Symbol) (db.Stats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats", symbol)
+	ret0, _ := ret[0].(db.Stats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockIDbOrderBookMockRecorder) Stats(symbol interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockIDbOrderBook)(nil).Stats), symbol)
+}
+
+// Symbols mocks base method.
+func (m *MockIDbOrderBook) Symbols() []message.Symbol {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Symbols")
+	ret0, _ := ret[0].([]message.Symbol)
+	return ret0
+}
+
+// Symbols indicates an expected call of Symbols.
+func (mr *MockIDbOrderBookMockRecorder) Symbols() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PrintDepth", reflect.TypeOf((*MockIDbOrderBook)(nil).PrintDepth), symbol)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Symbols", reflect.TypeOf((*MockIDbOrderBook)(nil).Symbols))
 }
 
 // UpdateOrder mocks base method.
-func (m *MockIDbOrderBook) UpdateOrder(arg0 message.MessageUpdated) (bool, error) {
+func (m *MockIDbOrderBook) UpdateOrder(arg0 message.MessageUpdated) (int, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "UpdateOrder", arg0)
-	ret0, _ := ret[0].(bool)
+	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
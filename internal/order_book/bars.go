@@ -0,0 +1,206 @@
+package order_book
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/queue"
+)
+
+const (
+	BAR_FORMAT_CSV  = "csv"
+	BAR_FORMAT_JSON = "json"
+
+	BAR_KIND_BAR     = "bar"     // a single bar
+	BAR_KIND_SUMMARY = "summary" // all the bars of a symbol, printed when the stream ends
+
+	BAR_CSV_HEADER = "kind,symbol,start,end,open,high,low,close,volume,vwap,count\n"
+)
+
+// Bar is the open, high, low, close, volume and vwap of the trades of a symbol between Start and End
+// Start and End are seq for seq bars and unix milliseconds for time bars, End is excluded
+type Bar struct {
+	Kind     string  `json:"kind"`
+	Symbol   string  `json:"symbol"`
+	Start    int64   `json:"start"`
+	End      int64   `json:"end"`
+	Open     int64   `json:"open"`
+	High     int64   `json:"high"`
+	Low      int64   `json:"low"`
+	Close    int64   `json:"close"`
+	Volume   uint64  `json:"volume"`
+	Vwap     float64 `json:"vwap"`
+	Count    uint64  `json:"count"`
+	Scale    int     `json:"scale"` // decimal places of the prices, the json prices are raw
+	notional float64 // sum of price * qty, for the vwap
+}
+
+// add adds the trade to the bar
+func (b *Bar) add(trade TradeEvent) {
+	if b.Count == 0 {
+		b.Open, b.High, b.Low = trade.Price, trade.Price, trade.Price
+	}
+	if trade.Price > b.High {
+		b.High = trade.Price
+	}
+	if trade.Price < b.Low {
+		b.Low = trade.Price
+	}
+	b.Close = trade.Price
+	b.Volume += trade.Qty
+	b.Count++
+	b.notional += float64(trade.Price) * float64(trade.Qty)
+	b.Vwap = b.notional / float64(b.Volume)
+}
+
+// merge adds the next bar of the same symbol to the bar
+func (b *Bar) merge(next Bar) {
+	if b.Count == 0 {
+		*b = next
+		return
+	}
+	b.End = next.End
+	if next.High > b.High {
+		b.High = next.High
+	}
+	if next.Low < b.Low {
+		b.Low = next.Low
+	}
+	b.Close = next.Close
+	b.Volume += next.Volume
+	b.Count += next.Count
+	b.notional += next.notional
+	b.Vwap = b.notional / float64(b.Volume)
+}
+
+// barWriter builds the bars of every symbol from the trades and sends each of them to out once it is complete
+// a bar is complete when a later msg of any symbol falls into the next bar, so an illiquid symbol does not hold its bar back
+type barWriter struct {
+	NopListener
+	out      chan<- queue.Line
+	format   string
+	size     uint32                  // seq per bar, used when interval is 0
+	interval time.Duration           // capture or processing time per bar
+	now      func() time.Time        // clock of the time bars of the msg without a capture time
+	open     map[message.Symbol]*Bar // bar being built for each symbol
+	summary  map[message.Symbol]*Bar // all completed bars of each symbol
+	started  bool                    // whether anything has been printed
+}
+
+// SubscribeBars adds an output that receives the bars configured in config.Bars and a summary of each symbol when the stream ends
+// It must be called before ProcessMessage is started, out is closed when ProcessMessage returns
+func (o *OrderBookManager) SubscribeBars(out chan<- queue.Line) {
+	format := o.config.Bars.Format
+	if format != BAR_FORMAT_JSON && format != BAR_FORMAT_CSV {
+		if format != "" {
+			log.Printf("unrecognized bar format %s, printing csv \n", format)
+		}
+		format = BAR_FORMAT_CSV
+	}
+	o.listeners = append(o.listeners, &barWriter{
+		out:      out,
+		format:   format,
+		size:     o.config.BarSize(),
+		interval: o.config.Bars.Interval,
+		now:      time.Now,
+		open:     make(map[message.Symbol]*Bar),
+		summary:  make(map[message.Symbol]*Bar),
+	})
+	o.outputs = append(o.outputs, out)
+}
+
+func (b *barWriter) OnOrderAdded(event OrderAddedEvent)       { b.advance(event.Seq, event.Timestamp) }
+func (b *barWriter) OnOrderUpdated(event OrderUpdatedEvent)   { b.advance(event.Seq, event.Timestamp) }
+func (b *barWriter) OnOrderDeleted(event OrderDeletedEvent)   { b.advance(event.Seq, event.Timestamp) }
+func (b *barWriter) OnOrderExecuted(event OrderExecutedEvent) { b.advance(event.Seq, event.Timestamp) }
+
+func (b *barWriter) OnTrade(event TradeEvent) {
+	start, end := b.bounds(event.Seq, event.Timestamp)
+	bar, ok := b.open[event.Symbol]
+	if !ok {
+		bar = &Bar{Kind: BAR_KIND_BAR, Symbol: event.Symbol.String(), Start: start, End: end, Scale: event.Scale}
+		b.open[event.Symbol] = bar
+	}
+	bar.add(event)
+}
+
+// bounds returns the start and end of the bar that the msg falls into
+// time bars use the capture time of the msg, or the clock when the msg was not read from a capture
+func (b *barWriter) bounds(seq uint32, timestamp time.Time) (int64, int64) {
+	if b.interval > 0 {
+		if timestamp.IsZero() {
+			timestamp = b.now()
+		}
+		start := timestamp.Truncate(b.interval)
+		return start.UnixMilli(), start.Add(b.interval).UnixMilli()
+	}
+	start := int64(seq - seq%b.size)
+	return start, start + int64(b.size)
+}
+
+// advance prints the open bars that end before the bar of the msg
+func (b *barWriter) advance(seq uint32, timestamp time.Time) {
+	start, _ := b.bounds(seq, timestamp)
+	for _, symbol := range sortedSymbols(b.open) {
+		if bar := b.open[symbol]; bar.End <= start {
+			b.complete(symbol, bar)
+		}
+	}
+}
+
+// complete prints the bar and adds it to the summary of the symbol
+func (b *barWriter) complete(symbol message.Symbol, bar *Bar) {
+	delete(b.open, symbol)
+	b.print(symbol, *bar)
+	summary, ok := b.summary[symbol]
+	if !ok {
+		summary = &Bar{}
+		b.summary[symbol] = summary
+	}
+	summary.merge(*bar)
+	summary.Kind = BAR_KIND_SUMMARY
+}
+
+// flush prints the open bars and the summary of every symbol, it is called when the stream ends
+func (b *barWriter) flush() {
+	for _, symbol := range sortedSymbols(b.open) {
+		b.complete(symbol, b.open[symbol])
+	}
+	for _, symbol := range sortedSymbols(b.summary) {
+		b.print(symbol, *b.summary[symbol])
+	}
+}
+
+// print sends the bar to out in the configured format
+func (b *barWriter) print(symbol message.Symbol, bar Bar) {
+	if b.format == BAR_FORMAT_JSON {
+		line, err := json.Marshal(bar)
+		if err != nil {
+			log.Printf("unable to print bar: %s \n", err.Error())
+			return
+		}
+		b.out <- queue.Line{Symbol: symbol, Text: string(line) + "\n"}
+		return
+	}
+	if !b.started {
+		b.out <- queue.Line{Text: BAR_CSV_HEADER}
+	}
+	b.out <- queue.Line{Symbol: symbol, Text: fmt.Sprintf("%s,%s,%d,%d,%s,%s,%s,%s,%d,%s,%d\n", bar.Kind, bar.Symbol, bar.Start, bar.End,
+		FormatPrice(bar.Open, bar.Scale), FormatPrice(bar.High, bar.Scale), FormatPrice(bar.Low, bar.Scale), FormatPrice(bar.Close, bar.Scale),
+		bar.Volume, formatScaled(bar.Vwap, bar.Scale, -1), bar.Count)}
+	b.started = true
+}
+
+// sortedSymbols returns the symbols of the bars in order, so that bars completed together are always printed in the same order
+func sortedSymbols(bars map[message.Symbol]*Bar) []message.Symbol {
+	symbols := make([]message.Symbol, 0, len(bars))
+	for symbol := range bars {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].String() < symbols[j].String() })
+	return symbols
+}
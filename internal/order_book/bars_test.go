@@ -0,0 +1,141 @@
+package order_book
+
+import (
+	"fmt"
+	"time"
+
+	configPkg "github.com/albertsundjaja/order_book/config"
+	inmem_db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/queue"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bars", func() {
+	var config *configPkg.Config
+	var streamChan chan message.Message
+	var barChan chan queue.Line
+	var orderBookManager *OrderBookManager
+
+	added := func(seq uint32, symbol string, orderId uint64, price int64) message.Message {
+		s := message.NewSymbol(symbol)
+		return message.Message{
+			Symbol:    s,
+			MsgType:   message.MSG_TYPE_ADDED,
+			MsgHeader: message.Header{Seq: seq},
+			MsgBody:   message.MessageAdded{Symbol: s, OrderId: orderId, Side: [1]byte{message.SIDE_SELL}, Price: price, Size: 100},
+		}
+	}
+	executed := func(seq uint32, symbol string, orderId uint64, qty uint64) message.Message {
+		s := message.NewSymbol(symbol)
+		return message.Message{
+			Symbol:    s,
+			MsgType:   message.MSG_TYPE_EXECUTED,
+			MsgHeader: message.Header{Seq: seq},
+			MsgBody:   message.MessageExecuted{Symbol: s, OrderId: orderId, Side: [1]byte{message.SIDE_SELL}, TradedQty: qty},
+		}
+	}
+	// apply applies the msg and ends the stream so that the summary is printed
+	apply := func(msgs ...message.Message) []string {
+		for _, msg := range msgs {
+			Expect(orderBookManager.Apply(msg)).To(Succeed())
+		}
+		close(streamChan)
+		Expect(orderBookManager.ProcessMessage()).To(Succeed())
+		lines := make([]string, 0)
+		for line := range barChan {
+			lines = append(lines, line.Text)
+		}
+		return lines
+	}
+
+	BeforeEach(func() {
+		config = &configPkg.Config{}
+		config.OrderBook.Depth = 1
+		config.Bars.Size = 10
+		streamChan = make(chan message.Message)
+		barChan = make(chan queue.Line, 100)
+	})
+
+	JustBeforeEach(func() {
+		orderBookManager = NewOrderBookManager(config, streamChan, nil, inmem_db.NewOrderBookDb(config))
+		orderBookManager.SubscribeBars(barChan)
+	})
+
+	Context("with seq bars", func() {
+		It("should complete a bar once a later msg falls into the next bar", func() {
+			lines := apply(
+				added(1, "VC0", 1, 100), added(2, "VC0", 2, 102),
+				executed(3, "VC0", 1, 10), executed(4, "VC0", 2, 30),
+				added(12, "VC1", 3, 50), executed(13, "VC0", 2, 20),
+			)
+			Expect(lines).To(Equal([]string{
+				BAR_CSV_HEADER,
+				"bar,VC0,0,10,100,102,100,102,40,101.5,2\n",
+				"bar,VC0,10,20,102,102,102,102,20,102,1\n",
+				"summary,VC0,0,20,100,102,100,102,60,101.66666666666667,3\n",
+			}))
+		})
+	})
+
+	Context("with json format", func() {
+		BeforeEach(func() {
+			config.Bars.Format = BAR_FORMAT_JSON
+		})
+
+		It("should print a json object per line", func() {
+			lines := apply(added(1, "VC0", 1, 100), executed(2, "VC0", 1, 10))
+			Expect(lines).To(Equal([]string{
+				`{"kind":"bar","symbol":"VC0","start":0,"end":10,"open":100,"high":100,"low":100,"close":100,"volume":10,"vwap":100,"count":1,"scale":0}` + "\n",
+				`{"kind":"summary","symbol":"VC0","start":0,"end":10,"open":100,"high":100,"low":100,"close":100,"volume":10,"vwap":100,"count":1,"scale":0}` + "\n",
+			}))
+		})
+	})
+
+	Context("with time bars", func() {
+		BeforeEach(func() {
+			config.Bars.Interval = time.Minute
+		})
+
+		It("should use the processing time instead of the seq", func() {
+			now := time.Date(2020, 1, 1, 10, 0, 30, 0, time.UTC)
+			orderBookManager.listeners[0].(*barWriter).now = func() time.Time { return now }
+			Expect(orderBookManager.Apply(added(1, "VC0", 1, 100))).To(Succeed())
+			Expect(orderBookManager.Apply(executed(2, "VC0", 1, 10))).To(Succeed())
+			Expect(barChan).To(Not(Receive()))
+
+			now = now.Add(time.Minute)
+			lines := apply(added(3, "VC0", 2, 101))
+			start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC).UnixMilli()
+			Expect(lines[:2]).To(Equal([]string{
+				BAR_CSV_HEADER,
+				fmt.Sprintf("bar,VC0,%d,%d,100,100,100,100,10,100,1\n", start, start+time.Minute.Milliseconds()),
+			}))
+		})
+
+		It("should use the capture time of the msg when it has one", func() {
+			// the clock is an hour off the capture, it must not be read
+			orderBookManager.listeners[0].(*barWriter).now = func() time.Time { return time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC) }
+			captured := func(msg message.Message, timestamp time.Time) message.Message {
+				msg.Timestamp = timestamp
+				return msg
+			}
+			first := time.Date(2020, 1, 1, 10, 0, 30, 0, time.UTC)
+			lines := apply(
+				captured(added(1, "VC0", 1, 100), first),
+				captured(executed(2, "VC0", 1, 10), first.Add(10*time.Second)),
+				captured(added(3, "VC0", 2, 101), first.Add(time.Minute)),
+				captured(executed(4, "VC0", 2, 20), first.Add(time.Minute)),
+			)
+			start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC).UnixMilli()
+			minute := time.Minute.Milliseconds()
+			Expect(lines).To(Equal([]string{
+				BAR_CSV_HEADER,
+				fmt.Sprintf("bar,VC0,%d,%d,100,100,100,100,10,100,1\n", start, start+minute),
+				fmt.Sprintf("bar,VC0,%d,%d,101,101,101,101,20,101,1\n", start+minute, start+2*minute),
+				fmt.Sprintf("summary,VC0,%d,%d,100,101,100,101,30,100.66666666666667,2\n", start, start+2*minute),
+			}))
+		})
+	})
+})
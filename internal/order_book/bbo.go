@@ -0,0 +1,66 @@
+package order_book
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/queue"
+)
+
+// Spread returns the best sell price minus the best buy price, false if either side is empty
+func (e TopOfBookEvent) Spread() (int64, bool) {
+	if e.Buy.Volume == 0 || e.Sell.Volume == 0 {
+		return 0, false
+	}
+	return e.Sell.Price - e.Buy.Price, true
+}
+
+// Mid returns the price halfway between the best buy and sell price, false if either side is empty
+func (e TopOfBookEvent) Mid() (float64, bool) {
+	if e.Buy.Volume == 0 || e.Sell.Volume == 0 {
+		return 0, false
+	}
+	return (float64(e.Buy.Price) + float64(e.Sell.Price)) / 2, true
+}
+
+// bboWriter sends every top of book change to out as a printed line
+type bboWriter struct {
+	NopListener
+	out chan<- queue.Line
+}
+
+func (b bboWriter) OnTopOfBookChanged(event TopOfBookEvent) {
+	b.out <- queue.Line{Symbol: event.Symbol, Text: printBbo(event)}
+}
+
+// SubscribeBbo adds an output that receives a line whenever the best buy or sell level of a symbol changes
+// it can be used together with the depth outputs. It must be called before ProcessMessage is started, out is closed when ProcessMessage returns
+func (o *OrderBookManager) SubscribeBbo(out chan<- queue.Line) {
+	// not added through AddListener as the writer does not need the depth
+	o.listeners = append(o.listeners, bboWriter{out: out})
+	o.outputs = append(o.outputs, out)
+}
+
+// printBbo returns the line printed for the top of book, an empty side or an undefined spread and mid is printed as -
+// e.g. 4, VC0, 318800, 4709, 318900, 360, 100, 318850
+func printBbo(event TopOfBookEvent) string {
+	buyPrice, buySize := printLevel(event.Buy, event.Scale)
+	sellPrice, sellSize := printLevel(event.Sell, event.Scale)
+	spread, mid := "-", "-"
+	if value, ok := event.Spread(); ok {
+		spread = FormatPrice(value, event.Scale)
+	}
+	if value, ok := event.Mid(); ok {
+		mid = formatScaled(value, event.Scale, -1)
+	}
+	return fmt.Sprintf("%d, %s, %s, %s, %s, %s, %s, %s\n", event.Seq, event.Symbol.String(), buyPrice, buySize, sellPrice, sellSize, spread, mid)
+}
+
+// printLevel returns the price and volume of a level, - for both if the level is empty
+func printLevel(level db.Level, scale int) (string, string) {
+	if level.Volume == 0 {
+		return "-", "-"
+	}
+	return FormatPrice(level.Price, scale), strconv.FormatUint(level.Volume, 10)
+}
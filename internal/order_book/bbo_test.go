@@ -0,0 +1,26 @@
+package order_book
+
+import (
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/message"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("printBbo", func() {
+	symbol := message.NewSymbol("VC0")
+
+	Context("with both sides", func() {
+		It("should print the spread and mid", func() {
+			event := TopOfBookEvent{Seq: 4, Symbol: symbol, Buy: db.Level{Price: 318800, Volume: 4709}, Sell: db.Level{Price: 318900, Volume: 360}}
+			Expect(printBbo(event)).To(Equal("4, VC0, 318800, 4709, 318900, 360, 100, 318850\n"))
+		})
+	})
+
+	Context("with an empty sell side", func() {
+		It("should print - for the sell level, spread and mid", func() {
+			event := TopOfBookEvent{Seq: 1, Symbol: symbol, Buy: db.Level{Price: 3, Volume: 1}}
+			Expect(printBbo(event)).To(Equal("1, VC0, 3, 1, -, -, -, -\n"))
+		})
+	})
+})
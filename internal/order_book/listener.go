@@ -0,0 +1,141 @@
+package order_book
+
+import (
+	"time"
+
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// Listener receives the typed events of every book, embed NopListener to only handle some of them
+// listeners are called on the processing routine after the msg is applied to the DB, a slow listener slows down the stream
+type Listener interface {
+	OnOrderAdded(OrderAddedEvent)
+	OnOrderUpdated(OrderUpdatedEvent)
+	OnOrderDeleted(OrderDeletedEvent)
+	OnOrderExecuted(OrderExecutedEvent)
+	OnTopOfBookChanged(TopOfBookEvent)
+	OnDepthChanged(DepthEvent) // at the depth configured for the symbol
+	OnTrade(TradeEvent)        // after OnOrderExecuted
+}
+
+// flusher is implemented by the listeners that print what is left when the stream ends
+type flusher interface {
+	flush()
+}
+
+// NopListener ignores every event
+type NopListener struct{}
+
+func (NopListener) OnOrderAdded(OrderAddedEvent)       {}
+func (NopListener) OnOrderUpdated(OrderUpdatedEvent)   {}
+func (NopListener) OnOrderDeleted(OrderDeletedEvent)   {}
+func (NopListener) OnOrderExecuted(OrderExecutedEvent) {}
+func (NopListener) OnTopOfBookChanged(TopOfBookEvent)  {}
+func (NopListener) OnDepthChanged(DepthEvent)          {}
+func (NopListener) OnTrade(TradeEvent)                 {}
+
+// OrderAddedEvent is sent after an order is added
+type OrderAddedEvent struct {
+	Seq       uint32
+	Symbol    message.Symbol
+	OrderId   uint64
+	Side      byte // message.SIDE_BUY or message.SIDE_SELL
+	Price     int64
+	Size      uint64
+	Timestamp time.Time // capture time of the msg, zero when not read from a capture
+}
+
+// OrderUpdatedEvent is sent after an order price or size is updated
+type OrderUpdatedEvent struct {
+	Seq       uint32
+	Symbol    message.Symbol
+	OrderId   uint64
+	Side      byte
+	Price     int64 // new price
+	Size      uint64
+	Timestamp time.Time // capture time of the msg, zero when not read from a capture
+}
+
+// OrderDeletedEvent is sent after an order is deleted
+type OrderDeletedEvent struct {
+	Seq       uint32
+	Symbol    message.Symbol
+	OrderId   uint64
+	Side      byte
+	Timestamp time.Time // capture time of the msg, zero when not read from a capture
+}
+
+// OrderExecutedEvent is sent after an order is partially or fully executed
+type OrderExecutedEvent struct {
+	Seq       uint32
+	Symbol    message.Symbol
+	OrderId   uint64
+	Side      byte
+	TradedQty uint64
+	Timestamp time.Time // capture time of the msg, zero when not read from a capture
+}
+
+// TopOfBookEvent is sent when the best price or its volume changes on either side
+// a side without any order has a zero Level
+type TopOfBookEvent struct {
+	Seq    uint32
+	Symbol message.Symbol
+	Buy    db.Level
+	Sell   db.Level
+	Scale  int // decimal places of the prices of the symbol
+}
+
+// AddListener registers the listener for the events of every symbol. It must be called before ProcessMessage is started
+func (o *OrderBookManager) AddListener(listener Listener) {
+	o.listeners = append(o.listeners, listener)
+	o.OnDepthChanged(0, listener.OnDepthChanged)
+}
+
+// notifyOrder sends the order event of the applied msg to the listeners
+func (o *OrderBookManager) notifyOrder(msg message.Message) {
+	if len(o.listeners) == 0 {
+		return
+	}
+	seq, timestamp := msg.MsgHeader.Seq, msg.Timestamp
+	for _, listener := range o.listeners {
+		switch body := msg.MsgBody.(type) {
+		case message.MessageAdded:
+			listener.OnOrderAdded(OrderAddedEvent{Seq: seq, Symbol: msg.Symbol, OrderId: body.OrderId, Side: body.Side[0], Price: body.Price, Size: body.Size, Timestamp: timestamp})
+		case message.MessageUpdated:
+			listener.OnOrderUpdated(OrderUpdatedEvent{Seq: seq, Symbol: msg.Symbol, OrderId: body.OrderId, Side: body.Side[0], Price: body.Price, Size: body.Size, Timestamp: timestamp})
+		case message.MessageDeleted:
+			listener.OnOrderDeleted(OrderDeletedEvent{Seq: seq, Symbol: msg.Symbol, OrderId: body.OrderId, Side: body.Side[0], Timestamp: timestamp})
+		case message.MessageExecuted:
+			listener.OnOrderExecuted(OrderExecutedEvent{Seq: seq, Symbol: msg.Symbol, OrderId: body.OrderId, Side: body.Side[0], TradedQty: body.TradedQty, Timestamp: timestamp})
+		}
+	}
+}
+
+// notifyTopOfBook sends a TopOfBookEvent to the listeners if the best level of either side is different from the last one sent
+func (o *OrderBookManager) notifyTopOfBook(msg message.Message, changedLevel int) error {
+	if len(o.listeners) == 0 || changedLevel != 0 {
+		return nil
+	}
+	buy, sell, err := o.db.Depth(msg.Symbol, 1)
+	if err != nil {
+		return err
+	}
+	event := TopOfBookEvent{Seq: msg.MsgHeader.Seq, Symbol: msg.Symbol, Scale: o.priceOf(msg.Symbol).scale}
+	if len(buy) > 0 {
+		event.Buy = buy[0]
+	}
+	if len(sell) > 0 {
+		event.Sell = sell[0]
+	}
+	// an update at the best price that does not change its volume still reports level 0
+	last, ok := o.lastTopOfBook[msg.Symbol]
+	if ok && last.Buy == event.Buy && last.Sell == event.Sell {
+		return nil
+	}
+	o.lastTopOfBook[msg.Symbol] = event
+	for _, listener := range o.listeners {
+		listener.OnTopOfBookChanged(event)
+	}
+	return nil
+}
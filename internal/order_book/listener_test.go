@@ -0,0 +1,91 @@
+package order_book
+
+import (
+	configPkg "github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db"
+	inmem_db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// recordingListener keeps every event it receives
+type recordingListener struct {
+	NopListener
+	added     []OrderAddedEvent
+	executed  []OrderExecutedEvent
+	topOfBook []TopOfBookEvent
+	depths    []DepthEvent
+}
+
+func (r *recordingListener) OnOrderAdded(event OrderAddedEvent) { r.added = append(r.added, event) }
+func (r *recordingListener) OnOrderExecuted(event OrderExecutedEvent) {
+	r.executed = append(r.executed, event)
+}
+func (r *recordingListener) OnTopOfBookChanged(event TopOfBookEvent) {
+	r.topOfBook = append(r.topOfBook, event)
+}
+func (r *recordingListener) OnDepthChanged(event DepthEvent) { r.depths = append(r.depths, event) }
+
+var _ = Describe("Listener", func() {
+	symbol := message.NewSymbol("VC0")
+	var listener *recordingListener
+	var orderBookManager *OrderBookManager
+
+	addMsg := func(seq uint32, orderId uint64, side byte, price int64, size uint64) message.Message {
+		return message.Message{
+			Symbol:    symbol,
+			MsgType:   message.MSG_TYPE_ADDED,
+			MsgHeader: message.Header{Seq: seq},
+			MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: orderId, Side: [1]byte{side}, Price: price, Size: size},
+		}
+	}
+
+	BeforeEach(func() {
+		config := &configPkg.Config{}
+		config.OrderBook.Depth = 2
+		listener = &recordingListener{}
+		orderBookManager = NewOrderBookManager(config, nil, nil, inmem_db.NewOrderBookDb(config))
+		orderBookManager.AddListener(listener)
+	})
+
+	It("should send the order events with the seq and symbol", func() {
+		Expect(orderBookManager.Apply(addMsg(1, 10, message.SIDE_BUY, 100, 5))).To(Succeed())
+		Expect(orderBookManager.Apply(message.Message{
+			Symbol:    symbol,
+			MsgType:   message.MSG_TYPE_EXECUTED,
+			MsgHeader: message.Header{Seq: 2},
+			MsgBody:   message.MessageExecuted{Symbol: symbol, OrderId: 10, Side: [1]byte{message.SIDE_BUY}, TradedQty: 2},
+		})).To(Succeed())
+
+		Expect(listener.added).To(Equal([]OrderAddedEvent{{Seq: 1, Symbol: symbol, OrderId: 10, Side: message.SIDE_BUY, Price: 100, Size: 5}}))
+		Expect(listener.executed).To(Equal([]OrderExecutedEvent{{Seq: 2, Symbol: symbol, OrderId: 10, Side: message.SIDE_BUY, TradedQty: 2}}))
+	})
+
+	It("should only send the top of book when the best level changes", func() {
+		Expect(orderBookManager.Apply(addMsg(1, 10, message.SIDE_BUY, 100, 5))).To(Succeed())
+		Expect(orderBookManager.Apply(addMsg(2, 11, message.SIDE_BUY, 99, 5))).To(Succeed())
+		Expect(orderBookManager.Apply(addMsg(3, 12, message.SIDE_SELL, 101, 3))).To(Succeed())
+		// same price and size as order 10, level 0 is touched but not changed
+		Expect(orderBookManager.Apply(message.Message{
+			Symbol:    symbol,
+			MsgType:   message.MSG_TYPE_UPDATED,
+			MsgHeader: message.Header{Seq: 4},
+			MsgBody:   message.MessageUpdated{Symbol: symbol, OrderId: 10, Side: [1]byte{message.SIDE_BUY}, Price: 100, Size: 5},
+		})).To(Succeed())
+
+		Expect(listener.topOfBook).To(Equal([]TopOfBookEvent{
+			{Seq: 1, Symbol: symbol, Buy: db.Level{Price: 100, Volume: 5}},
+			{Seq: 3, Symbol: symbol, Buy: db.Level{Price: 100, Volume: 5}, Sell: db.Level{Price: 101, Volume: 3}},
+		}))
+	})
+
+	It("should send the depth at the configured depth", func() {
+		Expect(orderBookManager.Apply(addMsg(1, 10, message.SIDE_BUY, 100, 5))).To(Succeed())
+		Expect(orderBookManager.Apply(addMsg(2, 11, message.SIDE_BUY, 99, 5))).To(Succeed())
+		Expect(orderBookManager.Apply(addMsg(3, 12, message.SIDE_BUY, 98, 5))).To(Succeed())
+
+		Expect(listener.depths).To(HaveLen(2))
+		Expect(listener.depths[1].Buy).To(Equal([]db.Level{{Price: 100, Volume: 5}, {Price: 99, Volume: 5}}))
+	})
+})
@@ -1,101 +1,412 @@
 package order_book
 
 import (
+	"errors"
 	"fmt"
 
 	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/albertsundjaja/order_book/config"
 	"github.com/albertsundjaja/order_book/internal/db"
 	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/metrics"
+	"github.com/albertsundjaja/order_book/internal/queue"
 )
 
 // OrderBookManager contains the books of all the symbols
 type OrderBookManager struct {
-	config      *config.Config         // store app config
-	db          db.IDbOrderBook        // store all our order data
-	streamChan  <-chan message.Message // channel for receiving message from StreamHandler
-	managerChan chan bool              // for communicating with the main routine for termination
-	printChan   chan<- string          // for sending out the result of the market depth
+	config     *config.Config         // store app config
+	db         db.IDbOrderBook        // store all our order data
+	streamChan <-chan message.Message // channel for receiving message from StreamHandler
+	sinks      []*depthSink           // all the outputs of the market depth
+	depth      depthSettings          // depth printed for each symbol
+	reloadChan chan reloadSettings    // for changing the depth and price settings while processing
+	queryChan  chan func()            // queries run between two msg while processing
+	lastSeq    uint32                 // seq of the last applied msg
+	done       chan struct{}          // closed when ProcessMessage returns
+	stopped    chan struct{}          // closed by Stop when ProcessMessage will not be called
+	stopOnce   sync.Once
+	listeners  []Listener          // receive the typed events of every book
+	outputs    []chan<- queue.Line // outputs of the listeners, closed when ProcessMessage returns
+	// last top of book sent to the listeners for each symbol
+	lastTopOfBook map[message.Symbol]TopOfBookEvent
+	tradeStats    map[message.Symbol]TradeStats    // last sale and cumulative volume of each symbol
+	prices        map[message.Symbol]priceSettings // scale and tick size of each symbol
+	rejected      map[orderKey]uint64              // remaining size of the orders whose added msg was rejected, their later msg are rejected too
+	metrics       *metrics.Metrics                 // counts the applied msg and printed depth, nil when not exposed
+}
+
+// depthSettings is the depth printed for each symbol
+type depthSettings struct {
+	defaultDepth int                    // depth of symbols without their own depth
+	symbolDepth  map[message.Symbol]int // depth of each configured symbol
+}
+
+// depthSink is an output that receives the market depth whenever its top levels change
+// either out or fn is set
+type depthSink struct {
+	depth     int                       // depth printed to this sink, 0 means the depth of the symbol
+	increment int64                     // price increment that the levels are grouped into, 0 means the exact prices
+	out       chan<- queue.Line         // where to send the printed market depth
+	fn        func(DepthEvent)          // callback receiving the market depth levels
+	watched   func(message.Symbol) bool // symbols fn is called for, nil means every symbol
+	// last grouped depth sent for each symbol, as the changed level does not tell which buckets changed
+	last map[message.Symbol]string
+}
+
+// DepthEvent is the market depth of a symbol after a msg changed its top levels
+type DepthEvent struct {
+	Seq    uint32         // seq of the msg that changed the depth
+	Symbol message.Symbol // symbol of the depth
+	Buy    []db.Level     // top buy levels, best price first
+	Sell   []db.Level     // top sell levels, best price first
+	Scale  int            // decimal places of the prices of the symbol
 }
 
 // NewOrderBook manager init the OrderBookManager
-func NewOrderBookManager(config *config.Config, managerChan chan bool, streamChan <-chan message.Message, printChan chan<- string, db db.IDbOrderBook) *OrderBookManager {
-	return &OrderBookManager{
-		config:      config,
-		streamChan:  streamChan,
-		managerChan: managerChan,
-		printChan:   printChan,
-		db:          db,
+// printChan receives the market depth at the depth configured for each symbol, it can be nil when the manager is only used through Apply
+func NewOrderBookManager(config *config.Config, streamChan <-chan message.Message, printChan chan<- queue.Line, db db.IDbOrderBook) *OrderBookManager {
+	o := &OrderBookManager{
+		config:     config,
+		streamChan: streamChan,
+		depth:      newDepthSettings(config),
+		reloadChan: make(chan reloadSettings),
+		queryChan:  make(chan func()),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		db:         db,
+
+		lastTopOfBook: make(map[message.Symbol]TopOfBookEvent),
+		tradeStats:    make(map[message.Symbol]TradeStats),
+		prices:        make(map[message.Symbol]priceSettings),
+		rejected:      make(map[orderKey]uint64),
+	}
+	if printChan != nil {
+		o.SubscribeGrouped(0, config.OrderBook.PriceGroup, printChan)
+	}
+	return o
+}
+
+// SetMetrics records the applied msg, their duration and the printed market depth into m. It must be called before ProcessMessage is started
+func (o *OrderBookManager) SetMetrics(m *metrics.Metrics) {
+	o.metrics = m
+}
+
+// Subscribe adds another output that receives the market depth of every symbol at the given depth
+// 0 means the depth configured for each symbol. It must be called before ProcessMessage is started, out is closed when ProcessMessage returns
+func (o *OrderBookManager) Subscribe(depth int, out chan<- queue.Line) {
+	o.sinks = append(o.sinks, &depthSink{depth: depth, out: out})
+}
+
+// SubscribeGrouped adds another output that receives the market depth with the levels grouped into buckets of the price increment
+// e.g. with 100, the buy levels 318850 and 318810 are printed as a single (318800, volume, orders) bucket. depth is the number of buckets
+// It must be called before ProcessMessage is started, out is closed when ProcessMessage returns
+func (o *OrderBookManager) SubscribeGrouped(depth int, increment int64, out chan<- queue.Line) {
+	if increment <= 1 {
+		o.Subscribe(depth, out)
+		return
+	}
+	o.sinks = append(o.sinks, &depthSink{depth: depth, increment: increment, out: out, last: make(map[message.Symbol]string)})
+}
+
+// OnDepthChanged registers a callback that receives the depth levels of a symbol whenever its top depth levels change
+// 0 means the depth configured for each symbol. It must be called before ProcessMessage is started
+func (o *OrderBookManager) OnDepthChanged(depth int, fn func(DepthEvent)) {
+	o.OnWatchedDepthChanged(depth, nil, fn)
+}
+
+// OnWatchedDepthChanged is OnDepthChanged for the symbols that watched returns true for, the levels of the other symbols are not read
+// watched runs on the processing routine before every depth change, nil means every symbol
+func (o *OrderBookManager) OnWatchedDepthChanged(depth int, watched func(message.Symbol) bool, fn func(DepthEvent)) {
+	o.sinks = append(o.sinks, &depthSink{depth: depth, fn: fn, watched: watched})
+}
+
+// SetDepth changes the default and per symbol depth, scale and tick size while ProcessMessage is running, e.g. after the config file is modified
+// the books are not rebuilt, the next printed market depth uses the new settings
+func (o *OrderBookManager) SetDepth(config *config.Config) {
+	select {
+	case o.reloadChan <- reloadSettings{config: config, depth: newDepthSettings(config)}:
+	case <-o.done:
+	case <-o.stopped:
 	}
 }
 
-// ProcessMessage process the message received from the stream
-func (o *OrderBookManager) ProcessMessage() {
-mainLoop:
+// reloadSettings is a config applied while processing
+type reloadSettings struct {
+	config *config.Config
+	depth  depthSettings
+}
+
+// reload applies the depth of the config and drops the price settings read from the previous one
+func (o *OrderBookManager) reload(settings reloadSettings) {
+	o.config = settings.config
+	o.depth = settings.depth
+	o.prices = make(map[message.Symbol]priceSettings)
+}
+
+// newDepthSettings reads the default and per symbol depth from the config
+func newDepthSettings(config *config.Config) depthSettings {
+	settings := depthSettings{
+		defaultDepth: config.DefaultDepth(),
+		symbolDepth:  make(map[message.Symbol]int),
+	}
+	for _, symbolConfig := range config.OrderBook.Symbols {
+		settings.symbolDepth[message.NewSymbol(symbolConfig.Symbol)] = config.SymbolDepth(symbolConfig.Symbol)
+	}
+	return settings
+}
+
+// depthOf returns the depth the sink should print for the symbol
+func (o *OrderBookManager) depthOf(sink *depthSink, symbol message.Symbol) int {
+	if sink.depth > 0 {
+		return sink.depth
+	}
+	if depth, ok := o.depth.symbolDepth[symbol]; ok {
+		return depth
+	}
+	return o.depth.defaultDepth
+}
+
+// ProcessMessage process the message received from the stream until streamChan is closed
+// every output is closed when it returns, so that they can be drained before shutting down
+// returns the first error, the caller must stop the stream and drain streamChan as the remaining msg are not read
+func (o *OrderBookManager) ProcessMessage() error {
+	defer func() {
+		for _, listener := range o.listeners {
+			if f, ok := listener.(flusher); ok {
+				f.flush()
+			}
+		}
+		for _, sink := range o.sinks {
+			if sink.out != nil {
+				close(sink.out)
+			}
+		}
+		for _, out := range o.outputs {
+			close(out)
+		}
+		close(o.done)
+	}()
 	for {
 		select {
-		case msg := <-o.streamChan:
-			marketDepth, err := o.processMessage(msg)
-			if err != nil {
-				log.Printf("error occurred in ProcessMessage: %s \n", err.Error())
-				o.managerChan <- true
-				break mainLoop
+		case msg, ok := <-o.streamChan:
+			if !ok {
+				return nil
 			}
-			if marketDepth != "" {
-				o.printChan <- marketDepth
+			start := time.Now()
+			if err := o.Apply(msg); err != nil {
+				// a rejected msg did not change the books, the stream goes on without it
+				if errors.Is(err, ErrRejected) {
+					o.metrics.MessageRejected()
+					continue
+				}
+				log.Printf("error occurred in ProcessMessage: %s \n", err.Error())
+				return err
 			}
-		case <-o.managerChan:
-			break mainLoop
+			o.metrics.MessageApplied(msg.Symbol, time.Since(start))
+		case settings := <-o.reloadChan:
+			o.reload(settings)
+		case query := <-o.queryChan:
+			query()
 		}
 	}
 }
 
+// Apply processes a single msg synchronously and publishes the market depth, ProcessMessage calls it for every msg of the stream
+// a msg with an invalid price is rejected without changing the books, the returned error wraps ErrRejected
+func (o *OrderBookManager) Apply(msg message.Message) error {
+	if err := o.reject(msg); err != nil {
+		log.Printf("Rejected msg. Error: %s \n", err.Error())
+		return err
+	}
+	var trade TradeEvent
+	if msg.MsgType == message.MSG_TYPE_EXECUTED {
+		var err error
+		if trade, err = o.trade(msg); err != nil {
+			log.Printf("Unable to execute order. Error: %s \n", err.Error())
+			return err
+		}
+	}
+	changedLevel, err := o.processMessage(msg)
+	if err != nil {
+		return err
+	}
+	o.notifyOrder(msg)
+	if msg.MsgType == message.MSG_TYPE_EXECUTED {
+		o.notifyTrade(trade)
+	}
+	if err = o.notifyTopOfBook(msg, changedLevel); err != nil {
+		return err
+	}
+	o.lastSeq = msg.MsgHeader.Seq
+	return o.publishDepth(msg, changedLevel)
+}
+
 // processMessage parse the raw msg and send it to DB
-// returns empty string if the msg does not update the top N depth otherwise, it returns the complete string for the market depth
-func (o *OrderBookManager) processMessage(msg message.Message) (string, error) {
-	var shouldPrint bool
+// returns the shallowest depth level changed by the msg, db.NO_LEVEL_CHANGED if none
+func (o *OrderBookManager) processMessage(msg message.Message) (int, error) {
+	var changedLevel int
 	var err error
 	switch msg.MsgType {
 	case message.MSG_TYPE_ADDED:
-		addedMsg := msg.MsgBody.(message.MessageAdded)
-		shouldPrint, err = o.db.AddOrder(addedMsg)
+		addedMsg, ok := msg.MsgBody.(message.MessageAdded)
+		if !ok {
+			return db.NO_LEVEL_CHANGED, bodyMismatch(msg)
+		}
+		changedLevel, err = o.db.AddOrder(addedMsg)
 		if err != nil {
 			log.Printf("Unable to add order. Error: %s \n", err.Error())
-			return "", err
+			return db.NO_LEVEL_CHANGED, err
 		}
 	case message.MSG_TYPE_UPDATED:
-		updatedMsg := msg.MsgBody.(message.MessageUpdated)
-		shouldPrint, err = o.db.UpdateOrder(updatedMsg)
+		updatedMsg, ok := msg.MsgBody.(message.MessageUpdated)
+		if !ok {
+			return db.NO_LEVEL_CHANGED, bodyMismatch(msg)
+		}
+		changedLevel, err = o.db.UpdateOrder(updatedMsg)
 		if err != nil {
 			log.Printf("Unable to update order. Error: %s \n", err.Error())
-			return "", err
+			return db.NO_LEVEL_CHANGED, err
 		}
 	case message.MSG_TYPE_DELETED:
-		delMsg := msg.MsgBody.(message.MessageDeleted)
-		shouldPrint, err = o.db.DeleteOrder(delMsg)
+		delMsg, ok := msg.MsgBody.(message.MessageDeleted)
+		if !ok {
+			return db.NO_LEVEL_CHANGED, bodyMismatch(msg)
+		}
+		changedLevel, err = o.db.DeleteOrder(delMsg)
 		if err != nil {
 			log.Printf("Unable to delete order. Error: %s \n", err.Error())
-			return "", err
+			return db.NO_LEVEL_CHANGED, err
 		}
 	case message.MSG_TYPE_EXECUTED:
-		exMsg := msg.MsgBody.(message.MessageExecuted)
-		shouldPrint, err = o.db.ExecuteOrder(exMsg)
+		exMsg, ok := msg.MsgBody.(message.MessageExecuted)
+		if !ok {
+			return db.NO_LEVEL_CHANGED, bodyMismatch(msg)
+		}
+		changedLevel, err = o.db.ExecuteOrder(exMsg)
 		if err != nil {
 			log.Printf("Unable to execute order. Error: %s \n", err.Error())
-			return "", err
+			return db.NO_LEVEL_CHANGED, err
 		}
 	default:
-		return "", fmt.Errorf("unrecognized message type %s", msg.MsgType)
+		return db.NO_LEVEL_CHANGED, fmt.Errorf("unrecognized message type %s", msg.MsgType)
 	}
-	if shouldPrint {
-		marketDepth, err := o.db.PrintDepth(msg.Symbol)
-		if err != nil {
-			log.Printf("Unable to get market depth: %s", err.Error())
-			return "", err
+	return changedLevel, nil
+}
+
+// bodyMismatch is the error of a msg whose body is not the one of its msg type
+func bodyMismatch(msg message.Message) error {
+	return fmt.Errorf("message type %s does not match its body %T", msg.MsgType, msg.MsgBody)
+}
+
+// publishDepth sends the market depth to every sink whose printed levels include the changed level
+func (o *OrderBookManager) publishDepth(msg message.Message, changedLevel int) error {
+	if changedLevel == db.NO_LEVEL_CHANGED {
+		return nil
+	}
+	// sinks with the same depth share the printed market depth
+	printed := make(map[int]string)
+	for _, sink := range o.sinks {
+		depth := o.depthOf(sink, msg.Symbol)
+		if sink.increment > 0 {
+			if err := o.publishGroupedDepth(sink, msg, depth); err != nil {
+				return err
+			}
+			continue
+		}
+		if changedLevel >= depth {
+			continue
+		}
+		if sink.fn != nil {
+			if sink.watched != nil && !sink.watched(msg.Symbol) {
+				continue
+			}
+			buy, sell, err := o.db.Depth(msg.Symbol, depth)
+			if err != nil {
+				return err
+			}
+			sink.fn(DepthEvent{Seq: msg.MsgHeader.Seq, Symbol: msg.Symbol, Buy: buy, Sell: sell, Scale: o.priceOf(msg.Symbol).scale})
+			continue
+		}
+		marketDepth, ok := printed[depth]
+		if !ok {
+			var err error
+			marketDepth, err = o.printDepth(msg, depth)
+			if err != nil {
+				return err
+			}
+			printed[depth] = marketDepth
+		}
+		sink.out <- queue.Line{Symbol: msg.Symbol, Text: marketDepth}
+		o.metrics.DepthPrinted()
+	}
+	return nil
+}
+
+// publishGroupedDepth sends the grouped market depth to the sink if it is different from the last one sent for the symbol
+// a bucket can hold any number of levels, so the changed level can not be compared to the depth
+func (o *OrderBookManager) publishGroupedDepth(sink *depthSink, msg message.Message, depth int) error {
+	buy, sell, err := o.db.GroupedDepth(msg.Symbol, depth, sink.increment)
+	if err != nil {
+		log.Printf("Unable to get grouped market depth: %s", err.Error())
+		return err
+	}
+	scale := o.priceOf(msg.Symbol).scale
+	marketDepth := fmt.Sprintf("%s, %s", printBuckets(buy, scale), printBuckets(sell, scale))
+	if last, ok := sink.last[msg.Symbol]; ok && last == marketDepth {
+		return nil
+	}
+	sink.last[msg.Symbol] = marketDepth
+	// e.g. 4, VC0, [(318800, 7695, 2)], [(319000, 360, 1)]
+	sink.out <- queue.Line{Symbol: msg.Symbol, Text: fmt.Sprintf("%d, %s, %s\n", msg.MsgHeader.Seq, msg.Symbol.String(), marketDepth)}
+	o.metrics.DepthPrinted()
+	return nil
+}
+
+// printBuckets returns the buckets of one side as (price, volume, orders)
+func printBuckets(buckets []db.Bucket, scale int) string {
+	parts := make([]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		parts = append(parts, fmt.Sprintf("(%s, %d, %d)", FormatPrice(bucket.Price, scale), bucket.Volume, bucket.Orders))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// printDepth returns the complete string for the market depth of the msg symbol
+// prices are printed with the scale of the symbol, the DB prints the raw prices when it is 0
+func (o *OrderBookManager) printDepth(msg message.Message, depth int) (string, error) {
+	var marketDepth string
+	var err error
+	if scale := o.priceOf(msg.Symbol).scale; scale > 0 {
+		marketDepth, err = o.printScaledDepth(msg.Symbol, depth, scale)
+	} else {
+		marketDepth, err = o.db.PrintDepth(msg.Symbol, depth)
+	}
+	if err != nil {
+		log.Printf("Unable to get market depth: %s", err.Error())
+		return "", err
+	}
+	// e.g. 4, VC0, [(318800, 4709), (315000, 2986)], [(318900, 360)]
+	return fmt.Sprintf("%d, %s, %s\n", msg.MsgHeader.Seq, msg.Symbol.String(), marketDepth), nil
+}
+
+// printScaledDepth returns the top depth levels in the same format as the DB with scale decimal places
+// e.g. [(31.8800, 4709), (31.5000, 2986)], [(31.8900, 360)]
+func (o *OrderBookManager) printScaledDepth(symbol message.Symbol, depth int, scale int) (string, error) {
+	buy, sell, err := o.db.Depth(symbol, depth)
+	if err != nil {
+		return "", err
+	}
+	sides := make([]string, 0, 2)
+	for _, levels := range [][]db.Level{buy, sell} {
+		parts := make([]string, 0, len(levels))
+		for _, level := range levels {
+			parts = append(parts, fmt.Sprintf("(%s, %d)", FormatPrice(level.Price, scale), level.Volume))
 		}
-		// e.g. 4, VC0, [(318800, 4709), (315000, 2986)], [(318900, 360)]
-		return fmt.Sprintf("%d, %s, %s\n", msg.MsgHeader.Seq, string(msg.Symbol[:]), marketDepth), nil
+		sides = append(sides, "["+strings.Join(parts, ", ")+"]")
 	}
-	return "", nil
+	return strings.Join(sides, ", "), nil
 }
@@ -3,9 +3,11 @@ package order_book
 import (
 	"fmt"
 
-	"github.com/albertsundjaja/order_book/config"
+	configPkg "github.com/albertsundjaja/order_book/config"
+	dbPkg "github.com/albertsundjaja/order_book/internal/db"
 	"github.com/albertsundjaja/order_book/internal/message"
 	mockDb "github.com/albertsundjaja/order_book/internal/mock/db"
+	"github.com/albertsundjaja/order_book/internal/queue"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -14,21 +16,25 @@ import (
 var _ = Describe("OrderBookManager", func() {
 	var control *gomock.Controller
 	var db *mockDb.MockIDbOrderBook
-	config := &config.Config{}
+	config := &configPkg.Config{}
+	config.OrderBook.Depth = 3
+	config.OrderBook.Symbols = []configPkg.SymbolConfig{{Symbol: "VC1", Depth: 1}}
 	var orderBookManager *OrderBookManager
+	var printChan chan queue.Line
 
 	BeforeEach(func() {
 		control = gomock.NewController(GinkgoT())
 		db = mockDb.NewMockIDbOrderBook(control)
-		orderBookManager = NewOrderBookManager(config, make(chan bool), make(<-chan message.Message), make(chan<- string), db)
+		printChan = make(chan queue.Line, 10)
+		orderBookManager = NewOrderBookManager(config, make(<-chan message.Message), printChan, db)
 	})
 
 	Describe("processMessage", func() {
 		Context("valid raw added message", func() {
-			It("should return the correct market depth string", func() {
-				symbol := [3]byte{1, 2, 3}
+			It("should return the changed level", func() {
+				symbol := message.Symbol{1, 2, 3}
 				orderId := uint64(123)
-				price := int32(1)
+				price := int64(1)
 				volume := uint64(1)
 				addMsg := message.MessageAdded{
 					Symbol:  symbol,
@@ -47,14 +53,99 @@ var _ = Describe("OrderBookManager", func() {
 					MsgHeader: header,
 					MsgBody:   addMsg,
 				}
-				fakeDepth := "[(3, 1)], [(4, 2)]"
-				db.EXPECT().AddOrder(addMsg).Return(true, nil)
-				db.EXPECT().PrintDepth(symbol).Return(fakeDepth, nil)
-				expectedDepth := fmt.Sprintf("%d, %s, %s\n", header.Seq, string(symbol[:]), fakeDepth)
+				db.EXPECT().AddOrder(addMsg).Return(2, nil)
 
-				returnedDepth, err := orderBookManager.processMessage(rawMsg)
+				changedLevel, err := orderBookManager.processMessage(rawMsg)
 				Expect(err).To(BeNil())
-				Expect(returnedDepth).To(Equal(expectedDepth))
+				Expect(changedLevel).To(Equal(2))
+			})
+		})
+	})
+
+	Describe("publishDepth", func() {
+		symbol := message.NewSymbol("VC0")
+		rawMsg := message.Message{Symbol: symbol, MsgHeader: message.Header{Seq: 1}}
+
+		Context("with a changed level within the default depth", func() {
+			It("should send the correct market depth string", func() {
+				fakeDepth := "[(3, 1)], [(4, 2)]"
+				db.EXPECT().PrintDepth(symbol, 3).Return(fakeDepth, nil)
+				expectedDepth := fmt.Sprintf("%d, %s, %s\n", rawMsg.MsgHeader.Seq, symbol.String(), fakeDepth)
+
+				Expect(orderBookManager.publishDepth(rawMsg, 2)).To(Succeed())
+				Expect(printChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: expectedDepth})))
+			})
+		})
+
+		Context("with a changed level deeper than the symbol depth", func() {
+			It("should not send anything", func() {
+				vc1Msg := message.Message{Symbol: message.NewSymbol("VC1"), MsgHeader: message.Header{Seq: 1}}
+				Expect(orderBookManager.publishDepth(vc1Msg, 1)).To(Succeed())
+				Expect(printChan).To(BeEmpty())
+			})
+		})
+
+		Context("with a subscriber at a deeper depth", func() {
+			It("should only send to the outputs that print the changed level", func() {
+				deepChan := make(chan queue.Line, 10)
+				orderBookManager.Subscribe(5, deepChan)
+				db.EXPECT().PrintDepth(symbol, 5).Return("deep", nil)
+
+				Expect(orderBookManager.publishDepth(rawMsg, 4)).To(Succeed())
+				Expect(printChan).To(BeEmpty())
+				Expect(deepChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "1, VC0, deep\n"})))
+			})
+		})
+
+		Context("with a grouped subscriber", func() {
+			It("should only send the grouped depth when it is different from the last one", func() {
+				groupedChan := make(chan queue.Line, 10)
+				orderBookManager.SubscribeGrouped(2, 100, groupedChan)
+				buckets := []dbPkg.Bucket{{Price: 300, Volume: 5, Orders: 2}}
+				db.EXPECT().PrintDepth(symbol, 3).Return("exact", nil).Times(2)
+				db.EXPECT().GroupedDepth(symbol, 2, int64(100)).Return(buckets, []dbPkg.Bucket{}, nil).Times(2)
+
+				Expect(orderBookManager.publishDepth(rawMsg, 0)).To(Succeed())
+				Expect(orderBookManager.publishDepth(rawMsg, 0)).To(Succeed())
+				Expect(groupedChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "1, VC0, [(300, 5, 2)], []\n"})))
+				Expect(groupedChan).To(BeEmpty())
+				Expect(printChan).To(HaveLen(2))
+			})
+		})
+
+		Context("after the depth is changed at runtime", func() {
+			It("should print with the new depth", func() {
+				newConfig := &configPkg.Config{}
+				newConfig.OrderBook.Depth = 5
+				orderBookManager.depth = newDepthSettings(newConfig)
+				db.EXPECT().PrintDepth(symbol, 5).Return("deep", nil)
+
+				Expect(orderBookManager.publishDepth(rawMsg, 4)).To(Succeed())
+				Expect(printChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "1, VC0, deep\n"})))
+			})
+		})
+
+		Context("with a callback of the watched symbols", func() {
+			It("should not read the levels of the other symbols", func() {
+				var events []DepthEvent
+				watched := func(s message.Symbol) bool { return s == message.NewSymbol("VC1") }
+				orderBookManager.OnWatchedDepthChanged(5, watched, func(event DepthEvent) { events = append(events, event) })
+				db.EXPECT().PrintDepth(symbol, 3).Return("exact", nil)
+
+				// the mock fails on a Depth call
+				Expect(orderBookManager.publishDepth(rawMsg, 0)).To(Succeed())
+				Expect(events).To(BeEmpty())
+				Expect(printChan).To(HaveLen(1))
+			})
+		})
+
+		Context("without a configured depth", func() {
+			It("should print the default depth", func() {
+				orderBookManager.depth = newDepthSettings(&configPkg.Config{})
+				db.EXPECT().PrintDepth(symbol, configPkg.DEFAULT_DEPTH).Return("default", nil)
+
+				Expect(orderBookManager.publishDepth(rawMsg, 0)).To(Succeed())
+				Expect(printChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "1, VC0, default\n"})))
 			})
 		})
 	})
@@ -0,0 +1,140 @@
+package order_book
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// priceSettings is the scale and tick size of the prices of a symbol
+type priceSettings struct {
+	scale    int   // decimal places of the raw prices
+	tickSize int64 // raw prices must be a multiple of it, 0 means they are not validated
+}
+
+// priceOf returns the price settings of the symbol, they are read from the config once per symbol
+func (o *OrderBookManager) priceOf(symbol message.Symbol) priceSettings {
+	settings, ok := o.prices[symbol]
+	if !ok {
+		settings = priceSettings{
+			scale:    o.config.SymbolScale(symbol.String()),
+			tickSize: o.config.SymbolTickSize(symbol.String()),
+		}
+		o.prices[symbol] = settings
+	}
+	return settings
+}
+
+// Scale returns the decimal places of the raw prices of the symbol
+func (o *OrderBookManager) Scale(symbol message.Symbol) int {
+	return o.priceOf(symbol).scale
+}
+
+// ErrRejected is wrapped by the error of a msg that was not applied because it is invalid, the books are unchanged
+var ErrRejected = errors.New("msg rejected")
+
+// orderKey identifies a resting order
+type orderKey struct {
+	symbol  message.Symbol
+	orderId uint64
+}
+
+// reject returns an error wrapping ErrRejected if the price of the msg is invalid or its order was rejected
+// the order of a rejected added msg is remembered so that its later msg are rejected too instead of failing on the missing order
+// it is forgotten once deleted or fully executed, or when another added msg reuses its OrderId
+func (o *OrderBookManager) reject(msg message.Message) error {
+	key, ok := orderKeyOf(msg)
+	if ok && msg.MsgType == message.MSG_TYPE_ADDED {
+		// a new order reuses the OrderId, the rejected one is over
+		delete(o.rejected, key)
+	}
+	if size, rejected := o.rejected[key]; ok && rejected {
+		switch body := msg.MsgBody.(type) {
+		case message.MessageUpdated:
+			o.rejected[key] = body.Size
+		case message.MessageDeleted:
+			delete(o.rejected, key)
+		case message.MessageExecuted:
+			if body.TradedQty >= size {
+				delete(o.rejected, key)
+			} else {
+				o.rejected[key] = size - body.TradedQty
+			}
+		}
+		return fmt.Errorf("%w: order %d of seq %d was rejected before", ErrRejected, key.orderId, msg.MsgHeader.Seq)
+	}
+	if err := o.validatePrice(msg); err != nil {
+		if body, isAdded := msg.MsgBody.(message.MessageAdded); isAdded {
+			o.rejected[key] = body.Size
+		}
+		return fmt.Errorf("%w: %s", ErrRejected, err.Error())
+	}
+	return nil
+}
+
+// orderKeyOf returns the order of the msg, false if its body is not one of the msg types
+func orderKeyOf(msg message.Message) (orderKey, bool) {
+	switch body := msg.MsgBody.(type) {
+	case message.MessageAdded:
+		return orderKey{msg.Symbol, body.OrderId}, true
+	case message.MessageUpdated:
+		return orderKey{msg.Symbol, body.OrderId}, true
+	case message.MessageDeleted:
+		return orderKey{msg.Symbol, body.OrderId}, true
+	case message.MessageExecuted:
+		return orderKey{msg.Symbol, body.OrderId}, true
+	}
+	return orderKey{}, false
+}
+
+// validatePrice returns an error if the price of an added or updated msg is not on the tick grid of its symbol
+func (o *OrderBookManager) validatePrice(msg message.Message) error {
+	tickSize := o.priceOf(msg.Symbol).tickSize
+	if tickSize <= 0 {
+		return nil
+	}
+	var price int64
+	switch body := msg.MsgBody.(type) {
+	case message.MessageAdded:
+		price = body.Price
+	case message.MessageUpdated:
+		price = body.Price
+	default:
+		return nil
+	}
+	if price%tickSize != 0 {
+		return fmt.Errorf("price %d of seq %d is not a multiple of the %s tick size %d", price, msg.MsgHeader.Seq, msg.Symbol.String(), tickSize)
+	}
+	return nil
+}
+
+// FormatPrice renders the raw price with scale decimal places e.g. 318800 with scale 4 is 31.8800
+func FormatPrice(price int64, scale int) string {
+	if scale <= 0 {
+		return strconv.FormatInt(price, 10)
+	}
+	sign := ""
+	abs := price
+	if abs < 0 {
+		sign, abs = "-", -abs
+	}
+	digits := strconv.FormatInt(abs, 10)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// formatScaled renders a derived price e.g. a mid or vwap with scale decimal places plus extra ones
+// extra -1 prints as few decimals as needed
+func formatScaled(value float64, scale int, extra int) string {
+	value /= math.Pow10(scale)
+	if extra < 0 {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(value, 'f', scale+extra, 64)
+}
@@ -0,0 +1,146 @@
+package order_book
+
+import (
+	"errors"
+
+	configPkg "github.com/albertsundjaja/order_book/config"
+	inmem_db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/queue"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Price", func() {
+	Describe("FormatPrice", func() {
+		It("should place the decimal point by the scale", func() {
+			Expect(FormatPrice(318800, 0)).To(Equal("318800"))
+			Expect(FormatPrice(318800, 4)).To(Equal("31.8800"))
+			Expect(FormatPrice(5, 2)).To(Equal("0.05"))
+			Expect(FormatPrice(-150, 2)).To(Equal("-1.50"))
+		})
+	})
+
+	Describe("Apply", func() {
+		symbol := message.NewSymbol("VC0")
+		var orderBookManager *OrderBookManager
+		var printChan chan queue.Line
+
+		added := func(seq uint32, price int64) message.Message {
+			return message.Message{
+				Symbol:    symbol,
+				MsgType:   message.MSG_TYPE_ADDED,
+				MsgHeader: message.Header{Seq: seq},
+				MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: uint64(seq), Side: [1]byte{message.SIDE_BUY}, Price: price, Size: 1},
+			}
+		}
+
+		BeforeEach(func() {
+			config := &configPkg.Config{}
+			config.OrderBook.Depth = 1
+			config.OrderBook.Symbols = []configPkg.SymbolConfig{{Symbol: "VC0", Scale: 4, TickSize: 100}}
+			printChan = make(chan queue.Line, 10)
+			orderBookManager = NewOrderBookManager(config, nil, printChan, inmem_db.NewOrderBookDb(config))
+		})
+
+		Context("with a price on the tick grid", func() {
+			It("should print the depth with the scale of the symbol", func() {
+				Expect(orderBookManager.Apply(added(1, 318800))).To(Succeed())
+				Expect(printChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "1, VC0, [(31.8800, 1)], []\n"})))
+			})
+		})
+
+		Context("with a price off the tick grid", func() {
+			It("should return an error without applying the msg", func() {
+				err := orderBookManager.Apply(added(1, 318850))
+				Expect(errors.Is(err, ErrRejected)).To(BeTrue())
+				Expect(printChan).To(BeEmpty())
+			})
+
+			It("should also reject the later msg of the rejected order", func() {
+				Expect(orderBookManager.Apply(added(1, 318850))).To(Not(Succeed()))
+				deleted := message.Message{
+					Symbol:    symbol,
+					MsgType:   message.MSG_TYPE_DELETED,
+					MsgHeader: message.Header{Seq: 2},
+					MsgBody:   message.MessageDeleted{Symbol: symbol, OrderId: 1, Side: [1]byte{message.SIDE_BUY}},
+				}
+				Expect(errors.Is(orderBookManager.Apply(deleted), ErrRejected)).To(BeTrue())
+				Expect(orderBookManager.rejected).To(BeEmpty())
+			})
+
+			It("should forget the rejected order once it is fully executed", func() {
+				Expect(orderBookManager.Apply(added(1, 318850))).To(Not(Succeed()))
+				executed := message.Message{
+					Symbol:    symbol,
+					MsgType:   message.MSG_TYPE_EXECUTED,
+					MsgHeader: message.Header{Seq: 2},
+					MsgBody:   message.MessageExecuted{Symbol: symbol, OrderId: 1, Side: [1]byte{message.SIDE_BUY}, TradedQty: 1},
+				}
+				Expect(errors.Is(orderBookManager.Apply(executed), ErrRejected)).To(BeTrue())
+				Expect(orderBookManager.rejected).To(BeEmpty())
+			})
+
+			It("should apply a valid added msg that reuses the OrderId of the rejected order", func() {
+				Expect(orderBookManager.Apply(added(1, 318850))).To(Not(Succeed()))
+				reused := added(2, 318800)
+				body := reused.MsgBody.(message.MessageAdded)
+				body.OrderId = 1
+				reused.MsgBody = body
+				Expect(orderBookManager.Apply(reused)).To(Succeed())
+				Expect(printChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "2, VC0, [(31.8800, 1)], []\n"})))
+				Expect(orderBookManager.rejected).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("ProcessMessage", func() {
+		symbol := message.NewSymbol("VC0")
+		added := func(seq uint32, orderId uint64, price int64) message.Message {
+			return message.Message{
+				Symbol:    symbol,
+				MsgType:   message.MSG_TYPE_ADDED,
+				MsgHeader: message.Header{Seq: seq},
+				MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: orderId, Side: [1]byte{message.SIDE_BUY}, Price: price, Size: 1},
+			}
+		}
+
+		It("should skip a rejected msg and keep processing", func() {
+			config := &configPkg.Config{}
+			config.OrderBook.Depth = 1
+			config.OrderBook.TickSize = 100
+			streamChan := make(chan message.Message, 3)
+			printChan := make(chan queue.Line, 10)
+			orderBookManager := NewOrderBookManager(config, streamChan, printChan, inmem_db.NewOrderBookDb(config))
+			streamChan <- added(1, 1, 318850)
+			streamChan <- added(2, 2, 318800)
+			close(streamChan)
+			Expect(orderBookManager.ProcessMessage()).To(Succeed())
+			Expect(printChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "2, VC0, [(318800, 1)], []\n"})))
+		})
+
+		It("should read the scale and tick size again after the config is reloaded", func() {
+			config := &configPkg.Config{}
+			config.OrderBook.Depth = 1
+			config.OrderBook.TickSize = 100
+			streamChan := make(chan message.Message)
+			printChan := make(chan queue.Line, 10)
+			orderBookManager := NewOrderBookManager(config, streamChan, printChan, inmem_db.NewOrderBookDb(config))
+			processed := make(chan error, 1)
+			go func() {
+				processed <- orderBookManager.ProcessMessage()
+			}()
+			streamChan <- added(1, 1, 318800)
+			Eventually(printChan).Should(Receive(Equal(queue.Line{Symbol: symbol, Text: "1, VC0, [(318800, 1)], []\n"})))
+
+			reloaded := &configPkg.Config{}
+			reloaded.OrderBook.Depth = 1
+			reloaded.OrderBook.Scale = 2
+			orderBookManager.SetDepth(reloaded)
+			streamChan <- added(2, 2, 318850)
+			close(streamChan)
+			Eventually(processed).Should(Receive(BeNil()))
+			Expect(printChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "2, VC0, [(3188.50, 1)], []\n"})))
+		})
+	})
+})
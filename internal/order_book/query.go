@@ -0,0 +1,71 @@
+package order_book
+
+import (
+	"errors"
+
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// Snapshot is the state of every book after the same msg, it must not be used after the Query callback returned
+type Snapshot struct {
+	Db      db.IDbOrderBook // the books, only the read methods may be called
+	Seq     uint32          // seq of the last applied msg, 0 before the first one
+	manager *OrderBookManager
+}
+
+// ErrNotProcessing is returned by Query when ProcessMessage will not be called
+var ErrNotProcessing = errors.New("the order books are not processing the stream")
+
+// Query runs fn with a Snapshot of the books between two msg, so that every book fn reads is at the same seq
+// while ProcessMessage is running fn runs on the processing routine and blocks the stream, once it returned fn runs on the calling routine.
+// Query waits until ProcessMessage is started, fn is not run and ErrNotProcessing is returned once Stop was called
+func (o *OrderBookManager) Query(fn func(Snapshot)) error {
+	ran := make(chan struct{})
+	query := func() {
+		defer close(ran)
+		fn(o.snapshot())
+	}
+	select {
+	case o.queryChan <- query:
+		<-ran
+	case <-o.done:
+		// the books do not change anymore
+		fn(o.snapshot())
+	case <-o.stopped:
+		return ErrNotProcessing
+	}
+	return nil
+}
+
+// Stop releases the callers of Query and SetDepth waiting for ProcessMessage when it will not be called, e.g. the pipeline failed to start
+// it must not be called while ProcessMessage is running
+func (o *OrderBookManager) Stop() {
+	o.stopOnce.Do(func() {
+		close(o.stopped)
+	})
+}
+
+func (o *OrderBookManager) snapshot() Snapshot {
+	return Snapshot{Db: o.db, Seq: o.lastSeq, manager: o}
+}
+
+// TradeStats returns the last sale and the cumulative volume of the symbol, false if it never traded
+func (s Snapshot) TradeStats(symbol message.Symbol) (TradeStats, bool) {
+	stats, ok := s.manager.tradeStats[symbol]
+	return stats, ok
+}
+
+// Scale returns the decimal places of the raw prices of the symbol
+func (s Snapshot) Scale(symbol message.Symbol) int {
+	// read from the config, the cached settings are written by the processing routine
+	return s.manager.config.SymbolScale(symbol.String())
+}
+
+// Depth returns the depth printed for the symbol
+func (s Snapshot) Depth(symbol message.Symbol) int {
+	if depth, ok := s.manager.depth.symbolDepth[symbol]; ok {
+		return depth
+	}
+	return s.manager.depth.defaultDepth
+}
@@ -0,0 +1,85 @@
+package order_book
+
+import (
+	configPkg "github.com/albertsundjaja/order_book/config"
+	inmem_db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Query", func() {
+	symbol := message.NewSymbol("VC0")
+	var streamChan chan message.Message
+	var orderBookManager *OrderBookManager
+
+	added := func(seq uint32, orderId uint64) message.Message {
+		return message.Message{
+			Symbol:    symbol,
+			MsgType:   message.MSG_TYPE_ADDED,
+			MsgHeader: message.Header{Seq: seq},
+			MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: orderId, Side: [1]byte{message.SIDE_BUY}, Price: 100, Size: 10},
+		}
+	}
+
+	BeforeEach(func() {
+		config := &configPkg.Config{}
+		config.OrderBook.Depth = 2
+		config.OrderBook.Symbols = []configPkg.SymbolConfig{{Symbol: "VC0", Depth: 1, Scale: 2}}
+		streamChan = make(chan message.Message)
+		orderBookManager = NewOrderBookManager(config, streamChan, nil, inmem_db.NewOrderBookDb(config))
+	})
+
+	Context("while the stream is processed", func() {
+		It("should read the books between two msg", func() {
+			processed := make(chan error, 1)
+			go func() {
+				processed <- orderBookManager.ProcessMessage()
+			}()
+			streamChan <- added(1, 1)
+			streamChan <- added(2, 2)
+
+			var seq uint32
+			var volume uint64
+			orderBookManager.Query(func(snapshot Snapshot) {
+				seq = snapshot.Seq
+				stats, err := snapshot.Db.Stats(symbol)
+				Expect(err).To(BeNil())
+				volume = stats.BuyVolume
+				Expect(snapshot.Depth(symbol)).To(Equal(1))
+				Expect(snapshot.Scale(symbol)).To(Equal(2))
+			})
+			Expect(seq).To(Equal(uint32(2)))
+			Expect(volume).To(Equal(uint64(20)))
+
+			close(streamChan)
+			Eventually(processed).Should(Receive(BeNil()))
+		})
+	})
+
+	Context("when ProcessMessage will not be called", func() {
+		It("should return ErrNotProcessing without running fn", func() {
+			orderBookManager.Stop()
+			ran := false
+			Expect(orderBookManager.Query(func(Snapshot) {
+				ran = true
+			})).To(Equal(ErrNotProcessing))
+			Expect(ran).To(BeFalse())
+		})
+	})
+
+	Context("after the stream ended", func() {
+		It("should run on the calling routine", func() {
+			Expect(orderBookManager.Apply(added(7, 1))).To(Succeed())
+			close(streamChan)
+			Expect(orderBookManager.ProcessMessage()).To(Succeed())
+
+			var seq uint32
+			orderBookManager.Query(func(snapshot Snapshot) {
+				seq = snapshot.Seq
+				Expect(snapshot.Db.Symbols()).To(Equal([]message.Symbol{symbol}))
+			})
+			Expect(seq).To(Equal(uint32(7)))
+		})
+	})
+})
@@ -0,0 +1,75 @@
+package order_book
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/albertsundjaja/order_book/internal/queue"
+)
+
+// Imbalance returns (buy volume - sell volume) / (buy volume + sell volume) over the levels of the event, false if both sides are empty
+// it is between -1 when there are only sell orders and 1 when there are only buy orders
+func (e DepthEvent) Imbalance() (float64, bool) {
+	var buy, sell float64
+	for _, level := range e.Buy {
+		buy += float64(level.Volume)
+	}
+	for _, level := range e.Sell {
+		sell += float64(level.Volume)
+	}
+	if buy+sell == 0 {
+		return 0, false
+	}
+	return (buy - sell) / (buy + sell), true
+}
+
+// Microprice returns the mid of the best prices weighted by the volume of the opposite side, false if either side is empty
+// it moves towards the best sell price when the best buy volume is larger and the other way around
+func (e DepthEvent) Microprice() (float64, bool) {
+	if len(e.Buy) == 0 || len(e.Sell) == 0 {
+		return 0, false
+	}
+	buy, sell := e.Buy[0], e.Sell[0]
+	buyVolume, sellVolume := float64(buy.Volume), float64(sell.Volume)
+	return (float64(buy.Price)*sellVolume + float64(sell.Price)*buyVolume) / (buyVolume + sellVolume), true
+}
+
+// SpreadTicks returns the best sell price minus the best buy price in ticks, false if either side is empty
+func (e DepthEvent) SpreadTicks(tickSize int64) (float64, bool) {
+	if len(e.Buy) == 0 || len(e.Sell) == 0 {
+		return 0, false
+	}
+	return float64(e.Sell[0].Price-e.Buy[0].Price) / float64(tickSize), true
+}
+
+// SubscribeSignals adds an output that receives the imbalance, microprice and spread in ticks of a symbol whenever its levels
+// within config.Signals.Depth change, which includes every top of book change
+// It must be called before ProcessMessage is started, out is closed when ProcessMessage returns
+// the spread is in the tick size of the symbol, or config.Signals.TickSize for the symbols without one
+func (o *OrderBookManager) SubscribeSignals(out chan<- queue.Line) {
+	defaultTickSize := o.config.SignalTickSize()
+	o.OnDepthChanged(o.config.Signals.Depth, func(event DepthEvent) {
+		tickSize := o.priceOf(event.Symbol).tickSize
+		if tickSize <= 0 {
+			tickSize = defaultTickSize
+		}
+		out <- queue.Line{Symbol: event.Symbol, Text: printSignals(event, tickSize)}
+	})
+	o.outputs = append(o.outputs, out)
+}
+
+// printSignals returns the line printed for the signals of the depth, undefined signals are printed as -
+// e.g. 4, VC0, 0.8582, 318814.0861, 1 for seq, symbol, imbalance, microprice and spread in ticks
+func printSignals(event DepthEvent, tickSize int64) string {
+	imbalance, microprice, spread := "-", "-", "-"
+	if value, ok := event.Imbalance(); ok {
+		imbalance = strconv.FormatFloat(value, 'f', 4, 64)
+	}
+	if value, ok := event.Microprice(); ok {
+		microprice = formatScaled(value, event.Scale, 4)
+	}
+	if value, ok := event.SpreadTicks(tickSize); ok {
+		spread = strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%d, %s, %s, %s, %s\n", event.Seq, event.Symbol.String(), imbalance, microprice, spread)
+}
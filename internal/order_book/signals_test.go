@@ -0,0 +1,72 @@
+package order_book
+
+import (
+	configPkg "github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db"
+	inmem_db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/queue"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Signals", func() {
+	symbol := message.NewSymbol("VC0")
+
+	Context("with both sides", func() {
+		event := DepthEvent{
+			Seq:    4,
+			Symbol: symbol,
+			Buy:    []db.Level{{Price: 300, Volume: 30}, {Price: 200, Volume: 30}},
+			Sell:   []db.Level{{Price: 500, Volume: 20}},
+		}
+
+		It("should weight the imbalance over every level and the microprice over the best level", func() {
+			imbalance, ok := event.Imbalance()
+			Expect(ok).To(BeTrue())
+			Expect(imbalance).To(BeNumerically("~", 0.5))
+			microprice, ok := event.Microprice()
+			Expect(ok).To(BeTrue())
+			Expect(microprice).To(BeNumerically("~", 420))
+			Expect(printSignals(event, 100)).To(Equal("4, VC0, 0.5000, 420.0000, 2\n"))
+		})
+	})
+
+	Context("with an empty sell side", func() {
+		It("should only print the imbalance", func() {
+			event := DepthEvent{Seq: 1, Symbol: symbol, Buy: []db.Level{{Price: 300, Volume: 30}}, Sell: []db.Level{}}
+			Expect(printSignals(event, 1)).To(Equal("1, VC0, 1.0000, -, -\n"))
+		})
+	})
+
+	Describe("SubscribeSignals", func() {
+		It("should send the signals of every change and close the output once the stream is processed", func() {
+			config := &configPkg.Config{}
+			config.OrderBook.Depth = 1
+			config.Signals.Depth = 2
+			streamChan := make(chan message.Message, 2)
+			signalChan := make(chan queue.Line, 10)
+			orderBookManager := NewOrderBookManager(config, streamChan, nil, inmem_db.NewOrderBookDb(config))
+			orderBookManager.SubscribeSignals(signalChan)
+			for i, side := range []byte{message.SIDE_BUY, message.SIDE_SELL} {
+				streamChan <- message.Message{
+					Symbol:    symbol,
+					MsgType:   message.MSG_TYPE_ADDED,
+					MsgHeader: message.Header{Seq: uint32(i + 1)},
+					MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: uint64(i + 1), Side: [1]byte{side}, Price: int64(300 + 200*i), Size: 30},
+				}
+			}
+			close(streamChan)
+			Expect(orderBookManager.ProcessMessage()).To(Succeed())
+
+			var lines []queue.Line
+			for line := range signalChan {
+				lines = append(lines, line)
+			}
+			Expect(lines).To(Equal([]queue.Line{
+				{Symbol: symbol, Text: "1, VC0, 1.0000, -, -\n"},
+				{Symbol: symbol, Text: "2, VC0, 0.0000, 400.0000, 200\n"},
+			}))
+		})
+	})
+})
@@ -0,0 +1,99 @@
+package order_book
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/queue"
+)
+
+// TradeEvent is a trade derived from an execution, the feed only reports the resting order so the price is its resting price
+// and the aggressor is the opposite side of the resting order
+type TradeEvent struct {
+	Seq       uint32
+	Symbol    message.Symbol
+	OrderId   uint64 // resting order
+	Price     int64
+	Qty       uint64
+	Aggressor byte      // message.SIDE_BUY or message.SIDE_SELL
+	Scale     int       // decimal places of the prices of the symbol
+	Timestamp time.Time // capture time of the msg, zero when not read from a capture
+	TradeStats
+}
+
+// TradeStats is the last sale and the cumulative volume of a symbol
+type TradeStats struct {
+	LastPrice int64
+	Volume    uint64 // cumulative traded qty
+	Count     uint64 // number of trades
+}
+
+// tradeWriter sends every trade to out as a printed line
+type tradeWriter struct {
+	NopListener
+	out chan<- queue.Line
+}
+
+func (t tradeWriter) OnTrade(event TradeEvent) {
+	t.out <- queue.Line{Symbol: event.Symbol, Text: printTrade(event)}
+}
+
+// SubscribeTrades adds an output that receives a line for every trade
+// It must be called before ProcessMessage is started, out is closed when ProcessMessage returns
+func (o *OrderBookManager) SubscribeTrades(out chan<- queue.Line) {
+	o.listeners = append(o.listeners, tradeWriter{out: out})
+	o.outputs = append(o.outputs, out)
+}
+
+// TradeStats returns the last sale and the cumulative volume of the symbol, false if it never traded
+// it must not be called while ProcessMessage is running
+func (o *OrderBookManager) TradeStats(symbol message.Symbol) (TradeStats, bool) {
+	stats, ok := o.tradeStats[symbol]
+	return stats, ok
+}
+
+// trade returns the trade of an executed msg, it must be called before the msg is applied as a full execution removes the resting order
+func (o *OrderBookManager) trade(msg message.Message) (TradeEvent, error) {
+	exMsg, ok := msg.MsgBody.(message.MessageExecuted)
+	if !ok {
+		return TradeEvent{}, bodyMismatch(msg)
+	}
+	price, _, err := o.db.Order(msg.Symbol, exMsg.Side[0], exMsg.OrderId)
+	if err != nil {
+		return TradeEvent{}, fmt.Errorf("unable to find the resting order of the execution: %w", err)
+	}
+	aggressor := byte(message.SIDE_BUY)
+	if exMsg.Side[0] == message.SIDE_BUY {
+		aggressor = message.SIDE_SELL
+	}
+	return TradeEvent{
+		Seq:       msg.MsgHeader.Seq,
+		Symbol:    msg.Symbol,
+		OrderId:   exMsg.OrderId,
+		Price:     price,
+		Qty:       exMsg.TradedQty,
+		Aggressor: aggressor,
+		Scale:     o.priceOf(msg.Symbol).scale,
+		Timestamp: msg.Timestamp,
+	}, nil
+}
+
+// notifyTrade updates the trade stats of the symbol and sends the trade to the listeners
+func (o *OrderBookManager) notifyTrade(trade TradeEvent) {
+	stats := o.tradeStats[trade.Symbol]
+	stats.LastPrice = trade.Price
+	stats.Volume += trade.Qty
+	stats.Count++
+	o.tradeStats[trade.Symbol] = stats
+	trade.TradeStats = stats
+	for _, listener := range o.listeners {
+		listener.OnTrade(trade)
+	}
+}
+
+// printTrade returns the line printed for the trade
+// e.g. 12, VC0, 318800, 100, S, 2300, 7 for seq, symbol, price, qty, aggressor, cumulative volume and trade count
+func printTrade(trade TradeEvent) string {
+	return fmt.Sprintf("%d, %s, %s, %d, %c, %d, %d\n", trade.Seq, trade.Symbol.String(), FormatPrice(trade.Price, trade.Scale), trade.Qty, trade.Aggressor, trade.Volume, trade.Count)
+}
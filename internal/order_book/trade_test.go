@@ -0,0 +1,63 @@
+package order_book
+
+import (
+	configPkg "github.com/albertsundjaja/order_book/config"
+	inmem_db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/queue"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Trades", func() {
+	symbol := message.NewSymbol("VC0")
+	var orderBookManager *OrderBookManager
+	var tradeChan chan queue.Line
+
+	executed := func(seq uint32, orderId uint64, side byte, qty uint64) message.Message {
+		return message.Message{
+			Symbol:    symbol,
+			MsgType:   message.MSG_TYPE_EXECUTED,
+			MsgHeader: message.Header{Seq: seq},
+			MsgBody:   message.MessageExecuted{Symbol: symbol, OrderId: orderId, Side: [1]byte{side}, TradedQty: qty},
+		}
+	}
+
+	BeforeEach(func() {
+		config := &configPkg.Config{}
+		config.OrderBook.Depth = 1
+		orderBookManager = NewOrderBookManager(config, nil, nil, inmem_db.NewOrderBookDb(config))
+		tradeChan = make(chan queue.Line, 10)
+		orderBookManager.SubscribeTrades(tradeChan)
+		for i, side := range []byte{message.SIDE_BUY, message.SIDE_SELL} {
+			Expect(orderBookManager.Apply(message.Message{
+				Symbol:    symbol,
+				MsgType:   message.MSG_TYPE_ADDED,
+				MsgHeader: message.Header{Seq: uint32(i + 1)},
+				MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: uint64(i + 1), Side: [1]byte{side}, Price: int64(100 + i), Size: 10},
+			})).To(Succeed())
+		}
+	})
+
+	Context("with executions on both sides", func() {
+		It("should print the resting price and the opposite side as aggressor", func() {
+			Expect(orderBookManager.Apply(executed(3, 1, message.SIDE_BUY, 4))).To(Succeed())
+			Expect(orderBookManager.Apply(executed(4, 2, message.SIDE_SELL, 10))).To(Succeed())
+
+			Expect(tradeChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "3, VC0, 100, 4, S, 4, 1\n"})))
+			Expect(tradeChan).To(Receive(Equal(queue.Line{Symbol: symbol, Text: "4, VC0, 101, 10, B, 14, 2\n"})))
+			stats, ok := orderBookManager.TradeStats(symbol)
+			Expect(ok).To(BeTrue())
+			Expect(stats).To(Equal(TradeStats{LastPrice: 101, Volume: 14, Count: 2}))
+		})
+	})
+
+	Context("with an execution of an unknown order", func() {
+		It("should return an error without a trade", func() {
+			Expect(orderBookManager.Apply(executed(3, 5, message.SIDE_BUY, 4))).To(Not(Succeed()))
+			Expect(tradeChan).To(Not(Receive()))
+			_, ok := orderBookManager.TradeStats(symbol)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,149 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+const (
+	etherTypeIPv4   = 0x0800
+	etherTypeIPv6   = 0x86dd
+	etherTypeVlan   = 0x8100
+	etherTypeQinQ   = 0x88a8
+	protocolUdp     = 17
+	udpHeaderLength = 8
+)
+
+// Destination is the address the packets of the feed are sent to, an invalid Addr matches every address
+type Destination struct {
+	Addr netip.Addr
+	Port uint16
+}
+
+// ParseDestination parses host:port, the host can be left out e.g. :26400 to match the port on every address
+func ParseDestination(address string) (Destination, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return Destination{}, err
+	}
+	number, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return Destination{}, fmt.Errorf("invalid port %q", port)
+	}
+	destination := Destination{Port: uint16(number)}
+	if host != "" {
+		if destination.Addr, err = netip.ParseAddr(host); err != nil {
+			return Destination{}, err
+		}
+	}
+	return destination, nil
+}
+
+// Match reports whether the packet was sent to the destination
+func (d Destination) Match(packet Packet) bool {
+	if packet.Destination.Port() != d.Port {
+		return false
+	}
+	return !d.Addr.IsValid() || packet.Destination.Addr().Unmap() == d.Addr.Unmap()
+}
+
+// parseFrame returns the UDP packet carried by the frame, ok is false when it does not carry one
+func parseFrame(linkType uint16, frame []byte) (packet Packet, ok bool) {
+	switch linkType {
+	case LINK_TYPE_NULL:
+		if len(frame) < 4 {
+			return Packet{}, false
+		}
+		return parseIP(frame[4:])
+	case LINK_TYPE_ETHERNET:
+		if len(frame) < 14 {
+			return Packet{}, false
+		}
+		etherType, payload := binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		for (etherType == etherTypeVlan || etherType == etherTypeQinQ) && len(payload) >= 4 {
+			etherType, payload = binary.BigEndian.Uint16(payload[2:]), payload[4:]
+		}
+		if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+			return Packet{}, false
+		}
+		return parseIP(payload)
+	case LINK_TYPE_LINUX_SLL:
+		if len(frame) < 16 {
+			return Packet{}, false
+		}
+		return parseIP(frame[16:])
+	case LINK_TYPE_RAW, LINK_TYPE_IPV4, LINK_TYPE_IPV6:
+		return parseIP(frame)
+	}
+	return Packet{}, false
+}
+
+// parseIP returns the UDP packet of an IPv4 or IPv6 packet, fragmented datagrams are not reassembled and are skipped
+func parseIP(raw []byte) (Packet, bool) {
+	if len(raw) < 1 {
+		return Packet{}, false
+	}
+	var source, destination netip.Addr
+	var payload []byte
+	switch raw[0] >> 4 {
+	case 4:
+		headerLength := int(raw[0]&0x0f) * 4
+		if headerLength < 20 || len(raw) < headerLength || raw[9] != protocolUdp {
+			return Packet{}, false
+		}
+		// more fragments flag or a fragment offset
+		if binary.BigEndian.Uint16(raw[6:])&0x3fff != 0 {
+			return Packet{}, false
+		}
+		totalLength := int(binary.BigEndian.Uint16(raw[2:]))
+		if totalLength < headerLength || totalLength > len(raw) {
+			totalLength = len(raw)
+		}
+		source = netip.AddrFrom4(*(*[4]byte)(raw[12:16]))
+		destination = netip.AddrFrom4(*(*[4]byte)(raw[16:20]))
+		payload = raw[headerLength:totalLength]
+	case 6:
+		if len(raw) < 40 {
+			return Packet{}, false
+		}
+		next := raw[6]
+		end := 40 + int(binary.BigEndian.Uint16(raw[4:]))
+		if end > len(raw) {
+			end = len(raw)
+		}
+		source = netip.AddrFrom16(*(*[16]byte)(raw[8:24]))
+		destination = netip.AddrFrom16(*(*[16]byte)(raw[24:40]))
+		payload = raw[40:end]
+		// hop by hop, routing and destination options extension headers
+		for next == 0 || next == 43 || next == 60 {
+			if len(payload) < 8 {
+				return Packet{}, false
+			}
+			length := (int(payload[1]) + 1) * 8
+			if len(payload) < length {
+				return Packet{}, false
+			}
+			next, payload = payload[0], payload[length:]
+		}
+		if next != protocolUdp {
+			return Packet{}, false
+		}
+	default:
+		return Packet{}, false
+	}
+	if len(payload) < udpHeaderLength {
+		return Packet{}, false
+	}
+	length := int(binary.BigEndian.Uint16(payload[4:]))
+	if length < udpHeaderLength || length > len(payload) {
+		length = len(payload)
+	}
+	return Packet{
+		Source:      netip.AddrPortFrom(source, binary.BigEndian.Uint16(payload)),
+		Destination: netip.AddrPortFrom(destination, binary.BigEndian.Uint16(payload[2:])),
+		Payload:     payload[udpHeaderLength:length],
+	}, true
+}
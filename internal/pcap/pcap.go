@@ -0,0 +1,241 @@
+// Package pcap reads the UDP payloads out of pcap and pcapng capture files
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// magic numbers of the capture formats, as read in little endian
+const (
+	MAGIC_MICROS        = 0xa1b2c3d4 // pcap with microsecond timestamps
+	MAGIC_NANOS         = 0xa1b23c4d // pcap with nanosecond timestamps
+	MAGIC_PCAPNG        = 0x0a0d0d0a // block type of the pcapng section header
+	MAGIC_PCAPNG_ORDER  = 0x1a2b3c4d // byte order magic of the pcapng section header
+	pcapHeaderLength    = 24
+	pcapRecordLength    = 16
+	pcapngBlockLength   = 12 // block type, block length and the trailing block length
+	maxCaptureLength    = 1 << 24
+	defaultTsResolution = time.Microsecond
+)
+
+// pcapng block types
+const (
+	blockInterface      = 1
+	blockSimplePacket   = 3
+	blockEnhancedPacket = 6
+	optionTsResolution  = 9 // if_tsresol option of the interface description
+)
+
+// link types of the captured frames
+const (
+	LINK_TYPE_NULL      = 0
+	LINK_TYPE_ETHERNET  = 1
+	LINK_TYPE_RAW       = 101
+	LINK_TYPE_LINUX_SLL = 113
+	LINK_TYPE_IPV4      = 228
+	LINK_TYPE_IPV6      = 229
+)
+
+// Packet is the UDP payload of a captured frame
+type Packet struct {
+	Timestamp   time.Time      // capture time, zero for pcapng simple packets
+	Source      netip.AddrPort // sender of the datagram
+	Destination netip.AddrPort // where the datagram was sent to
+	Payload     []byte
+}
+
+// iface is a pcapng interface, pcap files have a single one
+type iface struct {
+	linkType     uint16
+	tsResolution time.Duration
+}
+
+// Reader reads the UDP packets of a pcap or pcapng capture in capture order
+type Reader struct {
+	reader     *bufio.Reader
+	pcapng     bool
+	order      binary.ByteOrder
+	interfaces []iface
+}
+
+// NewReader returns a Reader of the capture in r, the format is detected from its magic number
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{reader: bufio.NewReader(r)}
+	magic, err := reader.reader.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the capture magic number: %w", err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(magic) == MAGIC_PCAPNG:
+		reader.pcapng = true
+		return reader, nil
+	case binary.LittleEndian.Uint32(magic) == MAGIC_MICROS, binary.LittleEndian.Uint32(magic) == MAGIC_NANOS:
+		reader.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(magic) == MAGIC_MICROS, binary.BigEndian.Uint32(magic) == MAGIC_NANOS:
+		reader.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unrecognized capture magic number %x", magic)
+	}
+	header := make([]byte, pcapHeaderLength)
+	if _, err = io.ReadFull(reader.reader, header); err != nil {
+		return nil, fmt.Errorf("unable to read the pcap header: %w", err)
+	}
+	resolution := time.Microsecond
+	if reader.order.Uint32(header) == MAGIC_NANOS {
+		resolution = time.Nanosecond
+	}
+	reader.interfaces = []iface{{linkType: uint16(reader.order.Uint32(header[20:])), tsResolution: resolution}}
+	return reader, nil
+}
+
+// Next returns the next UDP packet, frames that are not UDP over IPv4 or IPv6 are skipped
+// returns io.EOF at the end of the capture
+func (r *Reader) Next() (Packet, error) {
+	for {
+		frame, linkType, timestamp, err := r.nextFrame()
+		if err != nil {
+			return Packet{}, err
+		}
+		packet, ok := parseFrame(linkType, frame)
+		if !ok {
+			continue
+		}
+		packet.Timestamp = timestamp
+		return packet, nil
+	}
+}
+
+// nextFrame returns the next captured frame with the link type of its interface
+func (r *Reader) nextFrame() ([]byte, uint16, time.Time, error) {
+	if !r.pcapng {
+		record := make([]byte, pcapRecordLength)
+		if _, err := io.ReadFull(r.reader, record); err != nil {
+			return nil, 0, time.Time{}, err
+		}
+		length := r.order.Uint32(record[8:])
+		if length > maxCaptureLength {
+			return nil, 0, time.Time{}, fmt.Errorf("pcap record of %d bytes is too large", length)
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(r.reader, frame); err != nil {
+			return nil, 0, time.Time{}, unexpected(err)
+		}
+		iface := r.interfaces[0]
+		timestamp := time.Unix(int64(r.order.Uint32(record)), int64(r.order.Uint32(record[4:]))*int64(iface.tsResolution))
+		return frame, iface.linkType, timestamp, nil
+	}
+	for {
+		blockType, body, err := r.nextBlock()
+		if err != nil {
+			return nil, 0, time.Time{}, err
+		}
+		switch blockType {
+		case blockInterface:
+			if len(body) < 8 {
+				return nil, 0, time.Time{}, fmt.Errorf("pcapng interface description of %d bytes is too short", len(body))
+			}
+			r.interfaces = append(r.interfaces, iface{linkType: r.order.Uint16(body), tsResolution: r.tsResolution(body[8:])})
+		case blockEnhancedPacket:
+			if len(body) < 20 {
+				return nil, 0, time.Time{}, fmt.Errorf("pcapng enhanced packet of %d bytes is too short", len(body))
+			}
+			id := r.order.Uint32(body)
+			length := r.order.Uint32(body[12:])
+			if int(id) >= len(r.interfaces) || uint64(length) > uint64(len(body)-20) {
+				return nil, 0, time.Time{}, fmt.Errorf("invalid pcapng enhanced packet of interface %d", id)
+			}
+			iface := r.interfaces[id]
+			ticks := uint64(r.order.Uint32(body[4:]))<<32 | uint64(r.order.Uint32(body[8:]))
+			timestamp := time.Unix(0, 0).Add(time.Duration(ticks) * iface.tsResolution)
+			return body[20 : 20+length], iface.linkType, timestamp, nil
+		case blockSimplePacket:
+			if len(body) < 4 || len(r.interfaces) == 0 {
+				return nil, 0, time.Time{}, fmt.Errorf("invalid pcapng simple packet")
+			}
+			length := r.order.Uint32(body)
+			if uint64(length) > uint64(len(body)-4) {
+				length = uint32(len(body) - 4)
+			}
+			return body[4 : 4+length], r.interfaces[0].linkType, time.Time{}, nil
+		}
+	}
+}
+
+// nextBlock returns the type and body of the next pcapng block, a section header resets the byte order and the interfaces
+func (r *Reader) nextBlock() (uint32, []byte, error) {
+	head, err := r.reader.Peek(12)
+	if err != nil {
+		if err == io.EOF && len(head) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	if binary.LittleEndian.Uint32(head) == MAGIC_PCAPNG {
+		switch {
+		case binary.LittleEndian.Uint32(head[8:]) == MAGIC_PCAPNG_ORDER:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(head[8:]) == MAGIC_PCAPNG_ORDER:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("invalid pcapng byte order magic %x", head[8:12])
+		}
+		r.interfaces = nil
+	}
+	if r.order == nil {
+		return 0, nil, fmt.Errorf("pcapng capture does not start with a section header")
+	}
+	blockType := r.order.Uint32(head)
+	length := r.order.Uint32(head[4:])
+	if length < pcapngBlockLength || length%4 != 0 || length > maxCaptureLength {
+		return 0, nil, fmt.Errorf("invalid pcapng block length %d", length)
+	}
+	block := make([]byte, length)
+	if _, err = io.ReadFull(r.reader, block); err != nil {
+		return 0, nil, unexpected(err)
+	}
+	return blockType, block[8 : length-4], nil
+}
+
+// tsResolution returns the timestamp resolution from the options of an interface description
+func (r *Reader) tsResolution(options []byte) time.Duration {
+	for len(options) >= 4 {
+		code := r.order.Uint16(options)
+		length := int(r.order.Uint16(options[2:]))
+		if len(options) < 4+length {
+			break
+		}
+		if code == optionTsResolution && length >= 1 {
+			value := options[4]
+			resolution := time.Second
+			if value&0x80 != 0 {
+				// a negative power of 2
+				resolution = time.Duration(float64(time.Second) / float64(uint64(1)<<(value&0x7f)))
+			} else {
+				for i := byte(0); i < value; i++ {
+					resolution /= 10
+				}
+			}
+			if resolution <= 0 {
+				resolution = time.Nanosecond
+			}
+			return resolution
+		}
+		if code == 0 {
+			break
+		}
+		options = options[4+(length+3)/4*4:]
+	}
+	return defaultTsResolution
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,13 @@
+package pcap_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPcap(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Pcap Suite")
+}
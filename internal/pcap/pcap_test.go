@@ -0,0 +1,181 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// byteOrder both reads and appends the numbers of a pcapng section
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+var _ = Describe("Reader", func() {
+	feed := netip.MustParseAddrPort("233.54.12.111:26400")
+	other := netip.MustParseAddrPort("233.54.12.112:26400")
+	source := netip.MustParseAddrPort("10.0.0.1:5000")
+	start := time.Unix(1700000000, 123456789)
+
+	// readAll returns every packet of the capture
+	readAll := func(raw []byte) ([]Packet, error) {
+		reader, err := NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		var packets []Packet
+		for {
+			packet, err := reader.Next()
+			if err == io.EOF {
+				return packets, nil
+			}
+			if err != nil {
+				return packets, err
+			}
+			packets = append(packets, packet)
+		}
+	}
+
+	Context("with a pcap capture", func() {
+		var capture bytes.Buffer
+
+		BeforeEach(func() {
+			capture.Reset()
+			writer, err := NewWriter(&capture)
+			Expect(err).To(BeNil())
+			Expect(writer.WritePacket(Packet{Timestamp: start, Source: source, Destination: feed, Payload: []byte("first")})).To(Succeed())
+			Expect(writer.WritePacket(Packet{Timestamp: start.Add(time.Millisecond), Source: source, Destination: other, Payload: []byte("other")})).To(Succeed())
+			Expect(writer.WritePacket(Packet{Timestamp: start.Add(2 * time.Millisecond), Source: source, Destination: feed, Payload: []byte("second")})).To(Succeed())
+		})
+
+		It("should read the packets in capture order with their timestamps", func() {
+			packets, err := readAll(capture.Bytes())
+			Expect(err).To(BeNil())
+			Expect(packets).To(HaveLen(3))
+			Expect(packets[0].Timestamp.Equal(start)).To(BeTrue())
+			Expect(packets[0].Source).To(Equal(source))
+			Expect(packets[0].Destination).To(Equal(feed))
+			Expect(string(packets[0].Payload)).To(Equal("first"))
+			Expect(packets[2].Timestamp.Sub(start)).To(Equal(2 * time.Millisecond))
+		})
+
+		It("should match the packets sent to the destination", func() {
+			packets, _ := readAll(capture.Bytes())
+			destination, err := ParseDestination("233.54.12.111:26400")
+			Expect(err).To(BeNil())
+			anyAddr, err := ParseDestination(":26400")
+			Expect(err).To(BeNil())
+			var matched, matchedAny []string
+			for _, packet := range packets {
+				if destination.Match(packet) {
+					matched = append(matched, string(packet.Payload))
+				}
+				if anyAddr.Match(packet) {
+					matchedAny = append(matchedAny, string(packet.Payload))
+				}
+			}
+			Expect(matched).To(Equal([]string{"first", "second"}))
+			Expect(matchedAny).To(Equal([]string{"first", "other", "second"}))
+		})
+
+		It("should return an error when a record is truncated", func() {
+			_, err := readAll(capture.Bytes()[:capture.Len()-1])
+			Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		})
+	})
+
+	Context("with frames that do not carry UDP", func() {
+		It("should skip them and read VLAN tagged frames", func() {
+			var capture bytes.Buffer
+			writer, _ := NewWriter(&capture)
+			Expect(writer.WritePacket(Packet{Timestamp: start, Source: source, Destination: feed, Payload: []byte("udp")})).To(Succeed())
+			raw := capture.Bytes()
+			udpFrame := append([]byte(nil), raw[pcapHeaderLength+pcapRecordLength:]...)
+
+			// the same frame as TCP, then tagged with a VLAN
+			tcpFrame := append([]byte(nil), udpFrame...)
+			tcpFrame[14+9] = 6
+			vlanFrame := append(append(append([]byte(nil), udpFrame[:12]...), 0x81, 0x00, 0x00, 0x07), udpFrame[12:]...)
+			for _, frame := range [][]byte{tcpFrame, vlanFrame} {
+				record := make([]byte, pcapRecordLength)
+				binary.LittleEndian.PutUint32(record, uint32(start.Unix()))
+				binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+				binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+				capture.Write(record)
+				capture.Write(frame)
+			}
+			packets, err := readAll(capture.Bytes())
+			Expect(err).To(BeNil())
+			Expect(packets).To(HaveLen(2))
+			Expect(string(packets[1].Payload)).To(Equal("udp"))
+			Expect(packets[1].Destination).To(Equal(feed))
+		})
+	})
+
+	Context("with a pcapng capture", func() {
+		// block returns a pcapng block of the body, padded to 4 bytes
+		block := func(order byteOrder, blockType uint32, body []byte) []byte {
+			for len(body)%4 != 0 {
+				body = append(body, 0)
+			}
+			raw := make([]byte, 8, 12+len(body))
+			order.PutUint32(raw, blockType)
+			order.PutUint32(raw[4:], uint32(12+len(body)))
+			raw = append(raw, body...)
+			return order.AppendUint32(raw, uint32(12+len(body)))
+		}
+		// ipFrame returns the IPv4 packet of the payload written by the Writer
+		ipFrame := func(payload string) []byte {
+			var capture bytes.Buffer
+			writer, _ := NewWriter(&capture)
+			writer.WritePacket(Packet{Timestamp: start, Source: source, Destination: feed, Payload: []byte(payload)})
+			return capture.Bytes()[pcapHeaderLength+pcapRecordLength+14:]
+		}
+
+		for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+			order := order
+			It("should read the enhanced packets with the resolution of their interface in "+order.String(), func() {
+				var capture bytes.Buffer
+				section := order.AppendUint32(nil, MAGIC_PCAPNG_ORDER)
+				section = append(section, 1, 0, 0, 0)
+				section = append(section, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+				capture.Write(block(order, MAGIC_PCAPNG, section))
+
+				// raw IP interface with nanosecond timestamps
+				description := order.AppendUint16(nil, LINK_TYPE_RAW)
+				description = append(description, 0, 0, 0, 0, 0, 0)
+				description = order.AppendUint16(description, optionTsResolution)
+				description = order.AppendUint16(description, 1)
+				description = append(description, 9, 0, 0, 0, 0, 0, 0, 0)
+				capture.Write(block(order, blockInterface, description))
+
+				frame := ipFrame("ng")
+				ticks := uint64(start.UnixNano())
+				packet := order.AppendUint32(nil, 0)
+				packet = order.AppendUint32(packet, uint32(ticks>>32))
+				packet = order.AppendUint32(packet, uint32(ticks))
+				packet = order.AppendUint32(packet, uint32(len(frame)))
+				packet = order.AppendUint32(packet, uint32(len(frame)))
+				capture.Write(block(order, blockEnhancedPacket, append(packet, frame...)))
+				capture.Write(block(order, 5, []byte{1, 2, 3, 4}))
+
+				packets, err := readAll(capture.Bytes())
+				Expect(err).To(BeNil())
+				Expect(packets).To(HaveLen(1))
+				Expect(packets[0].Timestamp.Equal(start)).To(BeTrue())
+				Expect(string(packets[0].Payload)).To(Equal("ng"))
+			})
+		}
+
+		It("should return an error when it does not start with a section header", func() {
+			_, err := NewReader(bytes.NewReader([]byte("not a capture")))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,76 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Writer writes a pcap capture of UDP over IPv4 on Ethernet with nanosecond timestamps
+type Writer struct {
+	writer io.Writer
+}
+
+// NewWriter writes the pcap header and returns the Writer of the packets
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := make([]byte, pcapHeaderLength)
+	binary.LittleEndian.PutUint32(header, MAGIC_NANOS)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 0xffff)
+	binary.LittleEndian.PutUint32(header[20:], LINK_TYPE_ETHERNET)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{writer: w}, nil
+}
+
+// WritePacket writes the packet as a single frame, the Source and Destination must be IPv4
+func (w *Writer) WritePacket(packet Packet) error {
+	if !packet.Source.Addr().Unmap().Is4() || !packet.Destination.Addr().Unmap().Is4() {
+		return fmt.Errorf("only IPv4 packets can be written")
+	}
+	udpLength := udpHeaderLength + len(packet.Payload)
+	if 20+udpLength > 0xffff {
+		return fmt.Errorf("udp payload of %d bytes is too long", len(packet.Payload))
+	}
+	frame := make([]byte, 14+20+udpLength)
+	binary.BigEndian.PutUint16(frame[12:], etherTypeIPv4)
+	ip := frame[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+udpLength))
+	ip[8] = 64
+	ip[9] = protocolUdp
+	source, destination := packet.Source.Addr().Unmap().As4(), packet.Destination.Addr().Unmap().As4()
+	copy(ip[12:], source[:])
+	copy(ip[16:], destination[:])
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip[:20]))
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp, packet.Source.Port())
+	binary.BigEndian.PutUint16(udp[2:], packet.Destination.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLength))
+	copy(udp[udpHeaderLength:], packet.Payload)
+
+	record := make([]byte, pcapRecordLength)
+	binary.LittleEndian.PutUint32(record, uint32(packet.Timestamp.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(packet.Timestamp.Nanosecond()))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+	if _, err := w.writer.Write(record); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(frame)
+	return err
+}
+
+// checksum is the IPv4 header checksum
+func checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
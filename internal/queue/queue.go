@@ -0,0 +1,168 @@
+// Package queue holds the bounded queues between the components of the pipeline
+package queue
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/metrics"
+)
+
+// what a queue does with a new item when it is full
+const (
+	POLICY_BLOCK       = "block"       // the producer waits for the consumer
+	POLICY_DROP_OLDEST = "drop-oldest" // the oldest item is dropped
+	POLICY_CONFLATE    = "conflate"    // a line replaces the pending line of the same symbol
+)
+
+// Line is a printed line e.g. the market depth of a symbol, and the symbol it was printed for
+type Line struct {
+	Symbol message.Symbol // zero for a line of no symbol e.g. the csv header of the bars
+	Text   string
+}
+
+// Lines is a bounded queue of printed lines e.g. the market depth, between the OrderBookManager and a writer
+//
+// with conflate a line received when the queue is full replaces the latest pending line of its symbol, so a slow consumer
+// skips to the latest depth instead of stalling the stream. A line of a symbol without a pending line waits for room
+type Lines struct {
+	name      string
+	size      int
+	policy    string
+	in        chan Line    // written by the producer and closed when it is done
+	out       chan Line    // read by the consumer, closed once every line was sent
+	length    atomic.Int64 // lines waiting, read by the metrics while Run updates it
+	dropped   atomic.Uint64
+	conflated atomic.Uint64
+	metrics   *metrics.Metrics // counts the dropped and conflated lines, nil when not exposed
+}
+
+// NewLines returns a Lines queue of size lines, the name is used in the logs and metrics
+func NewLines(name string, size int, policy string) (*Lines, error) {
+	switch policy {
+	case "":
+		policy = POLICY_BLOCK
+	case POLICY_BLOCK, POLICY_DROP_OLDEST, POLICY_CONFLATE:
+	default:
+		return nil, fmt.Errorf("unrecognized %s queue policy %q, expected %s, %s or %s", name, policy, POLICY_BLOCK, POLICY_DROP_OLDEST, POLICY_CONFLATE)
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("%s queue size must be at least 1, got %d", name, size)
+	}
+	return &Lines{name: name, size: size, policy: policy, in: make(chan Line), out: make(chan Line)}, nil
+}
+
+// SetMetrics counts the dropped and conflated lines into m, it must be called before Run
+func (l *Lines) SetMetrics(m *metrics.Metrics) {
+	l.metrics = m
+}
+
+// In is where the producer sends the lines, it must be closed once the producer is done
+func (l *Lines) In() chan<- Line {
+	return l.in
+}
+
+// Out is where the consumer reads the lines from, it is closed after In is closed and every line was read
+func (l *Lines) Out() <-chan Line {
+	return l.out
+}
+
+// Name returns the name of the queue in the logs and metrics
+func (l *Lines) Name() string {
+	return l.name
+}
+
+// Len returns the number of lines waiting
+func (l *Lines) Len() int {
+	return int(l.length.Load())
+}
+
+// Capacity returns the number of lines the queue holds
+func (l *Lines) Capacity() int {
+	return l.size
+}
+
+// Dropped returns the number of lines dropped by drop-oldest
+func (l *Lines) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Conflated returns the number of lines replaced by a later line of their symbol
+func (l *Lines) Conflated() uint64 {
+	return l.conflated.Load()
+}
+
+// Run moves the lines from In to Out until In is closed and every line was read
+func (l *Lines) Run() {
+	defer close(l.out)
+	pending := list.New()
+	latest := make(map[message.Symbol]*list.Element) // latest pending line of each symbol, only with conflate
+	var held *Line                                   // line waiting for room, only with conflate
+	in := l.in
+	for in != nil || pending.Len() > 0 || held != nil {
+		receive := in
+		if held != nil || (l.policy == POLICY_BLOCK && pending.Len() >= l.size) {
+			receive = nil
+		}
+		var send chan Line
+		var head Line
+		if front := pending.Front(); front != nil {
+			send, head = l.out, front.Value.(Line)
+		}
+		select {
+		case line, ok := <-receive:
+			if !ok {
+				in = nil
+				continue
+			}
+			held = l.push(pending, latest, line)
+		case send <- head:
+			front := pending.Front()
+			if l.policy == POLICY_CONFLATE {
+				if latest[head.Symbol] == front {
+					delete(latest, head.Symbol)
+				}
+			}
+			pending.Remove(front)
+			if held != nil {
+				held = l.push(pending, latest, *held)
+			}
+		}
+		l.length.Store(int64(pending.Len()))
+	}
+	if dropped, conflated := l.Dropped(), l.Conflated(); dropped > 0 || conflated > 0 {
+		log.Printf("%s queue dropped %d lines and conflated %d lines \n", l.name, dropped, conflated)
+	}
+}
+
+// push adds the line to the pending lines following the policy, returns the line when it has to wait for room
+func (l *Lines) push(pending *list.List, latest map[message.Symbol]*list.Element, line Line) *Line {
+	switch l.policy {
+	case POLICY_DROP_OLDEST:
+		if pending.Len() >= l.size {
+			pending.Remove(pending.Front())
+			if l.dropped.Add(1) == 1 {
+				log.Printf("%s queue is full, dropping the oldest lines \n", l.name)
+			}
+			l.metrics.QueueDropped(l.name)
+		}
+	case POLICY_CONFLATE:
+		if element, ok := latest[line.Symbol]; ok && pending.Len() >= l.size {
+			// the line goes to the back so that the lines stay in seq order
+			pending.Remove(element)
+			if l.conflated.Add(1) == 1 {
+				log.Printf("%s queue is falling behind, conflating the lines of each symbol \n", l.name)
+			}
+			l.metrics.QueueConflated(l.name)
+		} else if pending.Len() >= l.size {
+			return &line
+		}
+		latest[line.Symbol] = pending.PushBack(line)
+		return nil
+	}
+	pending.PushBack(line)
+	return nil
+}
@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"fmt"
+
+	"github.com/albertsundjaja/order_book/internal/message"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lines", func() {
+	line := func(seq int, symbol string) Line {
+		return Line{Symbol: message.NewSymbol(symbol), Text: fmt.Sprintf("%d, %s, [(%d, 1)], []\n", seq, symbol, seq)}
+	}
+	// fill sends the lines while nothing reads the queue, then reads every line once In is closed
+	fill := func(lines *Lines, sent []Line) []Line {
+		go lines.Run()
+		for _, line := range sent {
+			lines.In() <- line
+		}
+		close(lines.In())
+		var received []Line
+		for line := range lines.Out() {
+			received = append(received, line)
+		}
+		return received
+	}
+
+	Context("with an invalid config", func() {
+		It("should return an error", func() {
+			_, err := NewLines("print", 8, "fifo")
+			Expect(err).To(Not(BeNil()))
+			_, err = NewLines("print", 0, POLICY_BLOCK)
+			Expect(err).To(Not(BeNil()))
+		})
+	})
+
+	Context("with the block policy", func() {
+		It("should make the producer wait when full and keep every line", func() {
+			lines, err := NewLines("print", 2, "")
+			Expect(err).To(BeNil())
+			go lines.Run()
+			lines.In() <- line(1, "VC0")
+			lines.In() <- line(2, "VC0")
+			Consistently(lines.In()).ShouldNot(BeSent(line(3, "VC0")))
+			Expect(lines.Len()).To(Equal(2))
+			Expect(<-lines.Out()).To(Equal(line(1, "VC0")))
+			Eventually(lines.In()).Should(BeSent(line(3, "VC0")))
+			close(lines.In())
+			Expect(<-lines.Out()).To(Equal(line(2, "VC0")))
+			Expect(<-lines.Out()).To(Equal(line(3, "VC0")))
+			Eventually(lines.Out()).Should(BeClosed())
+		})
+	})
+
+	Context("with the drop-oldest policy", func() {
+		It("should keep the latest lines and count the dropped ones", func() {
+			lines, err := NewLines("print", 3, POLICY_DROP_OLDEST)
+			Expect(err).To(BeNil())
+			var sent []Line
+			for seq := 1; seq <= 10; seq++ {
+				sent = append(sent, line(seq, "VC0"))
+			}
+			Expect(fill(lines, sent)).To(Equal(sent[7:]))
+			Expect(lines.Dropped()).To(Equal(uint64(7)))
+			Expect(lines.Conflated()).To(Equal(uint64(0)))
+		})
+	})
+
+	Context("with the conflate policy", func() {
+		It("should replace the latest line of the symbol when full", func() {
+			lines, err := NewLines("print", 2, POLICY_CONFLATE)
+			Expect(err).To(BeNil())
+			received := fill(lines, []Line{line(1, "VC0"), line(2, "VC1"), line(3, "VC0"), line(4, "VC0"), line(5, "VC1")})
+			Expect(received).To(Equal([]Line{line(4, "VC0"), line(5, "VC1")}))
+			Expect(lines.Conflated()).To(Equal(uint64(3)))
+			Expect(lines.Dropped()).To(Equal(uint64(0)))
+		})
+
+		It("should conflate by the symbol of the line whatever its text", func() {
+			lines, err := NewLines("bbo", 1, POLICY_CONFLATE)
+			Expect(err).To(BeNil())
+			first := Line{Symbol: message.NewSymbol("VC0"), Text: "1, VC0, 318800, 10, -, -, -, -\n"}
+			second := Line{Symbol: message.NewSymbol("VC0"), Text: "2, VC0, 318900, 5, -, -, -, -\n"}
+			Expect(fill(lines, []Line{first, second})).To(Equal([]Line{second}))
+			Expect(lines.Conflated()).To(Equal(uint64(1)))
+		})
+
+		It("should make a new symbol wait for room when full", func() {
+			lines, err := NewLines("print", 1, POLICY_CONFLATE)
+			Expect(err).To(BeNil())
+			go lines.Run()
+			lines.In() <- line(1, "VC0")
+			lines.In() <- line(2, "VC1")
+			Consistently(lines.In()).ShouldNot(BeSent(line(3, "VC1")))
+			Expect(<-lines.Out()).To(Equal(line(1, "VC0")))
+			Eventually(lines.In()).Should(BeSent(line(3, "VC1")))
+			close(lines.In())
+			Expect(<-lines.Out()).To(Equal(line(3, "VC1")))
+			Eventually(lines.Out()).Should(BeClosed())
+			Expect(lines.Conflated()).To(Equal(uint64(1)))
+		})
+	})
+})
@@ -0,0 +1,329 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	MOLD_HEADER_LENGTH         = SESSION_LENGTH + 8 + 2 // session, seq of the first msg and msg count
+	MOLD_HEARTBEAT             = 0                      // msg count of a heartbeat, its seq is the next seq
+	MOLD_END_OF_SESSION        = 0xFFFF                 // msg count at the end of the session, its seq is the next seq
+	MOLD_MAX_PACKET            = 0xFFFF                 // largest UDP payload
+	DEFAULT_RETRANSMIT_TIMEOUT = time.Second            // wait before repeating a retransmission request when not configured
+	MOLD_MAX_REQUESTS          = 3                      // retransmission requests of a gap before it is skipped
+	moldReadInterval           = 100 * time.Millisecond // how often the client checks ctx and the retransmission timeout
+)
+
+// MoldPacket is a single MoldUDP64 downstream packet, or a retransmission request when Messages is empty
+type MoldPacket struct {
+	Session  string
+	Seq      uint64 // seq of the first msg
+	Count    uint16 // msg count, MOLD_HEARTBEAT or MOLD_END_OF_SESSION
+	Messages [][]byte
+}
+
+// ParseMoldPacket parses a downstream packet, the msg blocks are 2 bytes big endian length prefixed
+func ParseMoldPacket(raw []byte) (MoldPacket, error) {
+	if len(raw) < MOLD_HEADER_LENGTH {
+		return MoldPacket{}, fmt.Errorf("moldudp64 packet of %d bytes is too short", len(raw))
+	}
+	packet := MoldPacket{
+		Session: strings.TrimSpace(string(raw[:SESSION_LENGTH])),
+		Seq:     binary.BigEndian.Uint64(raw[SESSION_LENGTH:]),
+		Count:   binary.BigEndian.Uint16(raw[SESSION_LENGTH+8:]),
+	}
+	if packet.Count == MOLD_END_OF_SESSION {
+		return packet, nil
+	}
+	blocks := raw[MOLD_HEADER_LENGTH:]
+	for i := 0; i < int(packet.Count); i++ {
+		if len(blocks) < 2 {
+			return MoldPacket{}, fmt.Errorf("moldudp64 packet ends before msg %d of %d", i+1, packet.Count)
+		}
+		length := int(binary.BigEndian.Uint16(blocks))
+		if len(blocks) < 2+length {
+			return MoldPacket{}, fmt.Errorf("moldudp64 msg %d needs %d bytes, got %d", i+1, length, len(blocks)-2)
+		}
+		packet.Messages = append(packet.Messages, blocks[2:2+length])
+		blocks = blocks[2+length:]
+	}
+	return packet, nil
+}
+
+// EncodeMoldPacket encodes a downstream packet carrying msgs from seq, no msgs is a heartbeat
+func EncodeMoldPacket(session string, seq uint64, msgs [][]byte) []byte {
+	raw := encodeMoldHeader(session, seq, uint16(len(msgs)))
+	for _, msg := range msgs {
+		raw = binary.BigEndian.AppendUint16(raw, uint16(len(msg)))
+		raw = append(raw, msg...)
+	}
+	return raw
+}
+
+// EncodeMoldEndOfSession encodes the packet that ends the session, next is the seq after the last msg
+func EncodeMoldEndOfSession(session string, next uint64) []byte {
+	return encodeMoldHeader(session, next, MOLD_END_OF_SESSION)
+}
+
+// EncodeMoldRequest encodes a retransmission request of count msg from seq
+func EncodeMoldRequest(session string, seq uint64, count uint16) []byte {
+	return encodeMoldHeader(session, seq, count)
+}
+
+// ParseMoldRequest parses a retransmission request
+func ParseMoldRequest(raw []byte) (MoldPacket, error) {
+	if len(raw) < MOLD_HEADER_LENGTH {
+		return MoldPacket{}, fmt.Errorf("moldudp64 request of %d bytes is too short", len(raw))
+	}
+	return MoldPacket{
+		Session: strings.TrimSpace(string(raw[:SESSION_LENGTH])),
+		Seq:     binary.BigEndian.Uint64(raw[SESSION_LENGTH:]),
+		Count:   binary.BigEndian.Uint16(raw[SESSION_LENGTH+8:]),
+	}, nil
+}
+
+func encodeMoldHeader(session string, seq uint64, count uint16) []byte {
+	raw := make([]byte, 0, MOLD_MAX_PACKET)
+	raw = append(raw, padRight(session, SESSION_LENGTH)...)
+	raw = binary.BigEndian.AppendUint64(raw, seq)
+	return binary.BigEndian.AppendUint16(raw, count)
+}
+
+// MoldSequencer delivers the msg of MoldUDP64 packets in seq order from the configured first seq, or from the seq of the first packet
+// so that a session can be joined mid-stream
+// msg after a gap are held until the gap is filled or skipped, msg that were already delivered are dropped
+type MoldSequencer struct {
+	session string            // session of the first packet, packets of other sessions are dropped
+	started bool              // next is known, either configured or the seq of the first packet
+	next    uint64            // seq of the next msg to deliver
+	high    uint64            // seq after the highest msg the packets announced
+	pending map[uint64][]byte // msg after a gap by seq
+	ended   bool              // end of session was received
+}
+
+// NewMoldSequencer return an instance of MoldSequencer that starts at firstSeq, 0 means the seq of the first packet
+func NewMoldSequencer(firstSeq uint64) *MoldSequencer {
+	return &MoldSequencer{started: firstSeq > 0, next: firstSeq, high: firstSeq, pending: make(map[uint64][]byte)}
+}
+
+// Packet delivers the msg of the packet that are next in seq order, with any held msg that follow them
+func (s *MoldSequencer) Packet(packet MoldPacket, deliver Deliver) error {
+	if s.session == "" {
+		s.session = packet.Session
+	} else if packet.Session != s.session {
+		return nil
+	}
+	if !s.started {
+		s.started = true
+		s.next, s.high = packet.Seq, packet.Seq
+	}
+	if packet.Count == MOLD_END_OF_SESSION {
+		s.ended = true
+	}
+	end := packet.Seq + uint64(len(packet.Messages))
+	if end > s.high {
+		s.high = end
+	}
+	for i, msg := range packet.Messages {
+		seq := packet.Seq + uint64(i)
+		if seq >= s.next {
+			// msg are copied, the packet buffer is reused by the next read
+			s.pending[seq] = append([]byte(nil), msg...)
+		}
+	}
+	return s.deliverPending(deliver)
+}
+
+// deliverPending delivers the held msg that are next in seq order
+func (s *MoldSequencer) deliverPending(deliver Deliver) error {
+	for {
+		msg, ok := s.pending[s.next]
+		if !ok {
+			return nil
+		}
+		delete(s.pending, s.next)
+		if err := deliver(s.next, msg); err != nil {
+			return err
+		}
+		s.next++
+	}
+}
+
+// Gap returns the range of msg that are missing before the held msg, count is 0 when nothing is missing
+func (s *MoldSequencer) Gap() (seq uint64, count uint16) {
+	end := s.high
+	for held := range s.pending {
+		if held < end {
+			end = held
+		}
+	}
+	if end <= s.next {
+		return s.next, 0
+	}
+	if end-s.next >= MOLD_END_OF_SESSION {
+		return s.next, MOLD_END_OF_SESSION - 1
+	}
+	return s.next, uint16(end - s.next)
+}
+
+// Skip gives up on the msg missing before the held msg and delivers the held msg that follow them
+// it is used when the gap can not be retransmitted, returns the range of msg that were skipped
+func (s *MoldSequencer) Skip(deliver Deliver) (seq uint64, count uint64, err error) {
+	end := s.high
+	for held := range s.pending {
+		if held < end {
+			end = held
+		}
+	}
+	if end <= s.next {
+		return s.next, 0, nil
+	}
+	seq, count = s.next, end-s.next
+	s.next = end
+	return seq, count, s.deliverPending(deliver)
+}
+
+// Done reports whether the session ended and every msg was delivered
+func (s *MoldSequencer) Done() bool {
+	return s.ended && s.next >= s.high
+}
+
+// Session returns the session of the first packet
+func (s *MoldSequencer) Session() string {
+	return s.session
+}
+
+// MoldClient receives MoldUDP64 packets and requests the msg that were missed from the retransmission server
+// the responses are sent back to the address the packets are received on. A gap that is not filled after MOLD_MAX_REQUESTS requests,
+// or after retransmitTimeout without a retransmission server, is logged and skipped
+type MoldClient struct {
+	conn              net.PacketConn
+	requestAddress    string
+	retransmitTimeout time.Duration
+	sequencer         *MoldSequencer
+}
+
+// NewMoldClient return an instance of MoldClient that reads the packets from conn
+// requestAddress is the host:port of the retransmission server, no gap is requested when it is empty
+// firstSeq is the seq of the first msg to deliver, 0 means the seq of the first packet
+func NewMoldClient(conn net.PacketConn, requestAddress string, retransmitTimeout time.Duration, firstSeq uint64) *MoldClient {
+	if retransmitTimeout <= 0 {
+		retransmitTimeout = DEFAULT_RETRANSMIT_TIMEOUT
+	}
+	return &MoldClient{
+		conn:              conn,
+		requestAddress:    requestAddress,
+		retransmitTimeout: retransmitTimeout,
+		sequencer:         NewMoldSequencer(firstSeq),
+	}
+}
+
+// ListenMold returns the conn the packets sent to address are received on, a multicast group is joined
+func ListenMold(address string) (net.PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if udpAddr.IP != nil && udpAddr.IP.IsMulticast() {
+		return net.ListenMulticastUDP("udp", nil, udpAddr)
+	}
+	return net.ListenUDP("udp", udpAddr)
+}
+
+// Run delivers the msg until the end of session, ctx is cancelled or deliver returns an error
+// returns nil at the end of session and ctx.Err() when cancelled, the conn is closed when Run returns
+func (c *MoldClient) Run(ctx context.Context, deliver Deliver) error {
+	defer c.conn.Close()
+	var requestAddr net.Addr
+	if c.requestAddress != "" {
+		addr, err := net.ResolveUDPAddr("udp", c.requestAddress)
+		if err != nil {
+			return fmt.Errorf("invalid moldudp64 request address: %w", err)
+		}
+		requestAddr = addr
+	}
+	var gapSeq uint64       // first missing msg of the current gap
+	var gapSince time.Time  // when the current gap was found
+	var requested time.Time // when the current gap was last requested
+	var requests int        // requests of the current gap
+	fillGap := func() error {
+		seq, count := c.sequencer.Gap()
+		if count == 0 {
+			return nil
+		}
+		if seq != gapSeq || gapSince.IsZero() {
+			gapSeq, gapSince, requested, requests = seq, time.Now(), time.Time{}, 0
+		}
+		if requestAddr == nil {
+			// packets can arrive out of order, the gap is only skipped once they had time to arrive
+			if time.Since(gapSince) < c.retransmitTimeout {
+				return nil
+			}
+			return c.skip(deliver)
+		}
+		// a gap is requested again once the previous request timed out
+		if time.Since(requested) < c.retransmitTimeout {
+			return nil
+		}
+		if requests >= MOLD_MAX_REQUESTS {
+			return c.skip(deliver)
+		}
+		requested = time.Now()
+		requests++
+		if _, err := c.conn.WriteTo(EncodeMoldRequest(c.sequencer.Session(), seq, count), requestAddr); err != nil {
+			return fmt.Errorf("unable to request moldudp64 retransmission: %w", err)
+		}
+		return nil
+	}
+
+	buf := make([]byte, MOLD_MAX_PACKET)
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.conn.SetReadDeadline(time.Now().Add(moldReadInterval))
+		n, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if err = fillGap(); err != nil {
+					return err
+				}
+				if c.sequencer.Done() {
+					return nil
+				}
+				continue
+			}
+			return err
+		}
+		packet, err := ParseMoldPacket(buf[:n])
+		if err != nil {
+			return err
+		}
+		if err = c.sequencer.Packet(packet, deliver); err != nil {
+			return err
+		}
+		if err = fillGap(); err != nil {
+			return err
+		}
+		if c.sequencer.Done() {
+			return nil
+		}
+	}
+}
+
+// skip gives up on the current gap and delivers the msg held after it
+func (c *MoldClient) skip(deliver Deliver) error {
+	seq, count, err := c.sequencer.Skip(deliver)
+	if count > 0 {
+		log.Printf("moldudp64 msg %d to %d were not received, skipping them \n", seq, seq+count-1)
+	}
+	return err
+}
@@ -0,0 +1,216 @@
+package session
+
+import (
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MoldUDP64", func() {
+	Context("with an encoded packet", func() {
+		It("should parse it back", func() {
+			packet, err := ParseMoldPacket(EncodeMoldPacket("SESSION1", 7, [][]byte{[]byte("ab"), []byte("c")}))
+			Expect(err).To(BeNil())
+			Expect(packet).To(Equal(MoldPacket{Session: "SESSION1", Seq: 7, Count: 2, Messages: [][]byte{[]byte("ab"), []byte("c")}}))
+		})
+
+		It("should return an error when it is truncated", func() {
+			raw := EncodeMoldPacket("SESSION1", 7, [][]byte{[]byte("ab")})
+			_, err := ParseMoldPacket(raw[:len(raw)-1])
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("MoldSequencer", func() {
+		var sequencer *MoldSequencer
+		var msgs []delivered
+		deliver := func(seq uint64, payload []byte) error {
+			msgs = append(msgs, delivered{seq, string(payload)})
+			return nil
+		}
+		packet := func(seq uint64, payloads ...string) MoldPacket {
+			packet := MoldPacket{Session: "SESSION1", Seq: seq, Count: uint16(len(payloads))}
+			for _, payload := range payloads {
+				packet.Messages = append(packet.Messages, []byte(payload))
+			}
+			return packet
+		}
+
+		BeforeEach(func() {
+			sequencer = NewMoldSequencer(1)
+			msgs = nil
+		})
+
+		It("should hold the msg after a gap until it is filled", func() {
+			Expect(sequencer.Packet(packet(1, "a"), deliver)).To(BeNil())
+			Expect(sequencer.Packet(packet(4, "d", "e"), deliver)).To(BeNil())
+			seq, count := sequencer.Gap()
+			Expect([]uint64{seq, uint64(count)}).To(Equal([]uint64{2, 2}))
+			Expect(msgs).To(Equal([]delivered{{1, "a"}}))
+
+			Expect(sequencer.Packet(packet(2, "b", "c"), deliver)).To(BeNil())
+			Expect(msgs).To(Equal([]delivered{{1, "a"}, {2, "b"}, {3, "c"}, {4, "d"}, {5, "e"}}))
+			_, count = sequencer.Gap()
+			Expect(count).To(Equal(uint16(0)))
+		})
+
+		It("should drop the msg that were already delivered and packets of other sessions", func() {
+			Expect(sequencer.Packet(packet(1, "a", "b"), deliver)).To(BeNil())
+			Expect(sequencer.Packet(packet(2, "b", "c"), deliver)).To(BeNil())
+			other := packet(4, "x")
+			other.Session = "SESSION2"
+			Expect(sequencer.Packet(other, deliver)).To(BeNil())
+			Expect(msgs).To(Equal([]delivered{{1, "a"}, {2, "b"}, {3, "c"}}))
+		})
+
+		It("should start at the first packet without a first seq", func() {
+			sequencer = NewMoldSequencer(0)
+			Expect(sequencer.Packet(packet(1000, "x"), deliver)).To(BeNil())
+			Expect(sequencer.Packet(packet(1001, "y"), deliver)).To(BeNil())
+			Expect(msgs).To(Equal([]delivered{{1000, "x"}, {1001, "y"}}))
+		})
+
+		It("should deliver the held msg when the gap is skipped", func() {
+			Expect(sequencer.Packet(packet(1, "a"), deliver)).To(BeNil())
+			Expect(sequencer.Packet(packet(4, "d"), deliver)).To(BeNil())
+			seq, count, err := sequencer.Skip(deliver)
+			Expect(err).To(BeNil())
+			Expect([]uint64{seq, count}).To(Equal([]uint64{2, 2}))
+			Expect(msgs).To(Equal([]delivered{{1, "a"}, {4, "d"}}))
+			// a late msg of the skipped gap is dropped
+			Expect(sequencer.Packet(packet(2, "b"), deliver)).To(BeNil())
+			Expect(sequencer.Packet(packet(5, "e"), deliver)).To(BeNil())
+			Expect(msgs).To(Equal([]delivered{{1, "a"}, {4, "d"}, {5, "e"}}))
+		})
+
+		It("should find a gap from a heartbeat and be done after the end of session", func() {
+			Expect(sequencer.Packet(packet(1, "a"), deliver)).To(BeNil())
+			Expect(sequencer.Packet(MoldPacket{Session: "SESSION1", Seq: 3, Count: MOLD_END_OF_SESSION}, deliver)).To(BeNil())
+			Expect(sequencer.Done()).To(BeFalse())
+			seq, count := sequencer.Gap()
+			Expect([]uint64{seq, uint64(count)}).To(Equal([]uint64{2, 1}))
+			Expect(sequencer.Packet(packet(2, "b"), deliver)).To(BeNil())
+			Expect(sequencer.Done()).To(BeTrue())
+		})
+	})
+
+	Describe("MoldClient", func() {
+		var feed, requests, client net.PacketConn
+
+		BeforeEach(func() {
+			var err error
+			feed, err = net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			requests, err = net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			client, err = ListenMold("127.0.0.1:0")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			feed.Close()
+			requests.Close()
+		})
+
+		It("should request the missed msg from the retransmission server and deliver them in order", func() {
+			msgs := []string{"a", "b", "c", "d", "e"}
+			// stands in for the retransmission server, the first request is ignored so it has to be repeated
+			received := make(chan MoldPacket, 10)
+			go func() {
+				buf := make([]byte, MOLD_MAX_PACKET)
+				for first := true; ; first = false {
+					n, from, err := requests.ReadFrom(buf)
+					if err != nil {
+						return
+					}
+					request, err := ParseMoldRequest(buf[:n])
+					if err != nil {
+						continue
+					}
+					received <- request
+					if first {
+						continue
+					}
+					var resend [][]byte
+					for seq := request.Seq; seq < request.Seq+uint64(request.Count); seq++ {
+						resend = append(resend, []byte(msgs[seq-1]))
+					}
+					requests.WriteTo(EncodeMoldPacket(request.Session, request.Seq, resend), from)
+				}
+			}()
+			to := client.LocalAddr()
+			feed.WriteTo(EncodeMoldPacket("SESSION1", 1, [][]byte{[]byte("a")}), to)
+			feed.WriteTo(EncodeMoldPacket("SESSION1", 4, [][]byte{[]byte("d"), []byte("e")}), to)
+			feed.WriteTo(EncodeMoldEndOfSession("SESSION1", 6), to)
+
+			var got []delivered
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := NewMoldClient(client, requests.LocalAddr().String(), 200*time.Millisecond, 1).Run(ctx, func(seq uint64, payload []byte) error {
+				got = append(got, delivered{seq, string(payload)})
+				return nil
+			})
+			Expect(err).To(BeNil())
+			Expect(got).To(Equal([]delivered{{1, "a"}, {2, "b"}, {3, "c"}, {4, "d"}, {5, "e"}}))
+			Expect(len(received)).To(BeNumerically(">=", 2))
+			request := <-received
+			Expect(request).To(Equal(MoldPacket{Session: "SESSION1", Seq: 2, Count: 2}))
+		})
+
+		It("should skip a gap after the timeout without a retransmission server", func() {
+			to := client.LocalAddr()
+			// joins mid-session, 12 is lost
+			feed.WriteTo(EncodeMoldPacket("SESSION1", 10, [][]byte{[]byte("j"), []byte("k")}), to)
+			feed.WriteTo(EncodeMoldPacket("SESSION1", 13, [][]byte{[]byte("m")}), to)
+			feed.WriteTo(EncodeMoldEndOfSession("SESSION1", 14), to)
+
+			var got []delivered
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := NewMoldClient(client, "", 200*time.Millisecond, 0).Run(ctx, func(seq uint64, payload []byte) error {
+				got = append(got, delivered{seq, string(payload)})
+				return nil
+			})
+			Expect(err).To(BeNil())
+			Expect(got).To(Equal([]delivered{{10, "j"}, {11, "k"}, {13, "m"}}))
+		})
+
+		It("should skip a gap the retransmission server never fills", func() {
+			requested := make(chan struct{}, 10)
+			go func() {
+				buf := make([]byte, MOLD_MAX_PACKET)
+				for {
+					if _, _, err := requests.ReadFrom(buf); err != nil {
+						return
+					}
+					requested <- struct{}{}
+				}
+			}()
+			to := client.LocalAddr()
+			feed.WriteTo(EncodeMoldPacket("SESSION1", 1, [][]byte{[]byte("a")}), to)
+			feed.WriteTo(EncodeMoldPacket("SESSION1", 3, [][]byte{[]byte("c")}), to)
+			feed.WriteTo(EncodeMoldEndOfSession("SESSION1", 4), to)
+
+			var got []delivered
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := NewMoldClient(client, requests.LocalAddr().String(), 100*time.Millisecond, 1).Run(ctx, func(seq uint64, payload []byte) error {
+				got = append(got, delivered{seq, string(payload)})
+				return nil
+			})
+			Expect(err).To(BeNil())
+			Expect(got).To(Equal([]delivered{{1, "a"}, {3, "c"}}))
+			Eventually(requested).Should(HaveLen(MOLD_MAX_REQUESTS))
+		})
+
+		It("should return ctx.Err() when cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := NewMoldClient(client, "", 0, 0).Run(ctx, func(uint64, []byte) error { return nil })
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+})
@@ -0,0 +1,26 @@
+// Package session implements the SoupBinTCP and MoldUDP64 session layer protocols that carry the feed msg
+package session
+
+import "strings"
+
+// Deliver is called with every msg of the session in seq order, returning an error stops the session
+type Deliver func(seq uint64, payload []byte) error
+
+// SESSION_LENGTH is the length of the session name in both protocols
+const SESSION_LENGTH = 10
+
+// padLeft right aligns s in a field of width bytes
+func padLeft(s string, width int) string {
+	if len(s) >= width {
+		return s[:width]
+	}
+	return strings.Repeat(" ", width-len(s)) + s
+}
+
+// padRight left aligns s in a field of width bytes
+func padRight(s string, width int) string {
+	if len(s) >= width {
+		return s[:width]
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
@@ -0,0 +1,242 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SoupBinTCP 3.0 packet types
+const (
+	SOUP_DEBUG            = '+'
+	SOUP_LOGIN_ACCEPTED   = 'A'
+	SOUP_LOGIN_REJECTED   = 'J'
+	SOUP_SEQUENCED_DATA   = 'S'
+	SOUP_SERVER_HEARTBEAT = 'H'
+	SOUP_END_OF_SESSION   = 'Z'
+	SOUP_LOGIN_REQUEST    = 'L'
+	SOUP_CLIENT_HEARTBEAT = 'R'
+	SOUP_LOGOUT_REQUEST   = 'O'
+)
+
+const (
+	DEFAULT_SOUP_HEARTBEAT = time.Second      // client heartbeat interval when not configured
+	SOUP_TIMEOUT           = 15 * time.Second // the connection is lost when the server sends nothing for that long
+	soupUsernameLength     = 6
+	soupPasswordLength     = 10
+	soupSequenceLength     = 20
+)
+
+// SoupPacket is a single SoupBinTCP packet
+type SoupPacket struct {
+	Type    byte
+	Payload []byte
+}
+
+// SoupBinConfig is the SoupBinTCP server and login of a SoupBinClient
+type SoupBinConfig struct {
+	Address    string        // host:port of the server
+	Username   string        // up to 6 bytes
+	Password   string        // up to 10 bytes
+	Session    string        // session to log into, blank means the current one
+	Heartbeat  time.Duration // client heartbeat interval, 0 means DEFAULT_SOUP_HEARTBEAT
+	Reconnects int           // times the connection is re-established after it drops
+}
+
+// SoupBinClient logs into a SoupBinTCP server and delivers the sequenced data
+// the seq of the sequenced data is implicit, it starts at the seq of the login accepted and increments by one.
+// when the connection drops the client logs in again requesting the next seq, so the server sends the missed msg again
+type SoupBinClient struct {
+	config  SoupBinConfig
+	session string // session that was logged into, kept when logging in again
+	next    uint64 // seq of the next sequenced data
+}
+
+// NewSoupBinClient return an instance of SoupBinClient, the first login requests seq 1
+func NewSoupBinClient(config SoupBinConfig) *SoupBinClient {
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = DEFAULT_SOUP_HEARTBEAT
+	}
+	return &SoupBinClient{config: config, session: config.Session, next: 1}
+}
+
+// Run logs in and delivers the sequenced data until the end of session, ctx is cancelled or deliver returns an error
+// returns nil at the end of session and ctx.Err() when cancelled
+func (c *SoupBinClient) Run(ctx context.Context, deliver Deliver) error {
+	var err error
+	for attempt := 0; attempt <= c.config.Reconnects; attempt++ {
+		if attempt > 0 {
+			log.Printf("soupbintcp connection lost: %s, logging in again from seq %d \n", err.Error(), c.next)
+		}
+		var progressed bool
+		progressed, err = c.connect(ctx, deliver)
+		if !errors.Is(err, errConnectionLost) || ctx.Err() != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if progressed {
+			// only consecutive failures count against the reconnects
+			attempt = 0
+		}
+	}
+	return err
+}
+
+// errConnectionLost wraps the errors after which the client can log in again
+var errConnectionLost = errors.New("connection lost")
+
+// connect runs a single connection, progressed reports whether any sequenced data was delivered
+func (c *SoupBinClient) connect(ctx context.Context, deliver Deliver) (progressed bool, err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.config.Address)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", errConnectionLost, err)
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		conn.Close()
+		wg.Wait()
+	}()
+	// writes of the heartbeats and of the logout are serialized
+	var writeLock sync.Mutex
+	write := func(packetType byte, payload []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		return WriteSoupPacket(conn, packetType, payload)
+	}
+
+	if err = write(SOUP_LOGIN_REQUEST, c.loginRequest()); err != nil {
+		return false, fmt.Errorf("%w: %s", errConnectionLost, err)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(c.config.Heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if write(SOUP_CLIENT_HEARTBEAT, nil) != nil {
+					return
+				}
+			case <-ctx.Done():
+				write(SOUP_LOGOUT_REQUEST, nil)
+				conn.Close()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(SOUP_TIMEOUT))
+		packet, err := ReadSoupPacket(reader)
+		if err != nil {
+			return progressed, fmt.Errorf("%w: %s", errConnectionLost, err)
+		}
+		switch packet.Type {
+		case SOUP_LOGIN_ACCEPTED:
+			if err = c.loginAccepted(packet.Payload); err != nil {
+				return progressed, err
+			}
+		case SOUP_LOGIN_REJECTED:
+			return progressed, fmt.Errorf("soupbintcp login rejected: %q", packet.Payload)
+		case SOUP_SEQUENCED_DATA:
+			if err = deliver(c.next, packet.Payload); err != nil {
+				return progressed, err
+			}
+			c.next++
+			progressed = true
+		case SOUP_END_OF_SESSION:
+			write(SOUP_LOGOUT_REQUEST, nil)
+			return progressed, nil
+		}
+	}
+}
+
+// loginRequest returns the payload of the login request for the next seq
+func (c *SoupBinClient) loginRequest() []byte {
+	return []byte(padRight(c.config.Username, soupUsernameLength) +
+		padRight(c.config.Password, soupPasswordLength) +
+		padLeft(c.session, SESSION_LENGTH) +
+		padLeft(strconv.FormatUint(c.next, 10), soupSequenceLength))
+}
+
+// loginAccepted keeps the session and continues from the seq that the server will send next
+func (c *SoupBinClient) loginAccepted(payload []byte) error {
+	if len(payload) < SESSION_LENGTH+soupSequenceLength {
+		return fmt.Errorf("soupbintcp login accepted of %d bytes is too short", len(payload))
+	}
+	next, err := strconv.ParseUint(strings.TrimSpace(string(payload[SESSION_LENGTH:SESSION_LENGTH+soupSequenceLength])), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid soupbintcp login accepted seq: %w", err)
+	}
+	c.session = strings.TrimSpace(string(payload[:SESSION_LENGTH]))
+	c.next = next
+	return nil
+}
+
+// ReadSoupPacket reads a packet prefixed by its 2 bytes big endian length, the length includes the packet type
+func ReadSoupPacket(r io.Reader) (SoupPacket, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return SoupPacket{}, err
+	}
+	size := binary.BigEndian.Uint16(length[:])
+	if size == 0 {
+		return SoupPacket{}, fmt.Errorf("soupbintcp packet without a type")
+	}
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return SoupPacket{}, err
+	}
+	return SoupPacket{Type: raw[0], Payload: raw[1:]}, nil
+}
+
+// WriteSoupPacket writes a packet prefixed by its 2 bytes big endian length
+func WriteSoupPacket(w io.Writer, packetType byte, payload []byte) error {
+	if len(payload)+1 > 0xFFFF {
+		return fmt.Errorf("soupbintcp payload of %d bytes is too long", len(payload))
+	}
+	raw := make([]byte, 3+len(payload))
+	binary.BigEndian.PutUint16(raw, uint16(len(payload)+1))
+	raw[2] = packetType
+	copy(raw[3:], payload)
+	_, err := w.Write(raw)
+	return err
+}
+
+// EncodeLoginAccepted returns the payload of a login accepted for the session and the seq of the next sequenced data
+func EncodeLoginAccepted(session string, next uint64) []byte {
+	return []byte(padLeft(session, SESSION_LENGTH) + padLeft(strconv.FormatUint(next, 10), soupSequenceLength))
+}
+
+// ParseLoginRequest returns the username, password, session and requested seq of a login request payload
+func ParseLoginRequest(payload []byte) (username string, password string, session string, next uint64, err error) {
+	if len(payload) < soupUsernameLength+soupPasswordLength+SESSION_LENGTH+soupSequenceLength {
+		return "", "", "", 0, fmt.Errorf("soupbintcp login request of %d bytes is too short", len(payload))
+	}
+	field := func(from, length int) string {
+		return strings.TrimSpace(string(payload[from : from+length]))
+	}
+	next, err = strconv.ParseUint(field(soupUsernameLength+soupPasswordLength+SESSION_LENGTH, soupSequenceLength), 10, 64)
+	return field(0, soupUsernameLength), field(soupUsernameLength, soupPasswordLength), field(soupUsernameLength+soupPasswordLength, SESSION_LENGTH), next, err
+}
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// delivered is a msg passed to Deliver
+type delivered struct {
+	Seq     uint64
+	Payload string
+}
+
+var _ = Describe("SoupBinClient", func() {
+	var listener net.Listener
+	var logins chan uint64
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		logins = make(chan uint64, 10)
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	// serve stands in for a SoupBinTCP server of msgs, every connection logs in and is sent the msg from the requested seq
+	// the first connection is dropped after dropAfter msg, the last connection ends the session
+	serve := func(msgs []string, dropAfter int) {
+		go func() {
+			for connection := 0; ; connection++ {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				packet, err := ReadSoupPacket(conn)
+				if err != nil || packet.Type != SOUP_LOGIN_REQUEST {
+					conn.Close()
+					continue
+				}
+				username, password, _, next, err := ParseLoginRequest(packet.Payload)
+				if err != nil || username != "user" || password != "secret" {
+					WriteSoupPacket(conn, SOUP_LOGIN_REJECTED, []byte{'A'})
+					conn.Close()
+					continue
+				}
+				logins <- next
+				WriteSoupPacket(conn, SOUP_LOGIN_ACCEPTED, EncodeLoginAccepted("SESSION1", next))
+				WriteSoupPacket(conn, SOUP_SERVER_HEARTBEAT, nil)
+				for seq := next; seq <= uint64(len(msgs)); seq++ {
+					if connection == 0 && seq > uint64(dropAfter) {
+						break
+					}
+					WriteSoupPacket(conn, SOUP_SEQUENCED_DATA, []byte(msgs[seq-1]))
+				}
+				if connection > 0 || dropAfter >= len(msgs) {
+					WriteSoupPacket(conn, SOUP_END_OF_SESSION, nil)
+				}
+				conn.Close()
+			}
+		}()
+	}
+
+	run := func(client *SoupBinClient) ([]delivered, error) {
+		var msgs []delivered
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := client.Run(ctx, func(seq uint64, payload []byte) error {
+			msgs = append(msgs, delivered{seq, string(payload)})
+			return nil
+		})
+		return msgs, err
+	}
+
+	Context("with a session that ends", func() {
+		It("should deliver every sequenced data from seq 1", func() {
+			serve([]string{"a", "b", "c"}, 3)
+			msgs, err := run(NewSoupBinClient(SoupBinConfig{Address: listener.Addr().String(), Username: "user", Password: "secret"}))
+			Expect(err).To(BeNil())
+			Expect(msgs).To(Equal([]delivered{{1, "a"}, {2, "b"}, {3, "c"}}))
+			Expect(<-logins).To(Equal(uint64(1)))
+		})
+	})
+
+	Context("with a connection that drops", func() {
+		It("should log in again from the next seq and deliver the missed msg once", func() {
+			serve([]string{"a", "b", "c", "d", "e"}, 2)
+			msgs, err := run(NewSoupBinClient(SoupBinConfig{Address: listener.Addr().String(), Username: "user", Password: "secret", Reconnects: 1}))
+			Expect(err).To(BeNil())
+			Expect(msgs).To(Equal([]delivered{{1, "a"}, {2, "b"}, {3, "c"}, {4, "d"}, {5, "e"}}))
+			Expect(<-logins).To(Equal(uint64(1)))
+			Expect(<-logins).To(Equal(uint64(3)))
+		})
+
+		It("should return an error when it can not reconnect", func() {
+			serve([]string{"a", "b", "c"}, 1)
+			msgs, err := run(NewSoupBinClient(SoupBinConfig{Address: listener.Addr().String(), Username: "user", Password: "secret"}))
+			Expect(err).NotTo(BeNil())
+			Expect(msgs).To(Equal([]delivered{{1, "a"}}))
+		})
+	})
+
+	Context("with a rejected login", func() {
+		It("should return an error without reconnecting", func() {
+			serve([]string{"a"}, 1)
+			_, err := run(NewSoupBinClient(SoupBinConfig{Address: listener.Addr().String(), Username: "user", Password: "wrong", Reconnects: 3}))
+			Expect(err).To(MatchError(ContainSubstring("login rejected")))
+		})
+	})
+
+	Context("with a cancelled ctx", func() {
+		It("should log out and return ctx.Err()", func() {
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				ReadSoupPacket(conn)
+				WriteSoupPacket(conn, SOUP_LOGIN_ACCEPTED, EncodeLoginAccepted("SESSION1", 1))
+				accepted <- conn
+			}()
+			ctx, cancel := context.WithCancel(context.Background())
+			result := make(chan error, 1)
+			go func() {
+				result <- NewSoupBinClient(SoupBinConfig{Address: listener.Addr().String(), Heartbeat: time.Hour}).Run(ctx, func(uint64, []byte) error { return nil })
+			}()
+			conn := <-accepted
+			defer conn.Close()
+			cancel()
+			Eventually(result, 5*time.Second).Should(Receive(Equal(context.Canceled)))
+			packet, err := ReadSoupPacket(conn)
+			Expect(err).To(BeNil())
+			Expect(packet.Type).To(Equal(byte(SOUP_LOGOUT_REQUEST)))
+		})
+	})
+})
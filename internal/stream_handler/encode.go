@@ -0,0 +1,23 @@
+package stream_handler
+
+import (
+	"io"
+
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// EncodeMsg marshall the Message into the raw Header + msg type + body frame using the default schema, it is the inverse of ParseMsg
+// Header.Size is computed from the body, only Header.Seq is taken from the msg
+func EncodeMsg(msg message.Message) ([]byte, error) {
+	return defaultSchema.Encode(msg)
+}
+
+// WriteMsg encodes the Message and writes the frame into w
+func WriteMsg(w io.Writer, msg message.Message) error {
+	frame, err := EncodeMsg(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
@@ -0,0 +1,88 @@
+package stream_handler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// Frame is a single raw Header + body frame read from a capture
+type Frame struct {
+	Offset  int64          // byte offset of the start of the header in the capture
+	Header  message.Header // decoded header of the frame
+	MsgType string         // msg type, the first byte after the header
+	Body    []byte         // the rest of the msg after the msg type
+	symbol  message.Symbol // symbol read from the body with the schema
+}
+
+// Symbol returns the symbol of the frame, empty for an unrecognized msg type
+func (f Frame) Symbol() message.Symbol {
+	return f.symbol
+}
+
+// FrameReader reads the capture frame by frame without decoding the body
+// unlike StreamHandler, it keeps track of the byte offset of each frame
+type FrameReader struct {
+	config    *config.Config // store app config
+	schema    *Schema        // wire layout of the header and msg
+	schemaErr error          // returned by Next when the configured schema is invalid
+	reader    *bufio.Reader  // where to get the input from
+	offset    int64          // byte offset of the next frame
+}
+
+// NewFrameReader return an instance of FrameReader
+func NewFrameReader(config *config.Config, input io.Reader) *FrameReader {
+	schema, err := SchemaOf(config)
+	return &FrameReader{
+		config:    config,
+		schema:    schema,
+		schemaErr: err,
+		reader:    bufio.NewReader(input),
+	}
+}
+
+// Next returns the next frame in the capture
+// returns io.EOF when the capture ends cleanly on a frame boundary and io.ErrUnexpectedEOF when the last frame is truncated
+func (f *FrameReader) Next() (Frame, error) {
+	if f.schemaErr != nil {
+		return Frame{}, fmt.Errorf("invalid schema: %w", f.schemaErr)
+	}
+	frame := Frame{Offset: f.offset}
+	rawHeader := make([]byte, f.schema.HeaderLength())
+	if _, err := io.ReadFull(f.reader, rawHeader); err != nil {
+		return Frame{}, err
+	}
+	header, err := f.schema.DecodeHeader(rawHeader)
+	if err != nil {
+		return Frame{}, fmt.Errorf("%w at offset %d", err, frame.Offset)
+	}
+	frame.Header = header
+	if err = ValidateHeader(f.config, frame.Header); err != nil {
+		return Frame{}, fmt.Errorf("%w at offset %d", err, frame.Offset)
+	}
+	rawMsg := make([]byte, frame.Header.Size)
+	if _, err := io.ReadFull(f.reader, rawMsg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	frame.MsgType = string(rawMsg[:1])
+	frame.Body = rawMsg[1:]
+	frame.symbol = f.schema.Symbol(frame.MsgType, frame.Body)
+	f.offset += int64(f.schema.HeaderLength()) + int64(frame.Header.Size)
+	return frame, nil
+}
+
+// Parse decodes the body of the frame with the schema of the FrameReader
+func (f *FrameReader) Parse(frame Frame) (message.Message, error) {
+	msg, err := f.schema.Decode(frame.MsgType, frame.Body)
+	if err != nil {
+		return message.Message{}, err
+	}
+	msg.MsgHeader = frame.Header
+	return msg, nil
+}
@@ -0,0 +1,135 @@
+package stream_handler_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/generator"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+)
+
+// fuzzConfig is the config used by the fuzz targets
+func fuzzConfig() *config.Config {
+	config := &config.Config{}
+	config.Stream.HeaderLength = 8
+	return config
+}
+
+// addSeeds adds the sample capture, a synthetic feed and a few corrupted headers to the corpus
+func addSeeds(f *testing.F) {
+	if sample, err := os.ReadFile("../../input1.stream"); err == nil {
+		f.Add(sample, []byte{1, 7, 255})
+		f.Add(sample[:len(sample)-3], []byte{8})
+	}
+	gen, err := generator.NewGenerator(generator.DefaultConfig())
+	if err != nil {
+		f.Fatal(err)
+	}
+	var feed bytes.Buffer
+	if err = gen.WriteTo(&feed, 50); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(feed.Bytes(), []byte{3, 1, 4, 1, 5, 9, 2, 6})
+	// Header.Size of 0, 1 and 0xFFFFFFFF
+	f.Add([]byte{1, 0, 0, 0, 0, 0, 0, 0}, []byte{})
+	f.Add([]byte{1, 0, 0, 0, 1, 0, 0, 0, 'A'}, []byte{2})
+	f.Add([]byte{1, 0, 0, 0, 255, 255, 255, 255, 'A', 'V', 'C'}, []byte{1})
+}
+
+// decode feeds the stream to a StreamHandler in chunks, chunk sizes are taken from chunks with 0 meaning 1 byte
+// returns all decoded msg and whether the StreamHandler reported an error
+func decode(stream []byte, chunks []byte) ([]message.Message, bool) {
+	orderBookChan := make(chan message.Message)
+	streamHandler := stream_handler.NewStreamHandler(fuzzConfig(), nil, orderBookChan)
+	var msgs []message.Message
+	done := make(chan bool)
+	go func() {
+		for msg := range orderBookChan {
+			msgs = append(msgs, msg)
+		}
+		done <- true
+	}()
+
+	failed := false
+	for i := 0; len(stream) > 0; i++ {
+		size := len(stream)
+		if len(chunks) > 0 {
+			size = int(chunks[i%len(chunks)])
+			if size == 0 {
+				size = 1
+			}
+			if size > len(stream) {
+				size = len(stream)
+			}
+		}
+		if err := streamHandler.Read(stream[:size]); err != nil {
+			failed = true
+			break
+		}
+		stream = stream[size:]
+	}
+	close(orderBookChan)
+	<-done
+	return msgs, failed
+}
+
+// FuzzRead checks that StreamHandler.Read never panics and decodes the same msg regardless of how the stream is chunked
+func FuzzRead(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, stream []byte, chunks []byte) {
+		wholeMsgs, wholeFailed := decode(stream, nil)
+		chunkedMsgs, chunkedFailed := decode(stream, chunks)
+		if wholeFailed != chunkedFailed {
+			t.Fatalf("error depends on chunking: whole=%t chunked=%t", wholeFailed, chunkedFailed)
+		}
+		if !reflect.DeepEqual(wholeMsgs, chunkedMsgs) {
+			t.Fatalf("decoded %d msg as a whole and %d msg in chunks", len(wholeMsgs), len(chunkedMsgs))
+		}
+
+		// the FrameReader must agree with the StreamHandler on every complete frame
+		frameReader := stream_handler.NewFrameReader(fuzzConfig(), bytes.NewReader(stream))
+		for i, msg := range wholeMsgs {
+			frame, err := frameReader.Next()
+			if err != nil {
+				t.Fatalf("FrameReader failed on frame %d that StreamHandler decoded: %s", i, err)
+			}
+			if frame.Header != msg.MsgHeader || frame.MsgType != msg.MsgType {
+				t.Fatalf("FrameReader and StreamHandler disagree on frame %d", i)
+			}
+		}
+	})
+}
+
+// FuzzParseMsg checks that ParseMsg never panics and that every parsed msg encodes back into a frame that parses into the same msg
+func FuzzParseMsg(f *testing.F) {
+	for _, msgType := range []string{message.MSG_TYPE_ADDED, message.MSG_TYPE_UPDATED, message.MSG_TYPE_DELETED, message.MSG_TYPE_EXECUTED, "Z"} {
+		f.Add(msgType, bytes.Repeat([]byte{'V'}, 31))
+		f.Add(msgType, []byte{'V', 'C'})
+	}
+	f.Fuzz(func(t *testing.T, msgType string, body []byte) {
+		msg, err := stream_handler.ParseMsg(msgType, body)
+		if err != nil {
+			return
+		}
+		frame, err := stream_handler.EncodeMsg(msg)
+		if err != nil {
+			t.Fatalf("unable to encode parsed msg: %s", err)
+		}
+		// frame is header + msg type + body, the reserved bytes are not kept so only the decoded fields are compared
+		reparsed, err := stream_handler.ParseMsg(msgType, frame[9:])
+		if err != nil {
+			t.Fatalf("unable to parse encoded msg: %s", err)
+		}
+		if !reflect.DeepEqual(reparsed, msg) {
+			t.Fatalf("encoded msg %v does not parse back into %v", reparsed, msg)
+		}
+		if msg.Symbol.String() != strings.TrimRight(string(body[:3]), "\x00 ") {
+			t.Fatalf("symbol %v does not match body %v", msg.Symbol, body[:3])
+		}
+	})
+}
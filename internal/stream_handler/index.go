@@ -0,0 +1,157 @@
+package stream_handler
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// INDEX_FILE_EXT is appended to the capture path to get the sidecar index path
+const INDEX_FILE_EXT = ".idx"
+
+// Index is the sidecar index of a capture that allows random access by seq
+type Index struct {
+	HeaderLength int64              // header length the capture was indexed with
+	Size         int64              // bytes of the capture that were indexed
+	Entries      []IndexEntry       // byte offset of every frame, sorted by Seq
+	Symbols      map[string][]int64 // byte offsets of every frame of each symbol, in capture order
+}
+
+// IndexEntry maps a seq to the byte offset of its frame
+type IndexEntry struct {
+	Seq    uint32
+	Offset int64
+}
+
+// BuildIndex reads the whole capture and records the offset of every frame
+func BuildIndex(config *config.Config, input io.Reader) (*Index, error) {
+	idx := &Index{
+		HeaderLength: config.Stream.HeaderLength,
+		Symbols:      make(map[string][]int64),
+	}
+	frameReader := NewFrameReader(config, input)
+	for {
+		frame, err := frameReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to index frame %d: %w", len(idx.Entries), err)
+		}
+		idx.Entries = append(idx.Entries, IndexEntry{Seq: frame.Header.Seq, Offset: frame.Offset})
+		symbol := frame.Symbol().String()
+		idx.Symbols[symbol] = append(idx.Symbols[symbol], frame.Offset)
+	}
+	idx.Size = frameReader.offset
+	// seq is expected to be increasing already, stable sort keeps capture order for duplicated seq
+	sort.SliceStable(idx.Entries, func(i, j int) bool { return idx.Entries[i].Seq < idx.Entries[j].Seq })
+	return idx, nil
+}
+
+// Offset returns the byte offset of the first frame whose seq is greater or equal to seq
+func (i *Index) Offset(seq uint32) (int64, error) {
+	n := sort.Search(len(i.Entries), func(n int) bool { return i.Entries[n].Seq >= seq })
+	if n == len(i.Entries) {
+		return 0, fmt.Errorf("seq %d is beyond the last indexed seq", seq)
+	}
+	return i.Entries[n].Offset, nil
+}
+
+// SymbolOffsets returns the byte offsets of every frame for the symbol
+func (i *Index) SymbolOffsets(symbol message.Symbol) []int64 {
+	return i.Symbols[symbol.String()]
+}
+
+// WriteIndex encodes the index into w
+func WriteIndex(w io.Writer, idx *Index) error {
+	return gob.NewEncoder(w).Encode(idx)
+}
+
+// ReadIndex decodes an index previously written by WriteIndex
+func ReadIndex(r io.Reader) (*Index, error) {
+	var idx Index
+	if err := gob.NewDecoder(r).Decode(&idx); err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+// IndexPath returns the sidecar index path for the capture
+func IndexPath(capturePath string) string {
+	return capturePath + INDEX_FILE_EXT
+}
+
+// SaveIndex writes the index into the file at path
+func SaveIndex(path string, idx *Index) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = WriteIndex(f, idx); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadIndex reads the index from the file at path
+// returns an error if the index was built with another header length than the config
+func LoadIndex(config *config.Config, path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idx, err := ReadIndex(f)
+	if err != nil {
+		return nil, err
+	}
+	if err = idx.checkHeaderLength(config); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// checkHeaderLength returns an error if the offsets of the index do not use the framing of the config
+func (i *Index) checkHeaderLength(config *config.Config) error {
+	if i.HeaderLength != config.Stream.HeaderLength {
+		return fmt.Errorf("index was built with header length %d but the config has %d, rebuild it with the index command", i.HeaderLength, config.Stream.HeaderLength)
+	}
+	return nil
+}
+
+// OpenCaptureAt opens the capture and positions it at the frame for seq
+// the returned file can be given directly to NewStreamHandler
+// returns an error if the index is stale, i.e. it was built with another header length or for a capture of another size
+func OpenCaptureAt(config *config.Config, capturePath string, idx *Index, seq uint32) (*os.File, error) {
+	if err := idx.checkHeaderLength(config); err != nil {
+		return nil, err
+	}
+	offset, err := idx.Offset(seq)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(capturePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() != idx.Size {
+		f.Close()
+		return nil, fmt.Errorf("index covers %d bytes but the capture has %d, rebuild it with the index command", idx.Size, info.Size())
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
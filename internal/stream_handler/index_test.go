@@ -0,0 +1,128 @@
+package stream_handler
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/message"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Index", func() {
+	config := &config.Config{}
+	config.Stream.HeaderLength = 8
+	var capture bytes.Buffer
+
+	BeforeEach(func() {
+		capture.Reset()
+		WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_ADDED, MsgHeader: message.Header{Seq: 1}, MsgBody: message.MessageAdded{Symbol: message.NewSymbol("ABC"), OrderId: 1, Side: [1]byte{message.SIDE_BUY}, Size: 10, Price: 100}})
+		WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_ADDED, MsgHeader: message.Header{Seq: 2}, MsgBody: message.MessageAdded{Symbol: message.NewSymbol("XYZ"), OrderId: 2, Side: [1]byte{message.SIDE_SELL}, Size: 10, Price: 200}})
+		WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_DELETED, MsgHeader: message.Header{Seq: 3}, MsgBody: message.MessageDeleted{Symbol: message.NewSymbol("ABC"), OrderId: 1, Side: [1]byte{message.SIDE_BUY}}})
+	})
+
+	Describe("FrameReader", func() {
+		Context("with a truncated capture", func() {
+			It("should return io.ErrUnexpectedEOF for the last frame", func() {
+				frameReader := NewFrameReader(config, bytes.NewReader(capture.Bytes()[:capture.Len()-1]))
+				for i := 0; i < 2; i++ {
+					_, err := frameReader.Next()
+					Expect(err).To(BeNil())
+				}
+				_, err := frameReader.Next()
+				Expect(err).To(Equal(io.ErrUnexpectedEOF))
+			})
+		})
+	})
+
+	Describe("BuildIndex", func() {
+		Context("with a valid capture", func() {
+			It("should record the offset of every seq and symbol", func() {
+				idx, err := BuildIndex(config, bytes.NewReader(capture.Bytes()))
+				Expect(err).To(BeNil())
+				Expect(idx.Entries).To(Equal([]IndexEntry{{Seq: 1, Offset: 0}, {Seq: 2, Offset: 40}, {Seq: 3, Offset: 80}}))
+				Expect(idx.SymbolOffsets(message.NewSymbol("ABC"))).To(Equal([]int64{0, 80}))
+				Expect(idx.SymbolOffsets(message.NewSymbol("XYZ"))).To(Equal([]int64{40}))
+			})
+		})
+	})
+
+	Describe("Offset", func() {
+		Context("with a seq that is not in the index", func() {
+			It("should return the next available frame or an error past the end", func() {
+				idx := &Index{Entries: []IndexEntry{{Seq: 1, Offset: 0}, {Seq: 5, Offset: 40}}}
+				offset, err := idx.Offset(3)
+				Expect(err).To(BeNil())
+				Expect(offset).To(Equal(int64(40)))
+				_, err = idx.Offset(6)
+				Expect(err).To(Not(BeNil()))
+			})
+		})
+	})
+
+	Describe("OpenCaptureAt", func() {
+		Context("with a saved index", func() {
+			It("should position the capture at the requested seq", func() {
+				dir, err := os.MkdirTemp("", "index_test")
+				Expect(err).To(BeNil())
+				defer os.RemoveAll(dir)
+				capturePath := filepath.Join(dir, "capture.stream")
+				Expect(os.WriteFile(capturePath, capture.Bytes(), 0644)).To(Succeed())
+				f, _ := os.Open(capturePath)
+				idx, err := BuildIndex(config, f)
+				f.Close()
+				Expect(err).To(BeNil())
+				Expect(SaveIndex(IndexPath(capturePath), idx)).To(Succeed())
+
+				loaded, err := LoadIndex(config, IndexPath(capturePath))
+				Expect(err).To(BeNil())
+				Expect(loaded).To(Equal(idx))
+				f, err = OpenCaptureAt(config, capturePath, loaded, 2)
+				Expect(err).To(BeNil())
+				defer f.Close()
+				frame, err := NewFrameReader(config, f).Next()
+				Expect(err).To(BeNil())
+				Expect(frame.Header.Seq).To(Equal(uint32(2)))
+				Expect(frame.Symbol()).To(Equal(message.NewSymbol("XYZ")))
+			})
+		})
+
+		Context("with a stale index", func() {
+			var dir, capturePath string
+			var idx *Index
+
+			BeforeEach(func() {
+				var err error
+				dir, err = os.MkdirTemp("", "index_test")
+				Expect(err).To(BeNil())
+				capturePath = filepath.Join(dir, "capture.stream")
+				Expect(os.WriteFile(capturePath, capture.Bytes(), 0644)).To(Succeed())
+				idx, err = BuildIndex(config, bytes.NewReader(capture.Bytes()))
+				Expect(err).To(BeNil())
+				Expect(SaveIndex(IndexPath(capturePath), idx)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(dir)
+			})
+
+			It("should reject an index built with another header length", func() {
+				otherConfig := *config
+				otherConfig.Stream.HeaderLength = 12
+				_, err := LoadIndex(&otherConfig, IndexPath(capturePath))
+				Expect(err).To(MatchError(ContainSubstring("header length")))
+				_, err = OpenCaptureAt(&otherConfig, capturePath, idx, 2)
+				Expect(err).To(MatchError(ContainSubstring("header length")))
+			})
+
+			It("should reject an index of a capture that changed since", func() {
+				Expect(os.Truncate(capturePath, int64(capture.Len()-1))).To(Succeed())
+				_, err := OpenCaptureAt(config, capturePath, idx, 2)
+				Expect(err).To(MatchError(ContainSubstring("rebuild")))
+			})
+		})
+	})
+})
@@ -0,0 +1,395 @@
+package stream_handler
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/message"
+)
+
+// field names of the schema, size is the Header.Size in the header and the order size in a msg
+const (
+	FIELD_SEQ        = "seq"
+	FIELD_SIZE       = "size"
+	FIELD_SYMBOL     = "symbol"
+	FIELD_ORDER_ID   = "orderId"
+	FIELD_SIDE       = "side"
+	FIELD_PRICE      = "price"
+	FIELD_TRADED_QTY = "tradedQty"
+	ENDIAN_LITTLE    = "little"
+	ENDIAN_BIG       = "big"
+)
+
+// DEFAULT_HEADER_LENGTH is the header length of the default schema
+const DEFAULT_HEADER_LENGTH = 8
+
+// headerFields are the fields that the header must declare
+var headerFields = []string{FIELD_SEQ, FIELD_SIZE}
+
+// messageFields are the fields that each msg type must declare
+var messageFields = map[string][]string{
+	message.MSG_TYPE_ADDED:    {FIELD_SYMBOL, FIELD_ORDER_ID, FIELD_SIDE, FIELD_SIZE, FIELD_PRICE},
+	message.MSG_TYPE_UPDATED:  {FIELD_SYMBOL, FIELD_ORDER_ID, FIELD_SIDE, FIELD_SIZE, FIELD_PRICE},
+	message.MSG_TYPE_DELETED:  {FIELD_SYMBOL, FIELD_ORDER_ID, FIELD_SIDE},
+	message.MSG_TYPE_EXECUTED: {FIELD_SYMBOL, FIELD_ORDER_ID, FIELD_SIDE, FIELD_TRADED_QTY},
+}
+
+// defaultSchema is used by ParseMsg and EncodeMsg
+var defaultSchema = mustSchema(NewSchema(DEFAULT_HEADER_LENGTH, DefaultSchemaConfig()))
+
+// Schema is the compiled wire layout of the header and of each msg type
+type Schema struct {
+	headerLength int
+	fill         byte
+	seq          field
+	size         field
+	messages     map[string]layout
+}
+
+// layout is the body of a msg type
+type layout struct {
+	length int
+	fields map[string]field
+}
+
+// field is the position of a single field
+type field struct {
+	offset    int
+	width     int
+	bigEndian bool
+}
+
+// DefaultSchemaConfig returns the layout of the sample captures, reserved bytes are filled with spaces
+func DefaultSchemaConfig() *config.SchemaConfig {
+	fields := []config.FieldConfig{
+		{Name: FIELD_SYMBOL, Offset: 0, Width: 3},
+		{Name: FIELD_ORDER_ID, Offset: 3, Width: 8},
+		{Name: FIELD_SIDE, Offset: 11, Width: 1},
+		{Name: FIELD_SIZE, Offset: 15, Width: 8},
+		{Name: FIELD_PRICE, Offset: 23, Width: 4},
+	}
+	executed := append(fields[:3:3], config.FieldConfig{Name: FIELD_TRADED_QTY, Offset: 15, Width: 8})
+	return &config.SchemaConfig{
+		Endian: ENDIAN_LITTLE,
+		Fill:   " ",
+		Header: []config.FieldConfig{
+			{Name: FIELD_SEQ, Offset: 0, Width: 4},
+			{Name: FIELD_SIZE, Offset: 4, Width: 4},
+		},
+		Messages: []config.MessageConfig{
+			{Type: message.MSG_TYPE_ADDED, Length: 31, Fields: fields},
+			{Type: message.MSG_TYPE_UPDATED, Length: 31, Fields: fields},
+			{Type: message.MSG_TYPE_DELETED, Length: 12, Fields: fields[:3]},
+			{Type: message.MSG_TYPE_EXECUTED, Length: 23, Fields: executed},
+		},
+	}
+}
+
+// DefaultSchema returns the compiled DefaultSchemaConfig
+func DefaultSchema() *Schema {
+	return defaultSchema
+}
+
+// SchemaOf returns the schema configured in stream.schema, or the default schema when it is not configured
+func SchemaOf(config *config.Config) (*Schema, error) {
+	schemaConfig := config.Stream.Schema
+	if schemaConfig == nil {
+		schemaConfig = DefaultSchemaConfig()
+	}
+	return NewSchema(config.Stream.HeaderLength, schemaConfig)
+}
+
+// NewSchema validates the schema config and compiles it
+func NewSchema(headerLength int64, schemaConfig *config.SchemaConfig) (*Schema, error) {
+	bigEndian, err := isBigEndian(schemaConfig.Endian, false)
+	if err != nil {
+		return nil, err
+	}
+	schema := &Schema{headerLength: int(headerLength), fill: ' ', messages: make(map[string]layout)}
+	switch len(schemaConfig.Fill) {
+	case 0:
+	case 1:
+		schema.fill = schemaConfig.Fill[0]
+	default:
+		return nil, fmt.Errorf("schema fill %q must be a single byte", schemaConfig.Fill)
+	}
+
+	header, err := compileFields("header", schema.headerLength, headerFields, schemaConfig.Header, bigEndian)
+	if err != nil {
+		return nil, err
+	}
+	schema.seq, schema.size = header[FIELD_SEQ], header[FIELD_SIZE]
+
+	if len(schemaConfig.Messages) == 0 {
+		return nil, fmt.Errorf("schema has no msg type")
+	}
+	for _, messageConfig := range schemaConfig.Messages {
+		required, ok := messageFields[messageConfig.Type]
+		if !ok {
+			return nil, fmt.Errorf("unrecognized msg type %q in schema", messageConfig.Type)
+		}
+		if _, ok = schema.messages[messageConfig.Type]; ok {
+			return nil, fmt.Errorf("msg type %s is declared twice in schema", messageConfig.Type)
+		}
+		if messageConfig.Length < 1 {
+			return nil, fmt.Errorf("invalid length %d of msg type %s", messageConfig.Length, messageConfig.Type)
+		}
+		fields, err := compileFields("msg type "+messageConfig.Type, messageConfig.Length, required, messageConfig.Fields, bigEndian)
+		if err != nil {
+			return nil, err
+		}
+		schema.messages[messageConfig.Type] = layout{length: messageConfig.Length, fields: fields}
+	}
+	return schema, nil
+}
+
+// compileFields checks that exactly the required fields are declared and that they fit in length bytes
+func compileFields(name string, length int, required []string, fieldConfigs []config.FieldConfig, bigEndian bool) (map[string]field, error) {
+	fields := make(map[string]field)
+	for _, fieldConfig := range fieldConfigs {
+		if !contains(required, fieldConfig.Name) {
+			return nil, fmt.Errorf("unrecognized field %q in %s, expected %s", fieldConfig.Name, name, strings.Join(required, ", "))
+		}
+		if _, ok := fields[fieldConfig.Name]; ok {
+			return nil, fmt.Errorf("field %s is declared twice in %s", fieldConfig.Name, name)
+		}
+		maxWidth := 8
+		switch fieldConfig.Name {
+		case FIELD_SYMBOL:
+			maxWidth = message.SYMBOL_LENGTH
+		case FIELD_SIDE:
+			maxWidth = 1
+		}
+		if fieldConfig.Width < 1 || fieldConfig.Width > maxWidth {
+			return nil, fmt.Errorf("width %d of field %s in %s must be between 1 and %d", fieldConfig.Width, fieldConfig.Name, name, maxWidth)
+		}
+		if fieldConfig.Offset < 0 || fieldConfig.Offset+fieldConfig.Width > length {
+			return nil, fmt.Errorf("field %s in %s does not fit in its %d bytes", fieldConfig.Name, name, length)
+		}
+		fieldBigEndian, err := isBigEndian(fieldConfig.Endian, bigEndian)
+		if err != nil {
+			return nil, err
+		}
+		fields[fieldConfig.Name] = field{offset: fieldConfig.Offset, width: fieldConfig.Width, bigEndian: fieldBigEndian}
+	}
+	for _, fieldName := range required {
+		if _, ok := fields[fieldName]; !ok {
+			return nil, fmt.Errorf("field %s is missing in %s", fieldName, name)
+		}
+	}
+	return fields, nil
+}
+
+// isBigEndian parses the endian, an empty endian falls back to the parent one
+func isBigEndian(endian string, parent bool) (bool, error) {
+	switch endian {
+	case "":
+		return parent, nil
+	case ENDIAN_LITTLE:
+		return false, nil
+	case ENDIAN_BIG:
+		return true, nil
+	}
+	return false, fmt.Errorf("unrecognized endian %q, expected %s or %s", endian, ENDIAN_LITTLE, ENDIAN_BIG)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mustSchema panics when the schema can not be compiled, only used for the default schema
+func mustSchema(schema *Schema, err error) *Schema {
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// HeaderLength returns the number of bytes of the header
+func (s *Schema) HeaderLength() int {
+	return s.headerLength
+}
+
+// DecodeHeader unmarshall the raw header, raw must hold at least HeaderLength bytes
+func (s *Schema) DecodeHeader(raw []byte) (message.Header, error) {
+	if len(raw) < s.headerLength {
+		return message.Header{}, io.ErrUnexpectedEOF
+	}
+	seq, size := s.seq.uint(raw), s.size.uint(raw)
+	if seq > uint64(^uint32(0)) || size > uint64(^uint32(0)) {
+		return message.Header{}, fmt.Errorf("header seq %d or size %d does not fit in 32 bits", seq, size)
+	}
+	return message.Header{Seq: uint32(seq), Size: uint32(size)}, nil
+}
+
+// Decode unmarshall the body after the msg type byte into a complete Message, bytes after the msg type length are ignored
+func (s *Schema) Decode(msgType string, body []byte) (message.Message, error) {
+	layout, ok := s.messages[msgType]
+	if !ok {
+		return message.Message{}, fmt.Errorf("unrecognized message type")
+	}
+	if len(body) < layout.length {
+		return message.Message{}, fmt.Errorf("msg type %s needs %d bytes, got %d: %w", msgType, layout.length, len(body), io.ErrUnexpectedEOF)
+	}
+	fields := layout.fields
+	symbol := fields[FIELD_SYMBOL].symbol(body)
+	orderId := fields[FIELD_ORDER_ID].uint(body)
+	side := [1]byte{body[fields[FIELD_SIDE].offset]}
+
+	decodedMsg := message.Message{Symbol: symbol, MsgType: msgType}
+	switch msgType {
+	case message.MSG_TYPE_ADDED:
+		decodedMsg.MsgBody = message.MessageAdded{Symbol: symbol, OrderId: orderId, Side: side, Size: fields[FIELD_SIZE].uint(body), Price: fields[FIELD_PRICE].int(body)}
+	case message.MSG_TYPE_UPDATED:
+		decodedMsg.MsgBody = message.MessageUpdated{Symbol: symbol, OrderId: orderId, Side: side, Size: fields[FIELD_SIZE].uint(body), Price: fields[FIELD_PRICE].int(body)}
+	case message.MSG_TYPE_DELETED:
+		decodedMsg.MsgBody = message.MessageDeleted{Symbol: symbol, OrderId: orderId, Side: side}
+	case message.MSG_TYPE_EXECUTED:
+		decodedMsg.MsgBody = message.MessageExecuted{Symbol: symbol, OrderId: orderId, Side: side, TradedQty: fields[FIELD_TRADED_QTY].uint(body)}
+	}
+	return decodedMsg, nil
+}
+
+// Symbol returns the symbol of the body without decoding the rest of it
+// returns an empty symbol for an unrecognized msg type or a truncated body
+func (s *Schema) Symbol(msgType string, body []byte) message.Symbol {
+	layout, ok := s.messages[msgType]
+	if !ok || len(body) < layout.length {
+		return message.Symbol{}
+	}
+	return layout.fields[FIELD_SYMBOL].symbol(body)
+}
+
+// Encode marshall the Message into the raw Header + msg type + body frame, it is the inverse of Decode
+// Header.Size is computed from the msg type length, only Header.Seq is taken from the msg
+func (s *Schema) Encode(msg message.Message) ([]byte, error) {
+	var symbol message.Symbol
+	var side [1]byte
+	var orderId, size, tradedQty uint64
+	var price int64
+	var bodyType string
+	switch body := msg.MsgBody.(type) {
+	case message.MessageAdded:
+		bodyType, symbol, orderId, side, size, price = message.MSG_TYPE_ADDED, body.Symbol, body.OrderId, body.Side, body.Size, body.Price
+	case message.MessageUpdated:
+		bodyType, symbol, orderId, side, size, price = message.MSG_TYPE_UPDATED, body.Symbol, body.OrderId, body.Side, body.Size, body.Price
+	case message.MessageDeleted:
+		bodyType, symbol, orderId, side = message.MSG_TYPE_DELETED, body.Symbol, body.OrderId, body.Side
+	case message.MessageExecuted:
+		bodyType, symbol, orderId, side, tradedQty = message.MSG_TYPE_EXECUTED, body.Symbol, body.OrderId, body.Side, body.TradedQty
+	default:
+		return nil, fmt.Errorf("unrecognized message body %T", msg.MsgBody)
+	}
+	if msg.MsgType != bodyType {
+		return nil, fmt.Errorf("invalid message type %q for %T", msg.MsgType, msg.MsgBody)
+	}
+	layout, ok := s.messages[msg.MsgType]
+	if !ok {
+		return nil, fmt.Errorf("msg type %s is not in the schema", msg.MsgType)
+	}
+
+	frame := make([]byte, s.headerLength+1+layout.length)
+	if err := s.seq.putUint(frame, uint64(msg.MsgHeader.Seq)); err != nil {
+		return nil, fmt.Errorf("header seq: %w", err)
+	}
+	if err := s.size.putUint(frame, uint64(layout.length+1)); err != nil {
+		return nil, fmt.Errorf("header size: %w", err)
+	}
+	frame[s.headerLength] = msg.MsgType[0]
+	body := frame[s.headerLength+1:]
+	for i := range body {
+		body[i] = s.fill
+	}
+
+	fields := layout.fields
+	if err := fields[FIELD_SYMBOL].putSymbol(body, symbol, s.fill); err != nil {
+		return nil, err
+	}
+	if err := fields[FIELD_ORDER_ID].putUint(body, orderId); err != nil {
+		return nil, fmt.Errorf("%s: %w", FIELD_ORDER_ID, err)
+	}
+	body[fields[FIELD_SIDE].offset] = side[0]
+	switch msg.MsgType {
+	case message.MSG_TYPE_ADDED, message.MSG_TYPE_UPDATED:
+		if err := fields[FIELD_SIZE].putUint(body, size); err != nil {
+			return nil, fmt.Errorf("%s: %w", FIELD_SIZE, err)
+		}
+		if err := fields[FIELD_PRICE].putInt(body, price); err != nil {
+			return nil, fmt.Errorf("%s: %w", FIELD_PRICE, err)
+		}
+	case message.MSG_TYPE_EXECUTED:
+		if err := fields[FIELD_TRADED_QTY].putUint(body, tradedQty); err != nil {
+			return nil, fmt.Errorf("%s: %w", FIELD_TRADED_QTY, err)
+		}
+	}
+	return frame, nil
+}
+
+// uint reads the field as an unsigned number
+func (f field) uint(raw []byte) uint64 {
+	var value uint64
+	for i := 0; i < f.width; i++ {
+		b := uint64(raw[f.offset+i])
+		if f.bigEndian {
+			value = value<<8 | b
+		} else {
+			value |= b << (8 * i)
+		}
+	}
+	return value
+}
+
+// int reads the field as a two's complement signed number
+func (f field) int(raw []byte) int64 {
+	shift := 64 - 8*f.width
+	return int64(f.uint(raw)<<shift) >> shift
+}
+
+// symbol reads the field as a symbol, trailing spaces and zero bytes are padding
+func (f field) symbol(raw []byte) message.Symbol {
+	return message.NewSymbol(strings.TrimRight(string(raw[f.offset:f.offset+f.width]), "\x00 "))
+}
+
+// putUint writes value into the field, returns an error if it does not fit
+func (f field) putUint(raw []byte, value uint64) error {
+	if f.width < 8 && value>>(8*f.width) != 0 {
+		return fmt.Errorf("%d does not fit in %d bytes", value, f.width)
+	}
+	for i := 0; i < f.width; i++ {
+		shift := 8 * i
+		if f.bigEndian {
+			shift = 8 * (f.width - 1 - i)
+		}
+		raw[f.offset+i] = byte(value >> shift)
+	}
+	return nil
+}
+
+// putInt writes value into the field as a two's complement signed number, returns an error if it does not fit
+func (f field) putInt(raw []byte, value int64) error {
+	shift := 64 - 8*f.width
+	if value<<shift>>shift != value {
+		return fmt.Errorf("%d does not fit in %d bytes", value, f.width)
+	}
+	return f.putUint(raw, uint64(value)&(^uint64(0)>>shift))
+}
+
+// putSymbol writes the symbol into the field padded with fill, returns an error if it is longer than the field
+func (f field) putSymbol(raw []byte, symbol message.Symbol, fill byte) error {
+	s := symbol.String()
+	if len(s) > f.width {
+		return fmt.Errorf("symbol %s does not fit in %d bytes", s, f.width)
+	}
+	copy(raw[f.offset:f.offset+f.width], s)
+	for i := f.offset + len(s); i < f.offset+f.width; i++ {
+		raw[i] = fill
+	}
+	return nil
+}
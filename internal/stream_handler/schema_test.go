@@ -0,0 +1,172 @@
+package stream_handler
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/message"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// wideSchemaConfig is a big endian layout with a 12 bytes header, 8 bytes symbols and 8 bytes prices
+func wideSchemaConfig() *config.SchemaConfig {
+	fields := []config.FieldConfig{
+		{Name: FIELD_SIDE, Offset: 0, Width: 1},
+		{Name: FIELD_SYMBOL, Offset: 1, Width: 8},
+		{Name: FIELD_ORDER_ID, Offset: 9, Width: 8},
+		{Name: FIELD_SIZE, Offset: 17, Width: 4},
+		{Name: FIELD_PRICE, Offset: 21, Width: 8},
+	}
+	return &config.SchemaConfig{
+		Endian: ENDIAN_BIG,
+		Fill:   "\x00",
+		Header: []config.FieldConfig{
+			{Name: FIELD_SEQ, Offset: 0, Width: 8},
+			{Name: FIELD_SIZE, Offset: 8, Width: 2, Endian: ENDIAN_LITTLE},
+		},
+		Messages: []config.MessageConfig{
+			{Type: message.MSG_TYPE_ADDED, Length: 29, Fields: fields},
+			{Type: message.MSG_TYPE_DELETED, Length: 17, Fields: fields[:3]},
+		},
+	}
+}
+
+var _ = Describe("Schema", func() {
+	Describe("DefaultSchema", func() {
+		It("should encode the same frame as the sample captures", func() {
+			var raw bytes.Buffer
+			binary.Write(&raw, binary.LittleEndian, message.Header{Seq: 1, Size: 32})
+			raw.WriteString(message.MSG_TYPE_ADDED)
+			binary.Write(&raw, binary.LittleEndian, wireAdded{
+				Symbol:      [3]byte{'V', 'C', '0'},
+				OrderId:     7,
+				Side:        [1]byte{message.SIDE_BUY},
+				ReservedOne: [3]byte{' ', ' ', ' '},
+				Size:        100,
+				Price:       318800,
+				ReservedTwo: [4]byte{' ', ' ', ' ', ' '},
+			})
+			symbol := message.NewSymbol("VC0")
+			frame, err := DefaultSchema().Encode(message.Message{
+				Symbol:    symbol,
+				MsgType:   message.MSG_TYPE_ADDED,
+				MsgHeader: message.Header{Seq: 1},
+				MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: 7, Side: [1]byte{message.SIDE_BUY}, Size: 100, Price: 318800},
+			})
+			Expect(err).To(BeNil())
+			Expect(frame).To(Equal(raw.Bytes()))
+		})
+	})
+
+	Describe("with a configured schema", func() {
+		var schema *Schema
+		symbol := message.NewSymbol("ABCDEFGH")
+		added := message.Message{
+			Symbol:    symbol,
+			MsgType:   message.MSG_TYPE_ADDED,
+			MsgHeader: message.Header{Seq: 1 << 31},
+			MsgBody:   message.MessageAdded{Symbol: symbol, OrderId: 9, Side: [1]byte{message.SIDE_SELL}, Size: 100, Price: -(1 << 40)},
+		}
+
+		BeforeEach(func() {
+			var err error
+			schema, err = NewSchema(12, wideSchemaConfig())
+			Expect(err).To(BeNil())
+		})
+
+		It("should lay out the fields at their offsets and endianness", func() {
+			frame, err := schema.Encode(added)
+			Expect(err).To(BeNil())
+			Expect(frame).To(HaveLen(12 + 1 + 29))
+			Expect(frame[:8]).To(Equal([]byte{0, 0, 0, 0, 0x80, 0, 0, 0}))
+			Expect(frame[8:12]).To(Equal([]byte{30, 0, 0, 0}))
+			Expect(string(frame[12:14])).To(Equal("AS"))
+			Expect(string(frame[14:22])).To(Equal("ABCDEFGH"))
+			Expect(frame[34:42]).To(Equal([]byte{0xff, 0xff, 0xff, 0, 0, 0, 0, 0}))
+		})
+
+		It("should decode what it encodes", func() {
+			frame, err := schema.Encode(added)
+			Expect(err).To(BeNil())
+			header, err := schema.DecodeHeader(frame)
+			Expect(err).To(BeNil())
+			Expect(header).To(Equal(message.Header{Seq: 1 << 31, Size: 30}))
+			msg, err := schema.Decode(message.MSG_TYPE_ADDED, frame[13:])
+			Expect(err).To(BeNil())
+			msg.MsgHeader = header
+			Expect(msg.MsgHeader.Seq).To(Equal(added.MsgHeader.Seq))
+			Expect(msg.MsgBody).To(Equal(added.MsgBody))
+		})
+
+		It("should be used by the StreamHandler", func() {
+			frame, err := schema.Encode(added)
+			Expect(err).To(BeNil())
+			appConfig := &config.Config{}
+			appConfig.Stream.HeaderLength = 12
+			appConfig.Stream.Schema = wideSchemaConfig()
+			orderBookChan := make(chan message.Message, 1)
+			Expect(NewStreamHandler(appConfig, nil, orderBookChan).Read(frame)).To(Succeed())
+			msg := <-orderBookChan
+			Expect(msg.Symbol).To(Equal(symbol))
+			Expect(msg.MsgBody).To(Equal(added.MsgBody))
+		})
+
+		It("should pad short symbols and read them back without the padding", func() {
+			short := message.NewSymbol("VC0")
+			frame, err := schema.Encode(message.Message{MsgType: message.MSG_TYPE_DELETED, MsgBody: message.MessageDeleted{Symbol: short, OrderId: 1, Side: [1]byte{message.SIDE_BUY}}})
+			Expect(err).To(BeNil())
+			Expect(string(frame[14:22])).To(Equal("VC0\x00\x00\x00\x00\x00"))
+			Expect(schema.Symbol(message.MSG_TYPE_DELETED, frame[13:])).To(Equal(short))
+		})
+
+		It("should reject a msg type that is not in the schema", func() {
+			_, err := schema.Decode(message.MSG_TYPE_EXECUTED, make([]byte, 64))
+			Expect(err).To(Not(BeNil()))
+			_, err = schema.Encode(message.Message{MsgType: message.MSG_TYPE_EXECUTED, MsgBody: message.MessageExecuted{}})
+			Expect(err).To(Not(BeNil()))
+		})
+
+		It("should reject a value that does not fit in its field", func() {
+			_, err := schema.Encode(message.Message{MsgType: message.MSG_TYPE_ADDED, MsgBody: message.MessageAdded{Size: 1 << 32}})
+			Expect(err).To(Not(BeNil()))
+			_, err = DefaultSchema().Encode(message.Message{MsgType: message.MSG_TYPE_ADDED, MsgBody: message.MessageAdded{Price: 1 << 31}})
+			Expect(err).To(Not(BeNil()))
+			_, err = DefaultSchema().Encode(message.Message{MsgType: message.MSG_TYPE_DELETED, MsgBody: message.MessageDeleted{Symbol: symbol}})
+			Expect(err).To(Not(BeNil()))
+		})
+	})
+
+	Describe("NewSchema", func() {
+		invalid := []struct {
+			name         string
+			headerLength int64
+			modify       func(*config.SchemaConfig)
+		}{
+			{"header longer than the header length", 8, func(c *config.SchemaConfig) {}},
+			{"unknown endian", 12, func(c *config.SchemaConfig) { c.Endian = "middle" }},
+			{"fill of more than a byte", 12, func(c *config.SchemaConfig) { c.Fill = "ab" }},
+			{"unknown msg type", 12, func(c *config.SchemaConfig) { c.Messages[0].Type = "Z" }},
+			{"msg type declared twice", 12, func(c *config.SchemaConfig) { c.Messages[1] = c.Messages[0] }},
+			{"missing field", 12, func(c *config.SchemaConfig) { c.Messages[0].Fields = c.Messages[0].Fields[1:] }},
+			{"unknown field", 12, func(c *config.SchemaConfig) {
+				c.Messages[1].Fields = append(c.Messages[1].Fields, config.FieldConfig{Name: FIELD_PRICE, Offset: 0, Width: 4})
+			}},
+			{"field past the msg length", 12, func(c *config.SchemaConfig) { c.Messages[0].Length = 20 }},
+			{"symbol wider than SYMBOL_LENGTH", 12, func(c *config.SchemaConfig) { c.Messages[1].Fields[1].Width = 9 }},
+			{"number wider than 8 bytes", 12, func(c *config.SchemaConfig) { c.Messages[1].Fields[2].Width = 9 }},
+		}
+		for _, test := range invalid {
+			test := test
+			Context("with "+test.name, func() {
+				It("should return an error", func() {
+					schemaConfig := wideSchemaConfig()
+					test.modify(schemaConfig)
+					_, err := NewSchema(test.headerLength, schemaConfig)
+					Expect(err).To(Not(BeNil()))
+				})
+			})
+		}
+	})
+})
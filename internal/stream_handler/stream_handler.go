@@ -2,7 +2,7 @@ package stream_handler
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -10,28 +10,94 @@ import (
 	"time"
 
 	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/itch"
 	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/metrics"
+	"github.com/albertsundjaja/order_book/internal/pcap"
+	"github.com/albertsundjaja/order_book/internal/session"
 )
 
+const (
+	PROTOCOL_NATIVE = "native" // Header + msg type + body frames laid out by the schema
+	PROTOCOL_ITCH   = "itch"   // ITCH 5.0 msg each prefixed by its 2 bytes big endian length
+)
+
+const (
+	SESSION_SOUPBINTCP = "soupbintcp" // msg are the sequenced data of a SoupBinTCP server
+	SESSION_MOLDUDP64  = "moldudp64"  // msg are the blocks of MoldUDP64 packets
+)
+
+// Session delivers the msg of a session layer protocol in seq order
+type Session interface {
+	Run(ctx context.Context, deliver session.Deliver) error
+}
+
 // StreamHandler is the handler for reading stdin
 type StreamHandler struct {
 	config        *config.Config         // store app config
+	schema        *Schema                // wire layout of the header and msg
+	itch          *itch.Decoder          // set when the input is ITCH
+	itchSeq       uint32                 // number of ITCH msg read, used as the seq of the decoded msg
+	err           error                  // returned by Read when the stream config is invalid
+	timestamp     time.Time              // capture time of the packet being read, stamped on the msg it completes
 	buffer        []byte                 // store the buffer of the input stream
 	lastHeader    *message.Header        // store last fully constructed header
 	lastMsgType   string                 // store last read msg type
-	orderBookChan chan<- message.Message // channel for sending message to OrderBook
-	managerChan   chan bool              // for communicating with main routine
+	orderBookChan chan<- message.Message // channel for sending message to OrderBook, closed when Start returns
 	input         io.Reader              // where to get the input from
+	metrics       *metrics.Metrics       // counts the decoded msg and decode errors, nil when not exposed
 }
 
-func NewStreamHandler(config *config.Config, input io.Reader, managerChan chan bool, orderBookChan chan<- message.Message) *StreamHandler {
-	return &StreamHandler{
+func NewStreamHandler(config *config.Config, input io.Reader, orderBookChan chan<- message.Message) *StreamHandler {
+	streamHandler := &StreamHandler{
 		config:        config,
 		lastHeader:    nil,
 		orderBookChan: orderBookChan,
-		managerChan:   managerChan,
 		input:         input,
 	}
+	switch config.Stream.Protocol {
+	case "", PROTOCOL_NATIVE:
+		schema, err := SchemaOf(config)
+		if err != nil {
+			err = fmt.Errorf("invalid schema: %w", err)
+		}
+		streamHandler.schema, streamHandler.err = schema, err
+	case PROTOCOL_ITCH:
+		streamHandler.itch = itch.NewDecoder()
+	default:
+		streamHandler.err = fmt.Errorf("unrecognized protocol %q, expected %s or %s", config.Stream.Protocol, PROTOCOL_NATIVE, PROTOCOL_ITCH)
+	}
+	return streamHandler
+}
+
+// SetMetrics records the decoded msg and the decode errors into m, it must be called before Start
+func (s *StreamHandler) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
+// newSession returns the configured session layer, nil when the msg are read from the input
+func (s *StreamHandler) newSession() (Session, error) {
+	sessionConfig := s.config.Stream.Session
+	switch sessionConfig.Protocol {
+	case "":
+		return nil, nil
+	case SESSION_SOUPBINTCP:
+		return session.NewSoupBinClient(session.SoupBinConfig{
+			Address:    sessionConfig.Address,
+			Username:   sessionConfig.Username,
+			Password:   sessionConfig.Password,
+			Session:    sessionConfig.Session,
+			Heartbeat:  sessionConfig.Heartbeat,
+			Reconnects: sessionConfig.Reconnects,
+		}), nil
+	case SESSION_MOLDUDP64:
+		conn, err := session.ListenMold(sessionConfig.Address)
+		if err != nil {
+			return nil, fmt.Errorf("unable to listen for moldudp64 packets: %w", err)
+		}
+		return session.NewMoldClient(conn, sessionConfig.RequestAddress, sessionConfig.RetransmitTimeout, sessionConfig.FirstSeq), nil
+	}
+	return nil, fmt.Errorf("unrecognized session protocol %q, expected %s or %s", sessionConfig.Protocol, SESSION_SOUPBINTCP, SESSION_MOLDUDP64)
 }
 
 // eat returns the slice from 0:count from the buffer
@@ -47,44 +113,213 @@ func (s *StreamHandler) eat(count int64) ([]byte, error) {
 }
 
 // Start is the main process that read from stdin and parse the chunks
-func (s *StreamHandler) Start() {
-	//read the stdin in chunks
-	reader := bufio.NewReader(s.input)
-	part := make([]byte, 4096)
-	var err error
-	var count int
+// it stops reading when the input ends, the stream is corrupted or ctx is cancelled, every msg decoded so far is still sent to OrderBook
+// orderBookChan is closed when Start returns. Returns nil at the end of the input, ctx.Err() when cancelled
+func (s *StreamHandler) Start(ctx context.Context) error {
+	defer close(s.orderBookChan)
+	if s.err != nil {
+		return s.err
+	}
+	if s.config.Stream.Pcap.Destination != "" {
+		return s.readCapture(ctx)
+	}
+	sessionLayer, err := s.newSession()
+	if err != nil {
+		return err
+	}
+	if sessionLayer != nil {
+		// the session layer replaces the input
+		if err = sessionLayer.Run(ctx, s.ReadMsg); err != nil {
+			if ctx.Err() != nil {
+				log.Println("stream interrupted")
+				return ctx.Err()
+			}
+			log.Printf("error while reading the session: %s \n", err.Error())
+			return err
+		}
+		log.Println("session finished")
+		return nil
+	}
+
+	// read the stdin in chunks on its own routine, a blocked read can not be interrupted but it should not delay the shutdown
+	chunks := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(s.input)
+		for {
+			part := make([]byte, 4096)
+			count, err := reader.Read(part)
+			if count > 0 {
+				select {
+				case chunks <- part[:count]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
 	for {
-		// read until EOF
-		if count, err = reader.Read(part); err != nil {
-			break
+		select {
+		case <-ctx.Done():
+			log.Println("stream interrupted")
+			return ctx.Err()
+		case chunk := <-chunks:
+			// pass the data into our stream handler
+			if err := s.Read(chunk); err != nil {
+				log.Printf("error while parsing: %s \n", err.Error())
+				return err
+			}
+		case err := <-readErr:
+			// chunks is unbuffered, every chunk read before the error has already been parsed
+			if err != io.EOF {
+				log.Printf("error while reading: %s \n", err.Error())
+				return err
+			}
+			if len(s.buffer) > 0 || s.lastHeader != nil {
+				log.Printf("stream ended with an incomplete msg, %d bytes discarded \n", len(s.buffer))
+			}
+			log.Println("stream finished")
+			return nil
+		}
+	}
+}
+
+// readCapture reads the UDP payloads sent to the configured destination out of a pcap or pcapng capture in capture order
+// the payloads are MoldUDP64 packets when the session protocol is moldudp64, the raw stream otherwise
+func (s *StreamHandler) readCapture(ctx context.Context) error {
+	destination, err := pcap.ParseDestination(s.config.Stream.Pcap.Destination)
+	if err != nil {
+		return fmt.Errorf("invalid pcap destination: %w", err)
+	}
+	var sequencer *session.MoldSequencer
+	// there is no retransmission server for a capture, a gap still open after retransmitTimeout of capture time is skipped
+	var gapSeq uint64
+	var gapSince time.Time
+	skipTimeout := s.config.Stream.Session.RetransmitTimeout
+	if skipTimeout <= 0 {
+		skipTimeout = session.DEFAULT_RETRANSMIT_TIMEOUT
+	}
+	skip := func() error {
+		seq, count, err := sequencer.Skip(s.ReadMsg)
+		if count > 0 {
+			log.Printf("moldudp64 msg %d to %d are not in the capture, skipping them \n", seq, seq+count-1)
 		}
-		// pass the data into our stream handler
-		s.Read(part[:count])
+		return err
 	}
-	if err == io.EOF {
-		// extra time to allow OrderBook to finish (not required, but here so that the print statements are nicely ordered)
-		time.Sleep(500 * time.Microsecond)
-		log.Println("stream finished")
-	} else {
-		log.Printf("error while reading: %s \n", err.Error())
+	switch s.config.Stream.Session.Protocol {
+	case "":
+	case SESSION_MOLDUDP64:
+		sequencer = session.NewMoldSequencer(s.config.Stream.Session.FirstSeq)
+	default:
+		return fmt.Errorf("session protocol %q can not be read from a capture, expected %s", s.config.Stream.Session.Protocol, SESSION_MOLDUDP64)
+	}
+
+	// read the capture on its own routine like the chunks of the raw stream
+	packets := make(chan pcap.Packet)
+	readErr := make(chan error, 1)
+	go func() {
+		reader, err := pcap.NewReader(s.input)
+		if err != nil {
+			readErr <- err
+			return
+		}
+		for {
+			packet, err := reader.Next()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if !destination.Match(packet) {
+				continue
+			}
+			select {
+			case packets <- packet:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("stream interrupted")
+			return ctx.Err()
+		case packet := <-packets:
+			s.timestamp = packet.Timestamp
+			if sequencer == nil {
+				err = s.Read(packet.Payload)
+			} else {
+				var moldPacket session.MoldPacket
+				if moldPacket, err = session.ParseMoldPacket(packet.Payload); err == nil {
+					err = sequencer.Packet(moldPacket, s.ReadMsg)
+				}
+				if seq, count := sequencer.Gap(); err == nil && count > 0 {
+					if seq != gapSeq || gapSince.IsZero() {
+						gapSeq, gapSince = seq, packet.Timestamp
+					} else if packet.Timestamp.Sub(gapSince) >= skipTimeout {
+						err = skip()
+					}
+				}
+			}
+			if err != nil {
+				log.Printf("error while parsing: %s \n", err.Error())
+				return err
+			}
+		case err := <-readErr:
+			if err != io.EOF {
+				log.Printf("error while reading the capture: %s \n", err.Error())
+				return err
+			}
+			if sequencer != nil {
+				if err = skip(); err != nil {
+					log.Printf("error while parsing: %s \n", err.Error())
+					return err
+				}
+			} else if len(s.buffer) > 0 || s.lastHeader != nil {
+				log.Printf("capture ended with an incomplete msg, %d bytes discarded \n", len(s.buffer))
+			}
+			log.Println("capture finished")
+			return nil
+		}
 	}
-	s.managerChan <- true
+}
+
+// send stamps the msg with the capture time of the packet being read and sends it to OrderBook
+func (s *StreamHandler) send(msg message.Message) {
+	msg.Timestamp = s.timestamp
+	s.metrics.MessageDecoded(msg.MsgType)
+	s.orderBookChan <- msg
 }
 
 // Read read the raw message buffered from stdin
-func (s *StreamHandler) Read(rawMsg []byte) {
+// returns an error if the stream is corrupted, the stream can not be read any further after that
+func (s *StreamHandler) Read(rawMsg []byte) error {
+	if s.err != nil {
+		return s.err
+	}
 	s.buffer = append(s.buffer, rawMsg...)
+	if s.itch != nil {
+		return s.readItch()
+	}
 	for {
 		if s.lastHeader == nil {
-			rawHeader, err := s.eat(s.config.Stream.HeaderLength)
+			rawHeader, err := s.eat(int64(s.schema.HeaderLength()))
 			if err != nil {
 				break
 			}
 
-			var header message.Header
-			err = binary.Read(bytes.NewReader(rawHeader), binary.LittleEndian, &header)
+			header, err := s.schema.DecodeHeader(rawHeader)
 			if err != nil {
-				panic(err)
+				return s.decodeError(fmt.Errorf("unable to parse header: %w", err))
+			}
+			if err = ValidateHeader(s.config, header); err != nil {
+				return s.decodeError(err)
 			}
 			s.lastHeader = &header
 		}
@@ -93,13 +328,7 @@ func (s *StreamHandler) Read(rawMsg []byte) {
 			if err != nil {
 				break
 			}
-
-			var msgType [1]byte
-			err = binary.Read(bytes.NewReader(rawType), binary.LittleEndian, &msgType)
-			if err != nil {
-				panic(err)
-			}
-			s.lastMsgType = string(msgType[:])
+			s.lastMsgType = string(rawType)
 		}
 		if s.lastHeader != nil {
 			// remove 1 as we extracted the msg type
@@ -107,58 +336,81 @@ func (s *StreamHandler) Read(rawMsg []byte) {
 			if err != nil {
 				break
 			}
-			msg, err := ParseMsg(s.lastMsgType, body)
+			msg, err := s.schema.Decode(s.lastMsgType, body)
 			if err != nil {
-				panic(err)
+				return s.decodeError(fmt.Errorf("unable to parse msg seq %d: %w", s.lastHeader.Seq, err))
 			}
 			msg.MsgHeader = *s.lastHeader
 			s.lastHeader = nil
 			s.lastMsgType = ""
-			s.orderBookChan <- msg
+			s.send(msg)
 		}
 	}
+	return nil
 }
 
-// ParseMsg unmarshall the raw body received into a complete Message
-func ParseMsg(msgType string, msg []byte) (message.Message, error) {
-	var decodedMsg message.Message
-	decodedMsg.MsgType = msgType
-	switch msgType {
-	case message.MSG_TYPE_ADDED:
-		var msgAdded message.MessageAdded
-		err := binary.Read(bytes.NewBuffer(msg), binary.LittleEndian, &msgAdded)
+// ReadMsg reads a single msg delivered by a session layer
+// an ITCH msg takes the seq of the session, a native msg is a whole frame and keeps the seq of its header
+func (s *StreamHandler) ReadMsg(seq uint64, payload []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.itch != nil {
+		msgs, err := s.itch.Decode(uint32(seq), payload)
 		if err != nil {
-			return message.Message{}, err
+			return s.decodeError(fmt.Errorf("unable to parse itch msg %d: %w", seq, err))
 		}
-		decodedMsg.Symbol = msgAdded.Symbol
-		decodedMsg.MsgBody = msgAdded
-	case message.MSG_TYPE_UPDATED:
-		var msgUpdated message.MessageUpdated
-		err := binary.Read(bytes.NewBuffer(msg), binary.LittleEndian, &msgUpdated)
-		if err != nil {
-			return message.Message{}, err
+		for _, msg := range msgs {
+			s.send(msg)
 		}
-		decodedMsg.Symbol = msgUpdated.Symbol
-		decodedMsg.MsgBody = msgUpdated
-	case message.MSG_TYPE_DELETED:
-		var msgDeleted message.MessageDeleted
-		err := binary.Read(bytes.NewBuffer(msg), binary.LittleEndian, &msgDeleted)
-		if err != nil {
-			return message.Message{}, err
+		return nil
+	}
+	if err := s.Read(payload); err != nil {
+		return err
+	}
+	if len(s.buffer) > 0 || s.lastHeader != nil || s.lastMsgType != "" {
+		return s.decodeError(fmt.Errorf("session msg %d is not a complete frame", seq))
+	}
+	return nil
+}
+
+// readItch decodes every complete ITCH msg in the buffer, the ITCH msg do not have a seq so they are numbered from 1
+func (s *StreamHandler) readItch() error {
+	for len(s.buffer) >= 2 {
+		length := int64(binary.BigEndian.Uint16(s.buffer))
+		if int64(len(s.buffer)) < 2+length {
+			break
 		}
-		decodedMsg.Symbol = msgDeleted.Symbol
-		decodedMsg.MsgBody = msgDeleted
-	case message.MSG_TYPE_EXECUTED:
-		var msgExecuted message.MessageExecuted
-		err := binary.Read(bytes.NewBuffer(msg), binary.LittleEndian, &msgExecuted)
+		s.eat(2)
+		payload, _ := s.eat(length)
+		s.itchSeq++
+		msgs, err := s.itch.Decode(s.itchSeq, payload)
 		if err != nil {
-			return message.Message{}, err
+			return s.decodeError(fmt.Errorf("unable to parse itch msg %d: %w", s.itchSeq, err))
 		}
-		decodedMsg.Symbol = msgExecuted.Symbol
-		decodedMsg.MsgBody = msgExecuted
-	default:
-		return message.Message{}, fmt.Errorf("unrecognized message type")
+		for _, msg := range msgs {
+			s.send(msg)
+		}
+	}
+	return nil
+}
+
+// decodeError counts the input that could not be decoded and returns err
+func (s *StreamHandler) decodeError(err error) error {
+	s.metrics.DecodeError()
+	return err
+}
+
+// ValidateHeader checks that the Header.Size can hold the msg type and is not larger than the configured maximum
+func ValidateHeader(config *config.Config, header message.Header) error {
+	maxMsgLength := config.MaxMsgLength()
+	if header.Size < 1 || int64(header.Size) > maxMsgLength {
+		return fmt.Errorf("invalid msg size %d for seq %d, expected between 1 and %d", header.Size, header.Seq, maxMsgLength)
 	}
+	return nil
+}
 
-	return decodedMsg, nil
+// ParseMsg unmarshall the raw body received into a complete Message using the default schema
+func ParseMsg(msgType string, msg []byte) (message.Message, error) {
+	return defaultSchema.Decode(msgType, msg)
 }
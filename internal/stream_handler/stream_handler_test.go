@@ -2,31 +2,63 @@ package stream_handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"net"
+	"net/netip"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/itch"
 	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/metrics"
+	"github.com/albertsundjaja/order_book/internal/pcap"
+	"github.com/albertsundjaja/order_book/internal/session"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
+// wireAdded is the layout of the added and updated msg in the sample captures
+type wireAdded struct {
+	Symbol      [3]byte
+	OrderId     uint64
+	Side        [1]byte
+	ReservedOne [3]byte
+	Size        uint64
+	Price       int32
+	ReservedTwo [4]byte
+}
+
+// wireDeleted is the layout of the deleted msg in the sample captures
+type wireDeleted struct {
+	Symbol  [3]byte
+	OrderId uint64
+	Side    [1]byte
+}
+
+// wireExecuted is the layout of the executed msg in the sample captures
+type wireExecuted struct {
+	Symbol    [3]byte
+	OrderId   uint64
+	Side      [1]byte
+	Reserved  [3]byte
+	TradedQty uint64
+}
+
 var _ = Describe("StreamHandler", func() {
 	var (
 		streamHandler *StreamHandler
 	)
-	managerChan := make(chan bool)
 	orderBookChan := make(chan message.Message)
-	config := &config.Config{
-		Stream: struct {
-			HeaderLength int64 `mapstructure:"headerLength"`
-		}{
-			HeaderLength: 8,
-		},
-	}
+	config := &config.Config{}
+	config.Stream.HeaderLength = 8
 
 	BeforeEach(func() {
-		streamHandler = NewStreamHandler(config, os.Stdin, managerChan, orderBookChan)
+		streamHandler = NewStreamHandler(config, os.Stdin, orderBookChan)
 	})
 
 	Describe("eat", func() {
@@ -50,27 +82,27 @@ var _ = Describe("StreamHandler", func() {
 	Describe("ParseMsg", func() {
 		Context("with raw msg as MSG_TYPE_ADDED", func() {
 			It("should parse the message correctly", func() {
-				addMsg := message.MessageAdded{
+				addMsg := wireAdded{
 					Symbol:      [3]byte{1, 2, 3},
 					OrderId:     uint64(123),
 					Side:        [1]byte{1},
 					ReservedOne: [3]byte{1, 2, 3},
 					Size:        uint64(123),
-					Price:       int32(123),
+					Price:       int32(-123),
 					ReservedTwo: [4]byte{1, 2, 3, 4},
 				}
 				var msg bytes.Buffer
 				binary.Write(&msg, binary.LittleEndian, addMsg)
 
 				parsedMsg, err := ParseMsg(message.MSG_TYPE_ADDED, msg.Bytes())
-				_, ok := parsedMsg.MsgBody.(message.MessageAdded)
 				Expect(err).To(BeNil())
-				Expect(ok).To(BeTrue())
+				Expect(parsedMsg.Symbol).To(Equal(message.Symbol{1, 2, 3}))
+				Expect(parsedMsg.MsgBody).To(Equal(message.MessageAdded{Symbol: message.Symbol{1, 2, 3}, OrderId: 123, Side: [1]byte{1}, Size: 123, Price: -123}))
 			})
 		})
 		Context("with raw msg as MSG_TYPE_UPDATED", func() {
 			It("should parse the message correctly", func() {
-				updateMsg := message.MessageUpdated{
+				updateMsg := wireAdded{
 					Symbol:      [3]byte{1, 2, 3},
 					OrderId:     uint64(123),
 					Side:        [1]byte{1},
@@ -83,14 +115,13 @@ var _ = Describe("StreamHandler", func() {
 				binary.Write(&msg, binary.LittleEndian, updateMsg)
 
 				parsedMsg, err := ParseMsg(message.MSG_TYPE_UPDATED, msg.Bytes())
-				_, ok := parsedMsg.MsgBody.(message.MessageUpdated)
 				Expect(err).To(BeNil())
-				Expect(ok).To(BeTrue())
+				Expect(parsedMsg.MsgBody).To(Equal(message.MessageUpdated{Symbol: message.Symbol{1, 2, 3}, OrderId: 123, Side: [1]byte{1}, Size: 123, Price: 123}))
 			})
 		})
 		Context("with raw msg as MSG_TYPE_DELETED", func() {
 			It("should parse the message correctly", func() {
-				delMsg := message.MessageDeleted{
+				delMsg := wireDeleted{
 					Symbol:  [3]byte{1, 2, 3},
 					OrderId: uint64(123),
 					Side:    [1]byte{1},
@@ -99,14 +130,13 @@ var _ = Describe("StreamHandler", func() {
 				binary.Write(&msg, binary.LittleEndian, delMsg)
 
 				parsedMsg, err := ParseMsg(message.MSG_TYPE_DELETED, msg.Bytes())
-				_, ok := parsedMsg.MsgBody.(message.MessageDeleted)
 				Expect(err).To(BeNil())
-				Expect(ok).To(BeTrue())
+				Expect(parsedMsg.MsgBody).To(Equal(message.MessageDeleted{Symbol: message.Symbol{1, 2, 3}, OrderId: 123, Side: [1]byte{1}}))
 			})
 		})
 		Context("with raw msg as MSG_TYPE_EXECUTED", func() {
 			It("should parse the message correctly", func() {
-				exMsg := message.MessageExecuted{
+				exMsg := wireExecuted{
 					Symbol:    [3]byte{1, 2, 3},
 					OrderId:   uint64(123),
 					Side:      [1]byte{1},
@@ -117,14 +147,268 @@ var _ = Describe("StreamHandler", func() {
 				binary.Write(&msg, binary.LittleEndian, exMsg)
 
 				parsedMsg, err := ParseMsg(message.MSG_TYPE_EXECUTED, msg.Bytes())
-				_, ok := parsedMsg.MsgBody.(message.MessageExecuted)
 				Expect(err).To(BeNil())
-				Expect(ok).To(BeTrue())
+				Expect(parsedMsg.MsgBody).To(Equal(message.MessageExecuted{Symbol: message.Symbol{1, 2, 3}, OrderId: 123, Side: [1]byte{1}, TradedQty: 123}))
+			})
+		})
+		Context("with a truncated raw msg", func() {
+			It("should return an error", func() {
+				_, err := ParseMsg(message.MSG_TYPE_DELETED, []byte{'V', 'C', '0'})
+				Expect(err).To(Not(BeNil()))
 			})
 		})
 	})
 
 	Describe("Read", func() {
+		Context("with a msg split across chunks", func() {
+			It("should send the msg once it is complete", func() {
+				var capture bytes.Buffer
+				WriteMsg(&capture, message.Message{MsgType: message.MSG_TYPE_DELETED, MsgHeader: message.Header{Seq: 7}, MsgBody: message.MessageDeleted{Symbol: message.NewSymbol("ABC"), OrderId: 1, Side: [1]byte{message.SIDE_BUY}}})
+				raw := capture.Bytes()
+				Expect(streamHandler.Read(raw[:5])).To(Succeed())
+				Expect(streamHandler.Read(raw[5:9])).To(Succeed())
+				done := make(chan error)
+				go func() { done <- streamHandler.Read(raw[9:]) }()
+				msg := <-orderBookChan
+				Expect(<-done).To(BeNil())
+				Expect(msg.MsgHeader.Seq).To(Equal(uint32(7)))
+				Expect(msg.MsgBody).To(Equal(message.MessageDeleted{Symbol: message.NewSymbol("ABC"), OrderId: 1, Side: [1]byte{message.SIDE_BUY}}))
+			})
+		})
+		Context("with a header size of 0", func() {
+			It("should return an error instead of waiting for more data", func() {
+				var raw bytes.Buffer
+				binary.Write(&raw, binary.LittleEndian, message.Header{Seq: 1, Size: 0})
+				Expect(streamHandler.Read(raw.Bytes())).To(Not(Succeed()))
+			})
+		})
+		Context("with a header size larger than the max msg length", func() {
+			It("should return an error", func() {
+				var raw bytes.Buffer
+				binary.Write(&raw, binary.LittleEndian, message.Header{Seq: 1, Size: 1 << 31})
+				Expect(streamHandler.Read(raw.Bytes())).To(Not(Succeed()))
+			})
+		})
+		Context("with an unrecognized msg type", func() {
+			It("should return an error instead of panicking", func() {
+				var raw bytes.Buffer
+				binary.Write(&raw, binary.LittleEndian, message.Header{Seq: 1, Size: 2})
+				raw.WriteString("Z0")
+				Expect(streamHandler.Read(raw.Bytes())).To(Not(Succeed()))
+			})
+		})
+
+		Context("with the metrics set", func() {
+			It("should count the decoded msg by type and the decode errors", func() {
+				registry := prometheus.NewRegistry()
+				m := metrics.NewMetrics()
+				Expect(m.Register(registry)).To(Succeed())
+				streamHandler.SetMetrics(m)
+				var raw bytes.Buffer
+				WriteMsg(&raw, message.Message{MsgType: message.MSG_TYPE_DELETED, MsgHeader: message.Header{Seq: 1}, MsgBody: message.MessageDeleted{Symbol: message.NewSymbol("ABC"), OrderId: 1, Side: [1]byte{message.SIDE_BUY}}})
+				binary.Write(&raw, binary.LittleEndian, message.Header{Seq: 2, Size: 2})
+				raw.WriteString("Z0")
+				done := make(chan error)
+				go func() { done <- streamHandler.Read(raw.Bytes()) }()
+				<-orderBookChan
+				Expect(<-done).To(Not(Succeed()))
+
+				expected := `
+# HELP orderbook_decode_errors_total Input that could not be decoded into a msg.
+# TYPE orderbook_decode_errors_total counter
+orderbook_decode_errors_total 1
+# HELP orderbook_messages_decoded_total Msg decoded from the input by msg type.
+# TYPE orderbook_messages_decoded_total counter
+orderbook_messages_decoded_total{type="D"} 1
+`
+				Expect(testutil.GatherAndCompare(registry, strings.NewReader(expected), "orderbook_decode_errors_total", "orderbook_messages_decoded_total")).To(Succeed())
+			})
+		})
+
+		Context("with an ITCH stream split across chunks", func() {
+			It("should number the ITCH msg and send the book msg once they are complete", func() {
+				itchConfig := *config
+				itchConfig.Stream.Protocol = PROTOCOL_ITCH
+				itchHandler := NewStreamHandler(&itchConfig, nil, orderBookChan)
+				var raw bytes.Buffer
+				itch.WriteMsg(&raw, []byte{'S', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'O'})
+				itch.WriteMsg(&raw, itch.EncodeAddOrder(10, 'S', 100, "AAPL", 1725000))
+				Expect(itchHandler.Read(raw.Bytes()[:20])).To(Succeed())
+				done := make(chan error)
+				go func() { done <- itchHandler.Read(raw.Bytes()[20:]) }()
+				msg := <-orderBookChan
+				Expect(<-done).To(BeNil())
+				Expect(msg.MsgHeader.Seq).To(Equal(uint32(2)))
+				Expect(msg.MsgBody).To(Equal(message.MessageAdded{Symbol: message.NewSymbol("AAPL"), OrderId: 10, Side: [1]byte{message.SIDE_SELL}, Size: 100, Price: 1725000}))
+			})
+		})
+		Context("with an unrecognized protocol", func() {
+			It("should return an error", func() {
+				unknownConfig := *config
+				unknownConfig.Stream.Protocol = "fix"
+				Expect(NewStreamHandler(&unknownConfig, nil, orderBookChan).Read([]byte{1})).To(Not(Succeed()))
+			})
+		})
+	})
+
+	Describe("ReadMsg", func() {
+		Context("with an ITCH msg", func() {
+			It("should use the seq of the session", func() {
+				itchConfig := *config
+				itchConfig.Stream.Protocol = PROTOCOL_ITCH
+				itchHandler := NewStreamHandler(&itchConfig, nil, orderBookChan)
+				done := make(chan error)
+				go func() { done <- itchHandler.ReadMsg(42, itch.EncodeAddOrder(10, 'B', 100, "AAPL", 1725000)) }()
+				msg := <-orderBookChan
+				Expect(<-done).To(BeNil())
+				Expect(msg.MsgHeader.Seq).To(Equal(uint32(42)))
+			})
+		})
+		Context("with a native frame", func() {
+			It("should keep the seq of its header", func() {
+				var raw bytes.Buffer
+				Expect(WriteMsg(&raw, message.Message{
+					MsgHeader: message.Header{Seq: 7},
+					MsgType:   message.MSG_TYPE_DELETED,
+					MsgBody:   message.MessageDeleted{Symbol: message.NewSymbol("VC0"), OrderId: 1, Side: [1]byte{message.SIDE_BUY}},
+				})).To(Succeed())
+				done := make(chan error)
+				go func() { done <- streamHandler.ReadMsg(1, raw.Bytes()) }()
+				msg := <-orderBookChan
+				Expect(<-done).To(BeNil())
+				Expect(msg.MsgHeader.Seq).To(Equal(uint32(7)))
+			})
+		})
+		Context("with a partial native frame", func() {
+			It("should return an error", func() {
+				Expect(streamHandler.ReadMsg(1, []byte{1, 0, 0, 0, 13, 0})).To(Not(Succeed()))
+			})
+		})
+	})
 
+	Describe("Start", func() {
+		Context("with a SoupBinTCP session", func() {
+			It("should read the msg from the server instead of the input", func() {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).To(BeNil())
+				defer listener.Close()
+				go func() {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+					session.ReadSoupPacket(conn)
+					session.WriteSoupPacket(conn, session.SOUP_LOGIN_ACCEPTED, session.EncodeLoginAccepted("SESSION1", 1))
+					session.WriteSoupPacket(conn, session.SOUP_SEQUENCED_DATA, itch.EncodeAddOrder(10, 'S', 100, "AAPL", 1725000))
+					session.WriteSoupPacket(conn, session.SOUP_END_OF_SESSION, nil)
+				}()
+				soupConfig := *config
+				soupConfig.Stream.Protocol = PROTOCOL_ITCH
+				soupConfig.Stream.Session.Protocol = SESSION_SOUPBINTCP
+				soupConfig.Stream.Session.Address = listener.Addr().String()
+				soupChan := make(chan message.Message, 1)
+				Expect(NewStreamHandler(&soupConfig, bytes.NewReader([]byte{1, 2, 3}), soupChan).Start(context.Background())).To(Succeed())
+				msg := <-soupChan
+				Expect(msg.MsgHeader.Seq).To(Equal(uint32(1)))
+				Expect(msg.MsgBody).To(Equal(message.MessageAdded{Symbol: message.NewSymbol("AAPL"), OrderId: 10, Side: [1]byte{message.SIDE_SELL}, Size: 100, Price: 1725000}))
+				_, open := <-soupChan
+				Expect(open).To(BeFalse())
+			})
+		})
+		Context("with a pcap capture of the raw stream", func() {
+			It("should read the payloads sent to the destination in capture order and stamp the msg with the capture time", func() {
+				var raw bytes.Buffer
+				for seq := uint32(1); seq <= 2; seq++ {
+					Expect(WriteMsg(&raw, message.Message{
+						MsgHeader: message.Header{Seq: seq},
+						MsgType:   message.MSG_TYPE_DELETED,
+						MsgBody:   message.MessageDeleted{Symbol: message.NewSymbol("VC0"), OrderId: uint64(seq), Side: [1]byte{message.SIDE_BUY}},
+					})).To(Succeed())
+				}
+				feed := netip.MustParseAddrPort("233.54.12.111:26400")
+				source := netip.MustParseAddrPort("10.0.0.1:5000")
+				start := time.Unix(1700000000, 0)
+				var capture bytes.Buffer
+				writer, err := pcap.NewWriter(&capture)
+				Expect(err).To(BeNil())
+				// the second msg is split across two packets with a packet of another feed between them
+				Expect(writer.WritePacket(pcap.Packet{Timestamp: start, Source: source, Destination: feed, Payload: raw.Bytes()[:25]})).To(Succeed())
+				Expect(writer.WritePacket(pcap.Packet{Timestamp: start.Add(time.Second), Source: source, Destination: netip.MustParseAddrPort("233.54.12.111:26401"), Payload: []byte{1, 2, 3}})).To(Succeed())
+				Expect(writer.WritePacket(pcap.Packet{Timestamp: start.Add(2 * time.Second), Source: source, Destination: feed, Payload: raw.Bytes()[25:]})).To(Succeed())
+
+				captureConfig := *config
+				captureConfig.Stream.Pcap.Destination = feed.String()
+				captureChan := make(chan message.Message, 2)
+				Expect(NewStreamHandler(&captureConfig, &capture, captureChan).Start(context.Background())).To(Succeed())
+				first, second := <-captureChan, <-captureChan
+				Expect(first.MsgHeader.Seq).To(Equal(uint32(1)))
+				Expect(first.Timestamp.Equal(start)).To(BeTrue())
+				Expect(second.MsgHeader.Seq).To(Equal(uint32(2)))
+				Expect(second.Timestamp.Equal(start.Add(2 * time.Second))).To(BeTrue())
+			})
+		})
+		Context("with a pcap capture of MoldUDP64 packets out of order", func() {
+			It("should deliver the msg in seq order", func() {
+				feed := netip.MustParseAddrPort("233.54.12.111:26400")
+				source := netip.MustParseAddrPort("10.0.0.1:5000")
+				var capture bytes.Buffer
+				writer, err := pcap.NewWriter(&capture)
+				Expect(err).To(BeNil())
+				add := itch.EncodeAddOrder(10, 'B', 100, "AAPL", 1725000)
+				del := append([]byte{itch.MSG_TYPE_ORDER_DELETE}, make([]byte, 18)...)
+				binary.BigEndian.PutUint64(del[11:], 10)
+				Expect(writer.WritePacket(pcap.Packet{Source: source, Destination: feed, Payload: session.EncodeMoldPacket("SESSION1", 2, [][]byte{del})})).To(Succeed())
+				Expect(writer.WritePacket(pcap.Packet{Source: source, Destination: feed, Payload: session.EncodeMoldPacket("SESSION1", 1, [][]byte{add})})).To(Succeed())
+
+				captureConfig := *config
+				captureConfig.Stream.Protocol = PROTOCOL_ITCH
+				captureConfig.Stream.Session.Protocol = SESSION_MOLDUDP64
+				captureConfig.Stream.Pcap.Destination = ":26400"
+				// the session starts at 1, otherwise it would start at the seq of the first packet
+				captureConfig.Stream.Session.FirstSeq = 1
+				captureChan := make(chan message.Message, 2)
+				Expect(NewStreamHandler(&captureConfig, &capture, captureChan).Start(context.Background())).To(Succeed())
+				first, second := <-captureChan, <-captureChan
+				Expect(first.MsgType).To(Equal(message.MSG_TYPE_ADDED))
+				Expect(first.MsgHeader.Seq).To(Equal(uint32(1)))
+				Expect(second.MsgType).To(Equal(message.MSG_TYPE_DELETED))
+				Expect(second.MsgHeader.Seq).To(Equal(uint32(2)))
+			})
+		})
+		Context("with a pcap capture of MoldUDP64 packets joined mid-session with a gap", func() {
+			It("should start at the first packet and skip the gap that is never filled", func() {
+				feed := netip.MustParseAddrPort("233.54.12.111:26400")
+				source := netip.MustParseAddrPort("10.0.0.1:5000")
+				var capture bytes.Buffer
+				writer, err := pcap.NewWriter(&capture)
+				Expect(err).To(BeNil())
+				start := time.Date(2022, 1, 3, 9, 30, 0, 0, time.UTC)
+				// 7 is never sent, 9 arrives after the 1s retransmit timeout of capture time
+				for i, seq := range []uint64{5, 6, 8, 9} {
+					add := itch.EncodeAddOrder(seq, 'B', 100, "AAPL", 1725000)
+					Expect(writer.WritePacket(pcap.Packet{Timestamp: start.Add(time.Duration(i) * time.Second), Source: source, Destination: feed, Payload: session.EncodeMoldPacket("SESSION1", seq, [][]byte{add})})).To(Succeed())
+				}
+
+				captureConfig := *config
+				captureConfig.Stream.Protocol = PROTOCOL_ITCH
+				captureConfig.Stream.Session.Protocol = SESSION_MOLDUDP64
+				captureConfig.Stream.Pcap.Destination = ":26400"
+				captureChan := make(chan message.Message, 4)
+				Expect(NewStreamHandler(&captureConfig, &capture, captureChan).Start(context.Background())).To(Succeed())
+				var seqs []uint32
+				for msg := range captureChan {
+					seqs = append(seqs, msg.MsgHeader.Seq)
+				}
+				Expect(seqs).To(Equal([]uint32{5, 6, 8, 9}))
+			})
+		})
+		Context("with an unrecognized session protocol", func() {
+			It("should return an error", func() {
+				unknownConfig := *config
+				unknownConfig.Stream.Session.Protocol = "fixt"
+				Expect(NewStreamHandler(&unknownConfig, nil, make(chan message.Message)).Start(context.Background())).To(Not(Succeed()))
+			})
+		})
 	})
 })
@@ -1,53 +1,191 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/albertsundjaja/order_book/config"
-	db "github.com/albertsundjaja/order_book/internal/db/inmemory"
-	"github.com/albertsundjaja/order_book/internal/message"
-	"github.com/albertsundjaja/order_book/internal/order_book"
-	"github.com/albertsundjaja/order_book/internal/stream_handler"
+	"github.com/albertsundjaja/order_book/internal/command"
+	"github.com/albertsundjaja/order_book/internal/http_api"
+	"github.com/albertsundjaja/order_book/internal/input"
+	"github.com/albertsundjaja/order_book/pkg/orderbook"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
+const (
+	EXIT_OK     = 0   // input was fully processed
+	EXIT_ERROR  = 1   // input could not be read, parsed or applied to the order book
+	EXIT_SIGNAL = 128 // interrupted by a signal, the signal number is added e.g. 130 for SIGINT
+)
+
+// commands are the subcommands that can be given as the first argument, e.g. order_book index -input input2.stream
+var commands = map[string]func(*config.Config, []string) error{
+	"index":    command.RunIndex,
+	"dump":     command.RunDump,
+	"generate": command.RunGenerate,
+	"cost":     command.RunCost,
+}
+
 func main() {
-	depthParam := flag.Int("depth", 3, "the depth that will be printed")
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			// the usage was printed for -h, it is not a failure
+			if err := run(config.NewConfig(), os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatalf("%s failed: %s \n", os.Args[1], err.Error())
+			}
+			return
+		}
+	}
+
+	depthParam := flag.Int("depth", 0, "the depth that will be printed, overrides orderBook.depth in the config")
+	groupParam := flag.Int("group", 0, "the price increment that the printed levels are grouped into, overrides orderBook.priceGroup in the config")
+	bboParam := flag.String("bbo", "", "file to write the best buy and sell level changes to, next to the market depth on stdout")
+	tradesParam := flag.String("trades", "", "file to write the trades derived from the executions to")
+	barsParam := flag.String("bars", "", "file to write the OHLC and VWAP bars configured in bars to")
+	signalsParam := flag.String("signals", "", "file to write the imbalance, microprice and spread signals configured in signals to")
+	protocolParam := flag.String("protocol", "", "protocol of the input, native or itch, overrides stream.protocol in the config")
+	httpParam := flag.String("http", "", "address to serve the HTTP query API of the books on e.g. :8080, not served when empty")
+	metricsParam := flag.Bool("metrics", false, "serve the Prometheus metrics on /metrics of the -http address")
+	pcapParam := flag.String("pcap", "", "host:port the feed is sent to, stdin is read as a pcap or pcapng capture of it, overrides stream.pcap.destination in the config")
 	flag.Parse()
+	if *depthParam < 0 {
+		log.Fatalln("-depth must be positive, 0 uses orderBook.depth of the config")
+	}
+	if *metricsParam && *httpParam == "" {
+		log.Fatalln("-metrics needs an -http address to serve the metrics on")
+	}
 
-	config := config.NewConfig()
-	config.OrderBook.Depth = *depthParam
+	appConfig := config.NewConfig()
+	if *depthParam > 0 {
+		appConfig.OrderBook.Depth = *depthParam
+	}
+	if *groupParam > 0 {
+		appConfig.OrderBook.PriceGroup = int64(*groupParam)
+	}
+	if *protocolParam != "" {
+		appConfig.Stream.Protocol = *protocolParam
+	}
+	if *pcapParam != "" {
+		appConfig.Stream.Pcap.Destination = *pcapParam
+	}
+	// the files and globs given after the flags are read one after the other, stdin otherwise. Both can be gzip or zstd compressed
+	source := input.Decompress(os.Stdin)
+	if flag.NArg() > 0 {
+		stream, err := input.Open(appConfig, flag.Args())
+		if err != nil {
+			log.Fatalf("unable to open the input: %s \n", err.Error())
+		}
+		source = stream
+	}
 	// prepare components
-	orderManagerChan := make(chan bool)
-	streamHandlerChan := make(chan bool)
-	printChan := make(chan string)
-	commChan := make(chan message.Message)
-	db := db.NewOrderBookDb(config)
-	orderManager := order_book.NewOrderBookManager(config, orderManagerChan, commChan, printChan, db)
-	streamHandler := stream_handler.NewStreamHandler(config, os.Stdin, streamHandlerChan, commChan)
-
-	// start component routines
-	go streamHandler.Start()
-	go orderManager.ProcessMessage()
-
-	// wait for message from the components
-mainLoop:
-	for {
+	app := orderbook.NewPipeline(appConfig, source, os.Stdout)
+	// extra outputs written to their own file next to the market depth
+	extraOutputs := []struct {
+		path  string
+		write func(io.Writer)
+	}{
+		{*bboParam, app.WriteBbo},
+		{*tradesParam, app.WriteTrades},
+		{*barsParam, app.WriteBars},
+		{*signalsParam, app.WriteSignals},
+	}
+	var outputFiles []*os.File
+	for _, output := range extraOutputs {
+		if output.path == "" {
+			continue
+		}
+		file, err := os.Create(output.path)
+		if err != nil {
+			log.Fatalf("unable to create the output %s: %s \n", output.path, err.Error())
+		}
+		output.write(file)
+		outputFiles = append(outputFiles, file)
+	}
+	// depth can be changed by editing the config file while the app is running
+	config.OnChange(func(newConfig *config.Config) {
+		if *depthParam > 0 {
+			newConfig.OrderBook.Depth = *depthParam
+		}
+		log.Println("config changed, applying new depth and prices")
+		app.SetDepth(newConfig)
+	})
+
+	// stop reading the input on SIGINT/SIGTERM, what has been read is still processed and printed
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	received := make(chan os.Signal, 1)
+	go func() {
 		select {
-		case <-orderManagerChan:
-			log.Println("OrderManager sends terminate signal")
-			streamHandlerChan <- true
-			break mainLoop
-		case <-streamHandlerChan:
-			log.Println("StreamHandler sends terminate signal")
-			orderManagerChan <- true
-			break mainLoop
-		case msg := <-printChan:
-			// print the market depth
-			fmt.Print(msg)
+		case sig := <-signals:
+			// restores the default handling, a second signal kills the process without draining
+			signal.Stop(signals)
+			log.Printf("received %s, draining before shutting down, send it again to exit now \n", sig)
+			received <- sig
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// the api is stopped once the pipeline returned, the signals only stop reading the input
+	apiCtx, stopApi := context.WithCancel(context.Background())
+	apiStopped := make(chan struct{})
+	if *httpParam != "" {
+		// the server registers the depth changes of the WebSocket clients, so it is created before the pipeline runs
+		api := http_api.NewServer(appConfig, app)
+		if *metricsParam {
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+			if err := app.RegisterMetrics(registry); err != nil {
+				log.Fatalf("unable to register the metrics: %s \n", err.Error())
+			}
+			api.HandleMetrics(registry)
 		}
+		go func() {
+			defer close(apiStopped)
+			if err := api.Start(apiCtx, *httpParam); err != nil {
+				log.Printf("http api failed: %s \n", err.Error())
+			}
+		}()
+	} else {
+		close(apiStopped)
 	}
+
+	err := app.Run(ctx)
+	cancel()
+	stopApi()
+	<-apiStopped
+	// os.Exit does not run deferred calls
+	for _, file := range outputFiles {
+		file.Close()
+	}
+	source.Close()
 	log.Println("app shutting down")
+	os.Exit(exitCode(err, received))
+}
+
+// exitCode maps the result of the pipeline into the process exit code
+func exitCode(err error, received <-chan os.Signal) int {
+	select {
+	case sig := <-received:
+		if errors.Is(err, context.Canceled) {
+			if sysSig, ok := sig.(syscall.Signal); ok {
+				return EXIT_SIGNAL + int(sysSig)
+			}
+			return EXIT_SIGNAL
+		}
+	default:
+	}
+	if err != nil {
+		log.Printf("app failed: %s \n", err.Error())
+		return EXIT_ERROR
+	}
+	return EXIT_OK
 }
@@ -0,0 +1,80 @@
+package orderbook_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/albertsundjaja/order_book/pkg/orderbook"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// the specs of this file only use the exported API, as a module outside this repo would
+var _ = Describe("Public API", func() {
+	symbol := orderbook.NewSymbol("VC0")
+
+	added := func(seq uint32, orderId uint64, side byte, price int64) orderbook.Message {
+		return orderbook.Message{
+			Symbol:    symbol,
+			MsgType:   orderbook.MSG_TYPE_ADDED,
+			MsgHeader: orderbook.Header{Seq: seq},
+			MsgBody:   orderbook.MessageAdded{Symbol: symbol, OrderId: orderId, Side: [1]byte{side}, Price: price, Size: 10},
+		}
+	}
+
+	Describe("Engine", func() {
+		It("should be queried by Symbol", func() {
+			engine := orderbook.NewEngine(orderbook.DefaultConfig())
+			Expect(engine.Apply(added(1, 1, orderbook.SIDE_BUY, 100))).To(Succeed())
+			Expect(engine.Apply(added(2, 2, orderbook.SIDE_SELL, 102))).To(Succeed())
+			Expect(engine.Apply(orderbook.Message{
+				Symbol:    symbol,
+				MsgType:   orderbook.MSG_TYPE_EXECUTED,
+				MsgHeader: orderbook.Header{Seq: 3},
+				MsgBody:   orderbook.MessageExecuted{Symbol: symbol, OrderId: 2, Side: [1]byte{orderbook.SIDE_SELL}, TradedQty: 4},
+			})).To(Succeed())
+
+			buy, sell, err := engine.Depth(symbol, 1)
+			Expect(err).To(BeNil())
+			Expect(buy).To(Equal([]orderbook.Level{{Price: 100, Volume: 10}}))
+			Expect(sell).To(Equal([]orderbook.Level{{Price: 102, Volume: 6}}))
+			buckets, _, err := engine.GroupedDepth(symbol, 1, 10)
+			Expect(err).To(BeNil())
+			Expect(buckets).To(Equal([]orderbook.Bucket{{Price: 100, Volume: 10, Orders: 1}}))
+			printed, err := engine.PrintDepth(symbol, 1)
+			Expect(err).To(BeNil())
+			Expect(printed).To(Equal("[(100, 10)], [(102, 6)]"))
+			fill, err := engine.CostToFill(symbol, orderbook.SIDE_BUY, 6)
+			Expect(err).To(BeNil())
+			Expect(fill.Qty).To(Equal(uint64(6)))
+			stats, ok := engine.TradeStats(symbol)
+			Expect(ok).To(BeTrue())
+			Expect(stats.Volume).To(Equal(uint64(4)))
+			Expect(engine.Scale(symbol)).To(Equal(0))
+		})
+	})
+
+	Describe("Pipeline", func() {
+		It("should call back for the watched symbols only", func() {
+			var feed bytes.Buffer
+			for i, name := range []string{"VC0", "VC1"} {
+				other := orderbook.NewSymbol(name)
+				Expect(orderbook.Encode(&feed, orderbook.Message{
+					Symbol:    other,
+					MsgType:   orderbook.MSG_TYPE_ADDED,
+					MsgHeader: orderbook.Header{Seq: uint32(i + 1)},
+					MsgBody:   orderbook.MessageAdded{Symbol: other, OrderId: uint64(i + 1), Side: [1]byte{orderbook.SIDE_BUY}, Price: 100, Size: 1},
+				})).To(Succeed())
+			}
+			pipeline := orderbook.NewPipeline(orderbook.DefaultConfig(), &feed, io.Discard)
+			var events []orderbook.DepthEvent
+			pipeline.OnWatchedDepthChanged(1, func(s orderbook.Symbol) bool { return s == symbol }, func(event orderbook.DepthEvent) {
+				events = append(events, event)
+			})
+			Expect(pipeline.Run(context.Background())).To(Succeed())
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Symbol).To(Equal(symbol))
+		})
+	})
+})
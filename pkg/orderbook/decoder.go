@@ -0,0 +1,31 @@
+package orderbook
+
+import (
+	"io"
+
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+)
+
+// Decoder reads msg one by one from a stream in the Header + body format
+type Decoder struct {
+	frameReader *stream_handler.FrameReader
+}
+
+// NewDecoder return a Decoder reading from input
+func NewDecoder(config *Config, input io.Reader) *Decoder {
+	return &Decoder{frameReader: stream_handler.NewFrameReader(config, input)}
+}
+
+// Next returns the next decoded msg, io.EOF at the end of the stream
+func (d *Decoder) Next() (Message, error) {
+	frame, err := d.frameReader.Next()
+	if err != nil {
+		return Message{}, err
+	}
+	return d.frameReader.Parse(frame)
+}
+
+// Encode writes the msg into w in the Header + body format, it is the inverse of Next
+func Encode(w io.Writer, msg Message) error {
+	return stream_handler.WriteMsg(w, msg)
+}
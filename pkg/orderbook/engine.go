@@ -0,0 +1,84 @@
+package orderbook
+
+import (
+	"sort"
+
+	db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/order_book"
+)
+
+// Engine keeps the order book of every symbol and applies msg to them synchronously
+// it is not safe for concurrent use, Apply and the queries must be called from the same routine
+type Engine struct {
+	config  *Config
+	db      *db.OrderBookDb
+	manager *order_book.OrderBookManager
+}
+
+// NewEngine return an instance of Engine, config.OrderBook holds the default and per symbol depth of the callbacks
+func NewEngine(config *Config) *Engine {
+	db := db.NewOrderBookDb(config)
+	return &Engine{
+		config:  config,
+		db:      db,
+		manager: order_book.NewOrderBookManager(config, nil, nil, db),
+	}
+}
+
+// Apply applies the msg to the book of its symbol and calls the callbacks whose depth changed
+func (e *Engine) Apply(msg Message) error {
+	return e.manager.Apply(msg)
+}
+
+// OnDepthChanged registers fn to be called after a msg changes the top depth levels of a symbol
+// 0 means the depth configured for each symbol
+func (e *Engine) OnDepthChanged(depth int, fn func(DepthEvent)) {
+	e.manager.OnDepthChanged(depth, fn)
+}
+
+// AddListener registers a listener for the order, top of book and depth events of every symbol
+// embed NopListener to only handle some of the events
+func (e *Engine) AddListener(listener Listener) {
+	e.manager.AddListener(listener)
+}
+
+// TradeStats returns the last sale, cumulative volume and trade count of the symbol, false if it never traded
+func (e *Engine) TradeStats(symbol Symbol) (TradeStats, bool) {
+	return e.manager.TradeStats(symbol)
+}
+
+// Depth returns the top depth levels of the symbol, best price first
+func (e *Engine) Depth(symbol Symbol, depth int) (buy []Level, sell []Level, err error) {
+	return e.db.Depth(symbol, depth)
+}
+
+// GroupedDepth returns the top depth buckets of the symbol with the levels grouped into price increments, best price first
+func (e *Engine) GroupedDepth(symbol Symbol, depth int, increment int64) (buy []Bucket, sell []Bucket, err error) {
+	return e.db.GroupedDepth(symbol, depth, increment)
+}
+
+// Scale returns the decimal places of the raw prices of the symbol, configured in orderBook.scale
+func (e *Engine) Scale(symbol Symbol) int {
+	return e.manager.Scale(symbol)
+}
+
+// CostToFill walks the levels that an order of the side would consume to fill qty at once, best price first
+// side is the side of the order, a buy order consumes the sell levels. The fill qty is less than qty when the book runs out of volume
+func (e *Engine) CostToFill(symbol Symbol, side byte, qty uint64) (Fill, error) {
+	return e.db.CostToFill(symbol, side, qty)
+}
+
+// PrintDepth returns the top depth levels of the symbol in the same format as the CLI e.g. [(2, 1)], [(5, 1), (6, 1)]
+func (e *Engine) PrintDepth(symbol Symbol, depth int) (string, error) {
+	return e.db.PrintDepth(symbol, depth)
+}
+
+// Symbols returns every symbol that has received a msg, sorted
+func (e *Engine) Symbols() []string {
+	symbols := make([]string, 0)
+	for _, symbol := range e.db.Symbols() {
+		symbols = append(symbols, symbol.String())
+	}
+	sort.Strings(symbols)
+	return symbols
+}
@@ -0,0 +1,127 @@
+package orderbook
+
+import (
+	"bytes"
+	"io"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// topOfBookListener keeps the top of book events it receives
+type topOfBookListener struct {
+	NopListener
+	events []TopOfBookEvent
+}
+
+func (t *topOfBookListener) OnTopOfBookChanged(event TopOfBookEvent) {
+	t.events = append(t.events, event)
+}
+
+var _ = Describe("Engine", func() {
+	var engine *Engine
+	symbol := NewSymbol("VC0")
+
+	added := func(seq uint32, orderId uint64, side byte, price int64, size uint64) Message {
+		return Message{
+			Symbol:    symbol,
+			MsgType:   MSG_TYPE_ADDED,
+			MsgHeader: Header{Seq: seq},
+			MsgBody:   MessageAdded{Symbol: symbol, OrderId: orderId, Side: [1]byte{side}, Price: price, Size: size},
+		}
+	}
+
+	BeforeEach(func() {
+		engine = NewEngine(DefaultConfig())
+	})
+
+	Describe("Apply", func() {
+		Context("with orders on both sides", func() {
+			It("should be queryable at any depth", func() {
+				Expect(engine.Apply(added(1, 1, SIDE_BUY, 100, 5))).To(Succeed())
+				Expect(engine.Apply(added(2, 2, SIDE_BUY, 99, 3))).To(Succeed())
+				Expect(engine.Apply(added(3, 3, SIDE_SELL, 101, 7))).To(Succeed())
+
+				buy, sell, err := engine.Depth(symbol, 1)
+				Expect(err).To(BeNil())
+				Expect(buy).To(Equal([]Level{{Price: 100, Volume: 5}}))
+				Expect(sell).To(Equal([]Level{{Price: 101, Volume: 7}}))
+				printed, err := engine.PrintDepth(symbol, 5)
+				Expect(err).To(BeNil())
+				Expect(printed).To(Equal("[(100, 5), (99, 3)], [(101, 7)]"))
+				Expect(engine.Symbols()).To(Equal([]string{"VC0"}))
+			})
+		})
+
+		Context("with a depth callback", func() {
+			It("should only call back when the top levels change", func() {
+				var events []DepthEvent
+				engine.OnDepthChanged(1, func(event DepthEvent) { events = append(events, event) })
+				Expect(engine.Apply(added(1, 1, SIDE_BUY, 100, 5))).To(Succeed())
+				Expect(engine.Apply(added(2, 2, SIDE_BUY, 99, 3))).To(Succeed())
+				Expect(events).To(Equal([]DepthEvent{{Seq: 1, Symbol: symbol, Buy: []Level{{Price: 100, Volume: 5}}, Sell: []Level{}}}))
+			})
+		})
+
+		Context("with a listener", func() {
+			It("should receive the top of book changes", func() {
+				listener := &topOfBookListener{}
+				engine.AddListener(listener)
+				Expect(engine.Apply(added(1, 1, SIDE_BUY, 100, 5))).To(Succeed())
+				Expect(engine.Apply(added(2, 2, SIDE_BUY, 99, 3))).To(Succeed())
+				Expect(listener.events).To(Equal([]TopOfBookEvent{{Seq: 1, Symbol: symbol, Buy: Level{Price: 100, Volume: 5}}}))
+			})
+		})
+
+		Context("with an execution", func() {
+			It("should keep the trade stats of the symbol", func() {
+				Expect(engine.Apply(added(1, 1, SIDE_SELL, 101, 5))).To(Succeed())
+				Expect(engine.Apply(Message{Symbol: symbol, MsgType: MSG_TYPE_EXECUTED, MsgBody: MessageExecuted{Symbol: symbol, OrderId: 1, Side: [1]byte{SIDE_SELL}, TradedQty: 2}})).To(Succeed())
+				stats, ok := engine.TradeStats(symbol)
+				Expect(ok).To(BeTrue())
+				Expect(stats).To(Equal(TradeStats{LastPrice: 101, Volume: 2, Count: 1}))
+			})
+		})
+
+		Context("with an unknown order", func() {
+			It("should return an error", func() {
+				msg := Message{Symbol: symbol, MsgType: MSG_TYPE_DELETED, MsgBody: MessageDeleted{Symbol: symbol, OrderId: 1, Side: [1]byte{SIDE_BUY}}}
+				Expect(engine.Apply(msg)).To(Not(Succeed()))
+			})
+		})
+
+		Context("with a body that does not match the msg type", func() {
+			for _, msgType := range []string{MSG_TYPE_ADDED, MSG_TYPE_UPDATED, MSG_TYPE_DELETED, MSG_TYPE_EXECUTED} {
+				msgType := msgType
+				It("should return an error for "+msgType+" instead of panicking", func() {
+					msg := Message{Symbol: symbol, MsgType: msgType, MsgBody: "not a body"}
+					Expect(func() {
+						Expect(engine.Apply(msg)).To(MatchError(ContainSubstring("does not match its body")))
+					}).To(Not(Panic()))
+				})
+			}
+		})
+	})
+
+	Describe("Decoder", func() {
+		Context("with encoded msg", func() {
+			It("should decode the same msg until io.EOF", func() {
+				var stream bytes.Buffer
+				Expect(Encode(&stream, added(1, 1, SIDE_BUY, 100, 5))).To(Succeed())
+				Expect(Encode(&stream, added(2, 2, SIDE_SELL, 101, 5))).To(Succeed())
+
+				decoder := NewDecoder(DefaultConfig(), &stream)
+				msg, err := decoder.Next()
+				Expect(err).To(BeNil())
+				expected := added(1, 1, SIDE_BUY, 100, 5)
+				expected.MsgHeader.Size = 32
+				Expect(msg).To(Equal(expected))
+				msg, err = decoder.Next()
+				Expect(err).To(BeNil())
+				Expect(msg.MsgHeader.Seq).To(Equal(uint32(2)))
+				_, err = decoder.Next()
+				Expect(err).To(Equal(io.EOF))
+			})
+		})
+	})
+})
@@ -0,0 +1,78 @@
+// Package orderbook is the public API of the order book, it can be embedded by other services instead of running the binary
+//
+// Engine applies msg to the books synchronously and calls back on depth changes, Decoder reads msg from any io.Reader
+// and Pipeline runs the whole stream to depth output process that the CLI uses
+package orderbook
+
+import (
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/db"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/order_book"
+	"github.com/albertsundjaja/order_book/internal/queue"
+)
+
+// the msg and book types are aliases so that values can be passed between this package and the rest of the app
+type (
+	Config          = config.Config
+	Symbol          = message.Symbol // the fixed width symbol of the msg, built with NewSymbol
+	Message         = message.Message
+	Header          = message.Header
+	MessageAdded    = message.MessageAdded
+	MessageUpdated  = message.MessageUpdated
+	MessageDeleted  = message.MessageDeleted
+	MessageExecuted = message.MessageExecuted
+	Level           = db.Level
+	Fill            = db.Fill
+	Bucket          = db.Bucket
+	DepthEvent      = order_book.DepthEvent
+
+	Listener           = order_book.Listener
+	NopListener        = order_book.NopListener
+	OrderAddedEvent    = order_book.OrderAddedEvent
+	OrderUpdatedEvent  = order_book.OrderUpdatedEvent
+	OrderDeletedEvent  = order_book.OrderDeletedEvent
+	OrderExecutedEvent = order_book.OrderExecutedEvent
+	TopOfBookEvent     = order_book.TopOfBookEvent
+	TradeEvent         = order_book.TradeEvent
+	TradeStats         = order_book.TradeStats
+	Bar                = order_book.Bar
+	Snapshot           = order_book.Snapshot
+	Order              = db.Order
+	Stats              = db.Stats
+	Line               = queue.Line // a printed line and its symbol, sent to the outputs of Subscribe
+)
+
+const (
+	MSG_TYPE_ADDED    = message.MSG_TYPE_ADDED
+	MSG_TYPE_UPDATED  = message.MSG_TYPE_UPDATED
+	MSG_TYPE_DELETED  = message.MSG_TYPE_DELETED
+	MSG_TYPE_EXECUTED = message.MSG_TYPE_EXECUTED
+	SIDE_BUY          = message.SIDE_BUY
+	SIDE_SELL         = message.SIDE_SELL
+	HEADER_LENGTH     = 8 // length of the Header in the stream
+)
+
+// errors that callers can check with errors.Is
+var (
+	ErrRejected      = order_book.ErrRejected      // wrapped by the error of Apply for an invalid msg, the books are unchanged
+	ErrNotProcessing = order_book.ErrNotProcessing // returned by Query when the pipeline did not start
+)
+
+// DefaultConfig returns the config used when the app config file is not available
+func DefaultConfig() *Config {
+	config := &Config{}
+	config.Stream.HeaderLength = HEADER_LENGTH
+	config.OrderBook.Depth = 3
+	return config
+}
+
+// NewSymbol converts a symbol string e.g. VC0 into the Symbol of the msg, symbols longer than message.SYMBOL_LENGTH are truncated
+func NewSymbol(symbol string) Symbol {
+	return message.NewSymbol(symbol)
+}
+
+// FormatPrice renders the raw price with scale decimal places e.g. 318800 with scale 4 is 31.8800
+func FormatPrice(price int64, scale int) string {
+	return order_book.FormatPrice(price, scale)
+}
@@ -0,0 +1,13 @@
+package orderbook_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOrderbook(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Orderbook Suite")
+}
@@ -0,0 +1,282 @@
+package orderbook
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/albertsundjaja/order_book/config"
+	db "github.com/albertsundjaja/order_book/internal/db/inmemory"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/metrics"
+	"github.com/albertsundjaja/order_book/internal/order_book"
+	"github.com/albertsundjaja/order_book/internal/queue"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Pipeline reads the input stream, updates the order book and prints the market depth to the output
+//
+// shutdown follows the closed channel protocol, each stage closes its output channel when it returns:
+// StreamHandler closes commChan, OrderBookManager closes the input of printQueue and the printer returns once printQueue is drained.
+// Cancelling the context only stops reading the input, every msg decoded before that is still processed and printed
+//
+// every queue is bounded by config.Queues, commChan always blocks the decoding when full while the queues of the outputs follow their policy
+type Pipeline struct {
+	config        *config.Config
+	output        io.Writer
+	err           error            // returned by Run when the queues config is invalid
+	metrics       *metrics.Metrics // set by RegisterMetrics, nil when not exposed
+	commChan      chan message.Message
+	printQueue    *queue.Lines
+	streamHandler *stream_handler.StreamHandler
+	orderManager  *order_book.OrderBookManager
+	writers       []*outputWriter // extra outputs written next to the market depth
+}
+
+// outputWriter writes the lines of an extra output, e.g. the BBO, to its own writer
+type outputWriter struct {
+	queue  *queue.Lines // named e.g. bbo, the queue label of the metrics
+	output io.Writer
+}
+
+// NewPipeline init all the components of the pipeline
+func NewPipeline(config *config.Config, input io.Reader, output io.Writer) *Pipeline {
+	// every msg changes the book, and every trade and bar is an event rather than the latest state of its symbol
+	// so these queues can not drop or conflate
+	err := blockingQueue("stream", config.Queues.Stream)
+	if err == nil {
+		err = blockingQueue("trades", config.Queues.Trades)
+	}
+	if err == nil {
+		err = blockingQueue("bars", config.Queues.Bars)
+	}
+	commChan := make(chan message.Message, config.Queues.Stream.Capacity())
+	printQueue, printErr := newLines("print", config.Queues.Print)
+	if printErr != nil {
+		err = printErr
+	}
+	db := db.NewOrderBookDb(config)
+	return &Pipeline{
+		config:        config,
+		output:        output,
+		err:           err,
+		commChan:      commChan,
+		printQueue:    printQueue,
+		streamHandler: stream_handler.NewStreamHandler(config, input, commChan),
+		orderManager:  order_book.NewOrderBookManager(config, commChan, printQueue.In(), db),
+	}
+}
+
+// SetDepth changes the default and per symbol depth, scale and tick size while the pipeline is running
+func (p *Pipeline) SetDepth(config *config.Config) {
+	p.orderManager.SetDepth(config)
+}
+
+// Subscribe adds another output that receives the printed market depth at the given depth, it must be called before Run
+// 0 means the depth configured for each symbol. out is closed once every msg has been processed
+func (p *Pipeline) Subscribe(depth int, out chan<- Line) {
+	p.orderManager.Subscribe(depth, out)
+}
+
+// SubscribeGrouped adds another output that receives the market depth grouped into buckets of the price increment, it must be called before Run
+// depth is the number of buckets, 0 means the depth configured for each symbol. out is closed once every msg has been processed
+func (p *Pipeline) SubscribeGrouped(depth int, increment int64, out chan<- Line) {
+	p.orderManager.SubscribeGrouped(depth, increment, out)
+}
+
+// OnDepthChanged registers a callback that receives the depth levels whenever the top levels of a symbol change, it must be called before Run
+// the callback runs on the processing routine and blocks the stream while running
+func (p *Pipeline) OnDepthChanged(depth int, fn func(DepthEvent)) {
+	p.orderManager.OnDepthChanged(depth, fn)
+}
+
+// OnWatchedDepthChanged is OnDepthChanged for the symbols that watched returns true for, the levels of the other symbols are not read
+// watched runs on the processing routine before every depth change, it must be called before Run
+func (p *Pipeline) OnWatchedDepthChanged(depth int, watched func(Symbol) bool, fn func(DepthEvent)) {
+	p.orderManager.OnWatchedDepthChanged(depth, watched, fn)
+}
+
+// WriteBbo writes a line to output whenever the best buy or sell level of a symbol changes, it must be called before Run
+// e.g. 4, VC0, 318800, 4709, 318900, 360, 100, 318850 for seq, symbol, buy price, buy size, sell price, sell size, spread and mid
+func (p *Pipeline) WriteBbo(output io.Writer) {
+	p.orderManager.SubscribeBbo(p.addWriter("bbo", p.config.Queues.Bbo, output))
+}
+
+// WriteTrades writes a line to output for every trade derived from the executions, it must be called before Run
+// e.g. 12, VC0, 318800, 100, S, 2300, 7 for seq, symbol, resting price, qty, aggressor side, cumulative volume and trade count
+func (p *Pipeline) WriteTrades(output io.Writer) {
+	p.orderManager.SubscribeTrades(p.addWriter("trades", p.config.Queues.Trades, output))
+}
+
+// WriteBars writes the bars configured in config.Bars to output, in csv or json, and a summary of each symbol when the stream ends
+// it must be called before Run
+func (p *Pipeline) WriteBars(output io.Writer) {
+	p.orderManager.SubscribeBars(p.addWriter("bars", p.config.Queues.Bars, output))
+}
+
+// WriteSignals writes the imbalance, microprice and spread in ticks configured in config.Signals to output whenever the levels they use change
+// e.g. 4, VC0, 0.8582, 318814.0861, 1 for seq, symbol, imbalance, microprice and spread in ticks. It must be called before Run
+func (p *Pipeline) WriteSignals(output io.Writer) {
+	p.orderManager.SubscribeSignals(p.addWriter("signals", p.config.Queues.Signals, output))
+}
+
+// addWriter returns the channel whose lines are written to output while the pipeline runs, through a queue of the given config
+func (p *Pipeline) addWriter(name string, queueConfig config.QueueConfig, output io.Writer) chan<- Line {
+	lines, err := newLines(name, queueConfig)
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	lines.SetMetrics(p.metrics)
+	p.writers = append(p.writers, &outputWriter{queue: lines, output: output})
+	return lines.In()
+}
+
+// blockingQueue returns an error when the policy of the queue is not block
+func blockingQueue(name string, queueConfig config.QueueConfig) error {
+	if policy := queueConfig.Policy; policy != "" && policy != queue.POLICY_BLOCK {
+		return fmt.Errorf("unsupported %s queue policy %q, expected %s", name, policy, queue.POLICY_BLOCK)
+	}
+	return nil
+}
+
+// newLines returns the queue of the config, when the config is invalid it returns the error with a blocking queue
+// that keeps the pipeline usable until Run returns the error
+func newLines(name string, queueConfig config.QueueConfig) (*queue.Lines, error) {
+	lines, err := queue.NewLines(name, queueConfig.Capacity(), queueConfig.Policy)
+	if err != nil {
+		lines, _ = queue.NewLines(name, queueConfig.Capacity(), queue.POLICY_BLOCK)
+	}
+	return lines, err
+}
+
+// Query runs fn with a Snapshot of every book after the same msg, e.g. to serve the book state while the pipeline runs
+// fn blocks the stream while running. Query waits for Run to be called, after Run returned it reads the final books
+// returns ErrNotProcessing without running fn when Run failed before processing the stream, e.g. for an invalid config
+func (p *Pipeline) Query(fn func(Snapshot)) error {
+	return p.orderManager.Query(fn)
+}
+
+// RegisterMetrics registers the Prometheus metrics of the stream, the books and the queues between them to registerer
+// it must be called before Run. The size of the books is read with a Query at every scrape
+func (p *Pipeline) RegisterMetrics(registerer prometheus.Registerer) error {
+	m := metrics.NewMetrics()
+	if err := m.Register(registerer); err != nil {
+		return err
+	}
+	p.metrics = m
+	p.streamHandler.SetMetrics(m)
+	p.orderManager.SetMetrics(m)
+	p.printQueue.SetMetrics(m)
+	for _, writer := range p.writers {
+		writer.queue.SetMetrics(m)
+	}
+	queues := metrics.NewQueueCollector(func() []metrics.Queue {
+		queues := []metrics.Queue{
+			{Name: "stream", Length: len(p.commChan), Capacity: cap(p.commChan)},
+			{Name: "print", Length: p.printQueue.Len(), Capacity: p.printQueue.Capacity()},
+		}
+		for _, writer := range p.writers {
+			queues = append(queues, metrics.Queue{Name: writer.queue.Name(), Length: writer.queue.Len(), Capacity: writer.queue.Capacity()})
+		}
+		return queues
+	})
+	books := metrics.NewBookCollector(func() []metrics.BookStats {
+		var books []metrics.BookStats
+		// no book is reported when the pipeline did not start
+		p.Query(func(snapshot Snapshot) {
+			for _, symbol := range snapshot.Db.Symbols() {
+				if stats, err := snapshot.Db.Stats(symbol); err == nil {
+					books = append(books, metrics.BookStats{Symbol: symbol.String(), Stats: stats})
+				}
+			}
+		})
+		return books
+	})
+	for _, collector := range []prometheus.Collector{queues, books} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddListener registers a listener for the order, top of book and depth events of every symbol, it must be called before Run
+// the listener runs on the processing routine and blocks the stream while running
+func (p *Pipeline) AddListener(listener Listener) {
+	p.orderManager.AddListener(listener)
+}
+
+// Run starts all the components and blocks until every decoded msg has been processed and every market depth printed
+// returns the first error of the components, or ctx.Err() if the input was not read until the end
+func (p *Pipeline) Run(ctx context.Context) error {
+	if p.err != nil {
+		p.orderManager.Stop()
+		return p.err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var streamErr, managerErr error
+	writerErrs := make([]error, len(p.writers))
+	for i, writer := range p.writers {
+		wg.Add(1)
+		go writer.queue.Run()
+		go func(i int, writer *outputWriter) {
+			defer wg.Done()
+			writerErrs[i] = writer.run(cancel)
+		}(i, writer)
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		streamErr = p.streamHandler.Start(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		managerErr = p.orderManager.ProcessMessage()
+		if managerErr != nil {
+			// stop the stream and drain the msg it is still sending
+			cancel()
+			for range p.commChan {
+			}
+		}
+	}()
+
+	go p.printQueue.Run()
+	// the printer runs on this routine so that Run only returns after every line is written
+	printer := &outputWriter{queue: p.printQueue, output: p.output}
+	printErr := printer.run(cancel)
+	wg.Wait()
+
+	switch {
+	case managerErr != nil:
+		return managerErr
+	case printErr != nil:
+		return printErr
+	}
+	for _, err := range writerErrs {
+		if err != nil {
+			return err
+		}
+	}
+	return streamErr
+}
+
+// run writes the lines until the channel is closed, on error it cancels the pipeline and keeps draining
+func (w *outputWriter) run(cancel context.CancelFunc) error {
+	var writeErr error
+	for line := range w.queue.Out() {
+		if writeErr != nil {
+			continue
+		}
+		if _, writeErr = fmt.Fprint(w.output, line.Text); writeErr != nil {
+			log.Printf("unable to write output: %s \n", writeErr.Error())
+			cancel()
+		}
+	}
+	return writeErr
+}
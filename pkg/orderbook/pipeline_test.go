@@ -0,0 +1,247 @@
+package orderbook
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/albertsundjaja/order_book/config"
+	"github.com/albertsundjaja/order_book/internal/generator"
+	"github.com/albertsundjaja/order_book/internal/itch"
+	"github.com/albertsundjaja/order_book/internal/message"
+	"github.com/albertsundjaja/order_book/internal/queue"
+	"github.com/albertsundjaja/order_book/internal/stream_handler"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Pipeline", func() {
+	config := &config.Config{}
+	config.Stream.HeaderLength = 8
+	config.OrderBook.Depth = 1
+
+	Context("with a valid feed", func() {
+		It("should print the depth of every msg before returning", func() {
+			// every add on a new buy price above the previous one changes the top level
+			var feed bytes.Buffer
+			for i := 1; i <= 500; i++ {
+				stream_handler.WriteMsg(&feed, message.Message{
+					MsgType:   message.MSG_TYPE_ADDED,
+					MsgHeader: message.Header{Seq: uint32(i)},
+					MsgBody:   message.MessageAdded{Symbol: message.NewSymbol("ABC"), OrderId: uint64(i), Side: [1]byte{message.SIDE_BUY}, Size: 1, Price: int64(i)},
+				})
+			}
+			var output bytes.Buffer
+			err := NewPipeline(config, &feed, &output).Run(context.Background())
+			Expect(err).To(BeNil())
+			lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+			Expect(lines).To(HaveLen(500))
+			Expect(lines[499]).To(Equal("500, ABC, [(500, 1)], []"))
+		})
+	})
+
+	Context("with an ITCH feed", func() {
+		It("should apply the ITCH msg to the order book", func() {
+			itchConfig := *config
+			itchConfig.Stream.Protocol = stream_handler.PROTOCOL_ITCH
+			var feed bytes.Buffer
+			for _, msg := range [][]byte{
+				{'S', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'O'},
+				itch.EncodeAddOrder(10, 'B', 100, "AAPL", 1725000),
+				itch.EncodeAddOrder(11, 'S', 50, "AAPL", 1726000),
+				itch.EncodeOrderReplace(10, 12, 80, 1725500),
+				itch.EncodeOrderCancel(11, 20),
+				itch.EncodeOrderExecuted(12, 30, 1),
+			} {
+				itch.WriteMsg(&feed, msg)
+			}
+			var output bytes.Buffer
+			Expect(NewPipeline(&itchConfig, &feed, &output).Run(context.Background())).To(Succeed())
+			Expect(output.String()).To(Equal("2, AAPL, [(1725000, 100)], []\n" +
+				"3, AAPL, [(1725000, 100)], [(1726000, 50)]\n" +
+				"4, AAPL, [], [(1726000, 50)]\n" +
+				"4, AAPL, [(1725500, 80)], [(1726000, 50)]\n" +
+				"5, AAPL, [(1725500, 80)], [(1726000, 30)]\n" +
+				"6, AAPL, [(1725500, 50)], [(1726000, 30)]\n"))
+		})
+	})
+
+	Context("with a bbo output", func() {
+		It("should write the best levels next to the depth", func() {
+			var feed bytes.Buffer
+			for i, side := range []byte{message.SIDE_BUY, message.SIDE_SELL, message.SIDE_BUY} {
+				stream_handler.WriteMsg(&feed, message.Message{
+					MsgType:   message.MSG_TYPE_ADDED,
+					MsgHeader: message.Header{Seq: uint32(i + 1)},
+					MsgBody:   message.MessageAdded{Symbol: message.NewSymbol("ABC"), OrderId: uint64(i + 1), Side: [1]byte{side}, Size: 1, Price: int64(100 + i)},
+				})
+			}
+			var output, bbo bytes.Buffer
+			pipeline := NewPipeline(config, &feed, &output)
+			pipeline.WriteBbo(&bbo)
+			Expect(pipeline.Run(context.Background())).To(Succeed())
+			Expect(strings.Count(output.String(), "\n")).To(Equal(3))
+			// the second buy crosses to 102 above the 101 sell, the book does not prevent it
+			Expect(bbo.String()).To(Equal("1, ABC, 100, 1, -, -, -, -\n2, ABC, 100, 1, 101, 1, 1, 100.5\n3, ABC, 102, 1, 101, 1, -1, 101.5\n"))
+		})
+	})
+
+	Context("with a signals output", func() {
+		It("should write every signal before returning", func() {
+			var feed bytes.Buffer
+			for i := 1; i <= 500; i++ {
+				stream_handler.WriteMsg(&feed, message.Message{
+					MsgType:   message.MSG_TYPE_ADDED,
+					MsgHeader: message.Header{Seq: uint32(i)},
+					MsgBody:   message.MessageAdded{Symbol: message.NewSymbol("ABC"), OrderId: uint64(i), Side: [1]byte{message.SIDE_BUY}, Size: 1, Price: int64(i)},
+				})
+			}
+			signalsConfig := *config
+			// a queue of a single line keeps the writer behind the processing, the lines left when the stream ends must still be written
+			signalsConfig.Queues.Signals.Size = 1
+			var signals bytes.Buffer
+			pipeline := NewPipeline(&signalsConfig, &feed, io.Discard)
+			pipeline.WriteSignals(&signals)
+			Expect(pipeline.Run(context.Background())).To(Succeed())
+			lines := strings.Split(strings.TrimSuffix(signals.String(), "\n"), "\n")
+			Expect(lines).To(HaveLen(500))
+			Expect(lines[499]).To(Equal("500, ABC, 1.0000, -, -"))
+		})
+	})
+
+	Context("with the metrics registered", func() {
+		It("should count the msg and read the books at the scrape", func() {
+			var feed bytes.Buffer
+			for i, side := range []byte{message.SIDE_BUY, message.SIDE_SELL, message.SIDE_BUY} {
+				stream_handler.WriteMsg(&feed, message.Message{
+					MsgType:   message.MSG_TYPE_ADDED,
+					MsgHeader: message.Header{Seq: uint32(i + 1)},
+					MsgBody:   message.MessageAdded{Symbol: message.NewSymbol("ABC"), OrderId: uint64(i + 1), Side: [1]byte{side}, Size: 1, Price: int64(100 + i)},
+				})
+			}
+			registry := prometheus.NewRegistry()
+			metricsConfig := *config
+			metricsConfig.Queues.Bbo.Size = 16
+			pipeline := NewPipeline(&metricsConfig, &feed, io.Discard)
+			pipeline.WriteBbo(io.Discard)
+			Expect(pipeline.RegisterMetrics(registry)).To(Succeed())
+			Expect(pipeline.Run(context.Background())).To(Succeed())
+
+			expected := `
+# HELP orderbook_depth_prints_total Market depth lines sent to the outputs.
+# TYPE orderbook_depth_prints_total counter
+orderbook_depth_prints_total 3
+# HELP orderbook_messages_applied_total Msg applied to the order books by symbol.
+# TYPE orderbook_messages_applied_total counter
+orderbook_messages_applied_total{symbol="ABC"} 3
+# HELP orderbook_messages_decoded_total Msg decoded from the input by msg type.
+# TYPE orderbook_messages_decoded_total counter
+orderbook_messages_decoded_total{type="A"} 3
+# HELP orderbook_resting_orders Resting orders of the book by symbol and side.
+# TYPE orderbook_resting_orders gauge
+orderbook_resting_orders{side="buy",symbol="ABC"} 2
+orderbook_resting_orders{side="sell",symbol="ABC"} 1
+# HELP orderbook_queue_capacity Items the queue holds before its writer waits, 0 when unbuffered.
+# TYPE orderbook_queue_capacity gauge
+orderbook_queue_capacity{queue="bbo"} 16
+orderbook_queue_capacity{queue="print"} 1024
+orderbook_queue_capacity{queue="stream"} 1024
+`
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(expected),
+				"orderbook_depth_prints_total", "orderbook_messages_applied_total", "orderbook_messages_decoded_total",
+				"orderbook_resting_orders", "orderbook_queue_capacity")).To(Succeed())
+		})
+	})
+
+	Context("with an invalid queue policy", func() {
+		for _, queues := range [][2]string{{"conflate", ""}, {"", "fifo"}} {
+			queues := queues
+			It("should return the error of the "+queues[0]+queues[1]+" policy", func() {
+				queueConfig := *config
+				queueConfig.Queues.Stream.Policy = queues[0]
+				queueConfig.Queues.Print.Policy = queues[1]
+				pipeline := NewPipeline(&queueConfig, strings.NewReader(""), io.Discard)
+				err := pipeline.Run(context.Background())
+				Expect(err).To(MatchError(ContainSubstring("queue policy")))
+				// the books are never processed, a query must not wait for them
+				Expect(pipeline.Query(func(Snapshot) {})).To(Equal(ErrNotProcessing))
+			})
+		}
+
+		for _, policy := range []string{queue.POLICY_DROP_OLDEST, queue.POLICY_CONFLATE} {
+			policy := policy
+			It("should not "+policy+" the trades and bars", func() {
+				for _, name := range []string{"trades", "bars"} {
+					queueConfig := *config
+					if name == "trades" {
+						queueConfig.Queues.Trades.Policy = policy
+					} else {
+						queueConfig.Queues.Bars.Policy = policy
+					}
+					pipeline := NewPipeline(&queueConfig, strings.NewReader(""), io.Discard)
+					Expect(pipeline.Run(context.Background())).To(MatchError(ContainSubstring(name + " queue policy")))
+				}
+			})
+		}
+
+		It("should return the error of the policy of an extra output", func() {
+			queueConfig := *config
+			queueConfig.Queues.Bbo.Policy = "fifo"
+			pipeline := NewPipeline(&queueConfig, strings.NewReader(""), io.Discard)
+			pipeline.WriteBbo(io.Discard)
+			Expect(pipeline.Run(context.Background())).To(MatchError(ContainSubstring("bbo queue policy")))
+		})
+	})
+
+	Context("with a print queue conflating the depth", func() {
+		It("should print the latest depth of each symbol", func() {
+			var feed bytes.Buffer
+			for i := 1; i <= 500; i++ {
+				stream_handler.WriteMsg(&feed, message.Message{
+					MsgType:   message.MSG_TYPE_ADDED,
+					MsgHeader: message.Header{Seq: uint32(i)},
+					MsgBody:   message.MessageAdded{Symbol: message.NewSymbol("ABC"), OrderId: uint64(i), Side: [1]byte{message.SIDE_BUY}, Size: 1, Price: int64(i)},
+				})
+			}
+			queueConfig := *config
+			queueConfig.Queues.Print.Size = 1
+			queueConfig.Queues.Print.Policy = queue.POLICY_CONFLATE
+			var output bytes.Buffer
+			Expect(NewPipeline(&queueConfig, &feed, &output).Run(context.Background())).To(Succeed())
+			lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+			Expect(len(lines)).To(BeNumerically("<=", 500))
+			Expect(lines[len(lines)-1]).To(Equal("500, ABC, [(500, 1)], []"))
+		})
+	})
+
+	Context("with a msg that can not be applied to the order book", func() {
+		It("should stop and return the error", func() {
+			gen, _ := generator.NewGenerator(generator.DefaultConfig())
+			var feed bytes.Buffer
+			gen.WriteTo(&feed, 100)
+			// delete an order that was never added
+			stream_handler.WriteMsg(&feed, message.Message{
+				MsgType:   message.MSG_TYPE_DELETED,
+				MsgHeader: message.Header{Seq: 101},
+				MsgBody:   message.MessageDeleted{Symbol: message.NewSymbol("VC0"), OrderId: 1 << 60, Side: [1]byte{message.SIDE_BUY}},
+			})
+			gen.WriteTo(&feed, 100)
+			err := NewPipeline(config, &feed, io.Discard).Run(context.Background())
+			Expect(err).To(Not(BeNil()))
+		})
+	})
+
+	Context("with a cancelled context", func() {
+		It("should stop reading and return the context error", func() {
+			reader, writer := io.Pipe()
+			defer writer.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := NewPipeline(config, reader, io.Discard).Run(ctx)
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+})
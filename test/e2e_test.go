@@ -2,13 +2,12 @@ package test
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"os"
 
 	"github.com/albertsundjaja/order_book/config"
-	db "github.com/albertsundjaja/order_book/internal/db/inmemory"
-	"github.com/albertsundjaja/order_book/internal/message"
-	"github.com/albertsundjaja/order_book/internal/order_book"
-	"github.com/albertsundjaja/order_book/internal/stream_handler"
+	"github.com/albertsundjaja/order_book/pkg/orderbook"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -19,39 +18,19 @@ var _ = Describe("E2e test", func() {
 	Describe("testing with input1.stream", func() {
 		It("should print out the same output as output1.log", func() {
 			f, _ := os.Open("input1.stream")
+			defer f.Close()
 			reader := bufio.NewReader(f)
 			config := config.NewConfig()
 			config.OrderBook.Depth = 3
 
-			orderManagerChan := make(chan bool)
-			streamHandlerChan := make(chan bool)
-			printChan := make(chan string)
-			commChan := make(chan message.Message)
-			db := db.NewOrderBookDb(config)
-			orderManager := order_book.NewOrderBookManager(config, orderManagerChan, commChan, printChan, db)
-			streamHandler := stream_handler.NewStreamHandler(config, reader, streamHandlerChan, commChan)
-
-			// start component routines
-			go streamHandler.Start()
-			go orderManager.ProcessMessage()
-
-			var result string
-
-			// expect 10 message
-			counter := 0
-			for {
-				if counter == 9 {
-					break
-				}
-				msg := <-printChan
-				result += msg
-				counter += 1
-			}
-			// cleanup
-			orderManagerChan <- true
+			var result bytes.Buffer
+			app := orderbook.NewPipeline(config, reader, &result)
+			// Run only returns after the whole stream has been processed and printed
+			err := app.Run(context.Background())
+			Expect(err).To(BeNil())
 
 			expectedResult, _ := os.ReadFile("output1.log")
-			Expect(string(expectedResult)).To(Equal(result))
+			Expect(string(expectedResult)).To(Equal(result.String()))
 		})
 	})
 })